@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -12,9 +14,19 @@ import (
 func AnpApiXLHandler(c *fiber.Ctx) error {
 	// Extract query parameters from the URL
 	noskp := c.Query("noskp")
-	fsYear := c.Query("fs_year")
 	brand := c.Query("brand")
 
+	// fs_year mengikuti kalender fiskal (angka tahun, current atau previous).
+	cal, now := loadFiscalCalendar(), time.Now()
+	year, err := cal.resolveFsYear(c.Query("fs_year"), now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	fsYear := ""
+	if year > 0 {
+		fsYear = strconv.Itoa(year)
+	}
+
 	// Construct a unique cache key based on the query parameters.
 	var cacheKeyBuilder strings.Builder
 	cacheKeyBuilder.WriteString("AnpApiXLHandler") // Base prefix for this handler
@@ -52,38 +64,11 @@ func AnpApiXLHandler(c *fiber.Ctx) error {
         tp1.TotalCosting AS CostingLama, -- Aliased for clarity, avoiding duplicate 'TotalCosting'
         tp1.jnbalikkan AS JnBalikkan,
         tp1.realisasi AS Credit,
-        CASE
-            WHEN tp1.budget_type != 'on_top' THEN tp1.budget_total_tahunan
-            ELSE (select sum(budget_on_top) from [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_budget_on_top tx1 where tx1.budget_code = tp1.BudgetCode)
-        END AS Total_Budget,
-        CASE
-            WHEN tp1.budget_type != 'on_top' THEN (tp1.budget_total_tahunan - (SELECT SUM(TotalCosting)
-                                                FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal
-                                                WHERE BrandCode = t1.BrandCode AND BudgetCode = t1.BudgetCode and status_proposal != 'canceled' and Budget_type != 'on_top'
-                                                AND ProposalNumber <= t1.Number AND fs_year = tp1.fs_year))
-            ELSE ((select sum(budget_on_top) from [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_budget_on_top tx1 where tx1.budget_code = tp1.BudgetCode) - (SELECT SUM(TotalCosting)
-                                                FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal
-                                                WHERE BrandCode = t1.BrandCode AND BudgetCode = t1.BudgetCode and status_proposal != 'canceled' and Budget_type = 'on_top'
-                                                AND ProposalNumber <= t1.Number AND fs_year = tp1.fs_year))
-        END AS Balance,
-        (
-            SELECT
-            SUM(tx0.LineTotal)
-            FROM
-            [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_anp_dn_masuk tx0
-            inner JOIN [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_proposal_dn tdn1 ON tx0.numatcard = tdn1.nodn
-            WHERE
-            U_IDU_NoProposal = t1.Number or tdn1.proposalnumber = t1.number
-        ) AS DN_Dibuat,
-        (SELECT SUM(tx0.jmlbyr)
-        FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_anp_dn_dibayar tx0
-        INNER JOIN [APPSRV].[PK_ANP_DEV_QUERY].dbo.m_brand_anp tx1
-        ON tx0.kdbrand = tx1.code
-        WHERE U_pk_noproposal = t1.Number AND tglbyr IS NOT NULL
-        AND kdbrand IS NOT NULL AND tglbyr >= '2023-01-01'
-        AND tx1.BrandCode = t1.BrandCode
-        AND YEAR(tglbyr) = YEAR(t1.StartDatePeriode)
-        GROUP BY YEAR(tglbyr), kdbrand) AS DN_Dibayar,
+        -- Total_Budget dan Balance diisi dari budget ledger (budget.go)
+        NULL AS Total_Budget,
+        NULL AS Balance,
+        ` + dnDibuatSQL + ` AS DN_Dibuat,
+        ` + dnDibayarSQL + ` AS DN_Dibayar,
 
         null AS CN_Principal,
         STUFF((SELECT distinct '~~' + t6.GroupName
@@ -132,9 +117,7 @@ func AnpApiXLHandler(c *fiber.Ctx) error {
         WHERE U_IDU_NoProposal = t1.number
         ORDER BY numatcard ASC)
     ) AS NoDN,
-        isnull((
-            select max(t01.BudgetActivity) from  [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_activity t01 where t01.ActivityCode = tp1.ActivityCode and t01.BrandCode = tp1.brandcode and t01.BudgetCode = tp1.budgetcode
-        ),0) as BudgetActivity
+        NULL AS BudgetActivity
     FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_proposal t1
     left JOIN [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal tp1
     ON t1.Number = tp1.ProposalNumber and t1.[Status] != 'canceled'
@@ -148,10 +131,7 @@ func AnpApiXLHandler(c *fiber.Ctx) error {
 
 	// Add condition for 'noskp' if present in the URL query.
 	if noskp != "" {
-		conditions = append(conditions, `not exists(
-			select * from [appsrv].pk_anp_dev_query.dbo.tb_proposal_skp t1x
-			where (t1x.status_skp != 'canceled' or t1x.status_skp is null) and t1x.proposalnumber = t1.Number
-		) and t1.startdateperiode >= '2024-01-01' and DATEADD(DAY, 5, t2.approvedDate) < getdate()`)
+		conditions = append(conditions, proposalWithoutActiveSkp+` and t1.startdateperiode >= FISCAL_HISTORY_FROM and DATEADD(DAY, 5, t2.approvedDate) < getdate()`)
 	}
 
 	if brand != "" {
@@ -160,11 +140,13 @@ func AnpApiXLHandler(c *fiber.Ctx) error {
 		paramIndex += 2
 	}
 
-	// Add condition for 'fs_year' if present.
+	// Add condition for 'fs_year' if present: fs_year operating atau tanggal
+	// dibuat di dalam tahun fiskal tersebut.
 	if fsYear != "" {
-		conditions = append(conditions, fmt.Sprintf("(tp1.fs_year LIKE @p%d OR CONVERT(VARCHAR, t1.CreatedDate, 120) LIKE @p%d)", paramIndex, paramIndex+1))
-		params = append(params, "%"+fsYear+"%", "%"+fsYear+"%")
-		paramIndex += 2
+		fy := cal.Year(year)
+		conditions = append(conditions, fmt.Sprintf("(tp1.fs_year = @p%d OR (t1.CreatedDate >= @p%d AND t1.CreatedDate < @p%d))", paramIndex, paramIndex+1, paramIndex+2))
+		params = append(params, fsYear, fy.Start.Format("2006-01-02"), fy.Until().Format("2006-01-02"))
+		paramIndex += 3
 	}
 
 	// If there are any conditions, append them to the base SQL query with "AND".
@@ -174,6 +156,7 @@ func AnpApiXLHandler(c *fiber.Ctx) error {
 
 	// Append the ORDER BY clause.
 	baseSQL += " ORDER BY t1.CreatedDate DESC;"
+	baseSQL = cal.expandFiscalSQLFor(baseSQL, now, year)
 
 	// Define the desired column order for the HTML table explicitly.
 	// This MUST match the aliases used in your SQL SELECT statement.
@@ -209,5 +192,6 @@ func AnpApiXLHandler(c *fiber.Ctx) error {
 
 	// Pass the constructed query, cache key, the desiredOrder slice, and parameters
 	// to the GenericHtmlQueryHandler for caching and execution.
+	params = append(params, proposalBudgetColumns("Number", "Total_Budget", "Balance", "BudgetActivity"))
 	return GenericHtmlQueryHandler(c, cacheKey, baseSQL, desiredOrder, params...)
 }
\ No newline at end of file
@@ -2,16 +2,28 @@ package handlers
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
 )
 
 func AnpBudgetSisaHandler(c *fiber.Ctx) error {
 	// Extract query parameters from the URL
-	fsYear := c.Query("fs_year")
 	brand := c.Query("brand")
 
+	// fs_year mengikuti kalender fiskal (angka tahun, current atau previous).
+	year, err := loadFiscalCalendar().resolveFsYear(c.Query("fs_year"), time.Now())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	fsYear := ""
+	if year > 0 {
+		fsYear = strconv.Itoa(year)
+	}
+
 	// Construct a unique cache key based on the query parameters.
 	var cacheKeyBuilder strings.Builder
 	cacheKeyBuilder.WriteString("AnpBudgetSisaHandler") // Base prefix for this handler
@@ -40,38 +52,11 @@ func AnpBudgetSisaHandler(c *fiber.Ctx) error {
 		mpr.no AS Number,
 		mpr.promo_name AS Activity,
 		ISNULL((SELECT top 1 BrandName from [APPSRV].[PK_ANP_DEV_QUERY].dbo.m_brand where BrandCode = bb.BrandCode), '') AS Brand,
-		ISNULL((
-			SELECT max(toa.BudgetActivity)
-			FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_activity toa
-			WHERE toa.ActivityCode = mpr.id
-				AND toa.BrandCode = bb.BrandCode
-				AND toa.BudgetCode = bb.BudgetCode
-		), 0) AS Awal,
-		ISNULL((
-			SELECT SUM(topr.TotalCosting)
-			FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal topr
-			WHERE topr.ActivityCode = mpr.id
-				AND topr.BrandCode = bb.BrandCode
-				AND topr.BudgetCode = bb.BudgetCode
-				AND topr.status_proposal != 'canceled'
-		), 0) AS Terpakai,
-		(
-			ISNULL((
-				SELECT max(toa.BudgetActivity)
-				FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_activity toa
-				WHERE toa.ActivityCode = mpr.id
-					AND toa.BrandCode = bb.BrandCode
-					AND toa.BudgetCode = bb.BudgetCode
-			), 0) -
-			ISNULL((
-				SELECT SUM(topr.TotalCosting)
-				FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal topr
-				WHERE topr.ActivityCode = mpr.id
-					AND topr.BrandCode = bb.BrandCode
-					AND topr.BudgetCode = bb.BudgetCode
-					AND topr.status_proposal != 'canceled'
-			), 0)
-		) AS Sisa,
+		-- Awal, Terpakai dan Sisa diisi dari budget ledger (budget.go)
+		NULL AS Awal,
+		NULL AS Terpakai,
+		NULL AS Sisa,
+		mpr.id AS ActivityCode,
 		bb.BrandCode,
 		ISNULL((SELECT top 1 Pic from [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_pic_brand where BrandCode = bb.BrandCode and pic not like '%regis%' and pic not like '%deyan%'), '') AS Pic,
 		bb.BudgetCode,
@@ -89,16 +74,10 @@ func AnpBudgetSisaHandler(c *fiber.Ctx) error {
 		'32' AS Number,
 		'ON TOP' AS Activity,
 		tb.BrandName AS Brand,
-		ISNULL((
-			SELECT SUM(tp2.budget_on_top) FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_budget_on_top tp2 WHERE tp2.budget_code = tp.budget_code
-		), 0) AS Awal,
-		ISNULL((
-			SELECT SUM(tp2.TotalCosting) FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal tp2 WHERE tp2.BudgetCode = tp.budget_code AND tp2.Budget_type = 'on_top'
-		), 0) AS Terpakai,
-		ISNULL((
-			(SELECT SUM(tp2.budget_on_top) FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_budget_on_top tp2 WHERE tp2.budget_code = tp.budget_code) -
-			(SELECT SUM(tp2.TotalCosting) FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating_proposal tp2 WHERE tp2.BudgetCode = tp.budget_code AND tp2.Budget_type = 'on_top')
-		), 0) AS Sisa,
+		NULL AS Awal,
+		NULL AS Terpakai,
+		NULL AS Sisa,
+		NULL AS ActivityCode,
 		tp.brand_code AS BrandCode,
 		ISNULL((
 			SELECT TOP 1 tp2.Pic FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_pic_brand tp2 WHERE tp2.BrandCode = tp.brand_code AND (tp2.Pic NOT LIKE '%regis%' AND tp2.Pic NOT LIKE '%deya%' AND tp2.Pic NOT LIKE '%triana%' AND tp2.Pic NOT LIKE '%rara%')
@@ -132,13 +111,13 @@ func AnpBudgetSisaHandler(c *fiber.Ctx) error {
 	// Add condition for 'fs_year' if present.
 	if fsYear != "" {
 		// Condition for the first part of the UNION ALL
-		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating top_filter WHERE top_filter.BrandCode = bb.BrandCode AND top_filter.BudgetCode = bb.BudgetCode AND CAST(top_filter.fs_year AS VARCHAR) LIKE @p%d)", paramIndex))
-		params = append(params, "%"+fsYear+"%")
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating top_filter WHERE top_filter.BrandCode = bb.BrandCode AND top_filter.BudgetCode = bb.BudgetCode AND top_filter.fs_year = @p%d)", paramIndex))
+		params = append(params, fsYear)
 		paramIndex++
 
 		// Condition for the second part (ON TOP) of the UNION ALL
-		onTopConditions = append(onTopConditions, fmt.Sprintf("EXISTS (SELECT 1 FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating top_filter WHERE top_filter.BudgetCode = tp.budget_code AND CAST(top_filter.fs_year AS VARCHAR) LIKE @p%d)", paramIndex))
-		params = append(params, "%"+fsYear+"%")
+		onTopConditions = append(onTopConditions, fmt.Sprintf("EXISTS (SELECT 1 FROM [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_operating top_filter WHERE top_filter.BudgetCode = tp.budget_code AND top_filter.fs_year = @p%d)", paramIndex))
+		params = append(params, fsYear)
 		paramIndex++
 	}
 
@@ -161,5 +140,30 @@ func AnpBudgetSisaHandler(c *fiber.Ctx) error {
 	}
 
 	// Pass the 'desiredOrder' slice as the fourth argument.
+	params = append(params, budgetSisaColumns)
 	return GenericHtmlQueryHandler(c, cacheKey, finalSQL, desiredOrder, params...)
-}
\ No newline at end of file
+}
+
+// budgetSisaColumns mengisi Awal, Terpakai dan Sisa tiap baris dari budget
+// ledger: baris ON TOP memakai budget on_top, baris lain budget activity.
+var budgetSisaColumns QueryResultHook = func(src sqlAuditSource, rows []map[string]interface{}) error {
+	codes := make([]string, 0, len(rows))
+	for _, r := range rows {
+		codes = append(codes, rowString(r, "BudgetCode"))
+	}
+	ledger, err := loadBudgetLedger(src, db.GetDB(), codes)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		var b BudgetBalance
+		if r["ActivityCode"] == nil {
+			b, _ = ledger.Pool(budgetTypeOnTop, "", rowString(r, "BudgetCode"))
+		} else {
+			activity, _ := strconv.Atoi(rowString(r, "ActivityCode"))
+			b, _ = ledger.Activity(rowString(r, "BrandCode"), rowString(r, "BudgetCode"), activity)
+		}
+		r["Awal"], r["Terpakai"], r["Sisa"] = b.Initial, b.Committed, b.Remaining
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+	"my-fiber-app/db"
+)
+
+// Toleransi rekonsiliasi DN/CN. Selisih dianggap wajar bila tidak melebihi
+// nilai terbesar antara toleransi absolut dan persentase dari costing.
+const (
+	anpReconToleranceEnv    = "ANP_RECON_TOLERANCE"
+	anpReconTolerancePctEnv = "ANP_RECON_TOLERANCE_PCT"
+
+	defaultAnpReconTolerance    = 1000
+	defaultAnpReconTolerancePct = 1
+)
+
+// Kode alasan selisih rekonsiliasi.
+const (
+	reconClaimOverCosting     = "CLAIM_OVER_COSTING"     // DN + CN melebihi costing
+	reconRealisasiOverCosting = "REALISASI_OVER_COSTING" // realisasi melebihi costing
+	reconClaimVsRealisasi     = "CLAIM_VS_REALISASI"     // DN + CN tidak sama dengan realisasi
+	reconPaidOverDN           = "PAID_OVER_DN"           // DN dibayar melebihi DN dibuat
+	reconPaidWithoutDN        = "PAID_WITHOUT_DN"        // ada pembayaran tanpa DN masuk
+	reconUnpaidClosed         = "UNPAID_CLOSED"          // proposal closed tapi DN belum lunas
+)
+
+// reconReasonText adalah keterangan kode alasan untuk finance.
+var reconReasonText = map[string]string{
+	reconClaimOverCosting:     "Klaim (DN + CN) melebihi costing",
+	reconRealisasiOverCosting: "Realisasi melebihi costing",
+	reconClaimVsRealisasi:     "Klaim (DN + CN) berbeda dengan realisasi",
+	reconPaidOverDN:           "DN dibayar melebihi DN dibuat",
+	reconPaidWithoutDN:        "Ada pembayaran tanpa DN masuk",
+	reconUnpaidClosed:         "Proposal closed tapi DN belum lunas",
+}
+
+// ProposalReconciliation adalah posisi DN/CN satu proposal beserta hasil cek.
+type ProposalReconciliation struct {
+	Number    string   `json:"number"`
+	BrandCode string   `json:"brand_code"`
+	Brand     string   `json:"brand"`
+	Status    string   `json:"status"`
+	Costing   float64  `json:"costing"`
+	Realisasi float64  `json:"realisasi"`
+	DNIn      float64  `json:"dn_dibuat"`
+	DNPaid    float64  `json:"dn_dibayar"`
+	CN        float64  `json:"cn"`
+	Claimed   float64  `json:"claimed"`
+	Tolerance float64  `json:"tolerance"`
+	Reasons   []string `json:"reasons"`
+}
+
+// reconTolerance menentukan toleransi yang berlaku untuk costing tertentu.
+type reconTolerance struct {
+	Abs float64
+	Pct float64
+}
+
+func (t reconTolerance) For(costing float64) float64 {
+	return math.Max(t.Abs, math.Abs(costing)*t.Pct/100)
+}
+
+// reconcileProposal mengisi Claimed, Tolerance dan Reasons dari angka yang sudah dimuat.
+func reconcileProposal(r *ProposalReconciliation, tol reconTolerance) {
+	r.Claimed = r.DNIn + r.CN
+	r.Tolerance = tol.For(r.Costing)
+	r.Reasons = []string{}
+	over := func(a, b float64) bool { return a-b > r.Tolerance }
+
+	if over(r.Claimed, r.Costing) {
+		r.Reasons = append(r.Reasons, reconClaimOverCosting)
+	}
+	if over(r.Realisasi, r.Costing) {
+		r.Reasons = append(r.Reasons, reconRealisasiOverCosting)
+	}
+	if (r.Claimed > 0 || r.Realisasi > 0) && math.Abs(r.Claimed-r.Realisasi) > r.Tolerance {
+		r.Reasons = append(r.Reasons, reconClaimVsRealisasi)
+	}
+	if r.DNIn == 0 && r.DNPaid > r.Tolerance {
+		r.Reasons = append(r.Reasons, reconPaidWithoutDN)
+	} else if over(r.DNPaid, r.DNIn) {
+		r.Reasons = append(r.Reasons, reconPaidOverDN)
+	}
+	if strings.EqualFold(r.Status, proposalStatusClosed) && over(r.DNIn, r.DNPaid) {
+		r.Reasons = append(r.Reasons, reconUnpaidClosed)
+	}
+}
+
+// reconFilter adalah filter query rekonsiliasi.
+type reconFilter struct {
+	FsYear    int
+	BrandCode string
+	Status    string
+}
+
+// loadProposalReconciliation memuat costing, realisasi, DN dan CN per proposal.
+// DN dibuat dan dibayar memakai subquery yang sama dengan daftar proposal (proposaldn.go).
+func loadProposalReconciliation(src sqlAuditSource, database *sql.DB, f reconFilter) ([]ProposalReconciliation, error) {
+	var args boundArgs
+	var conditions []string
+	if f.FsYear > 0 {
+		conditions = append(conditions, "t2.fs_year = "+args.bind(f.FsYear))
+	}
+	if f.BrandCode != "" {
+		conditions = append(conditions, "t1.BrandCode = "+args.bind(f.BrandCode))
+	}
+	if f.Status != "" {
+		conditions = append(conditions, "LOWER(t2.status_proposal) = "+args.bind(strings.ToLower(f.Status)))
+	} else {
+		conditions = append(conditions, "LOWER(ISNULL(t2.status_proposal, '')) <> 'canceled'")
+	}
+
+	query := `
+		SELECT t1.Number, ISNULL(t1.BrandCode, ''), ISNULL(mb.BrandName, ''), ISNULL(t2.status_proposal, ''),
+			ISNULL(ISNULL(t2.costing_lama, t2.TotalCosting), 0),
+			ISNULL(t2.realisasi, 0),
+			ISNULL(` + dnDibuatSQL + `, 0),
+			ISNULL(` + dnDibayarSQL + `, 0),
+			ISNULL((SELECT SUM(cn.Credit) FROM tb_anp_cn_potongan cn WHERE cn.u_idu_noproposal = t1.Number), 0)
+		FROM tb_proposal t1
+		INNER JOIN tb_operating_proposal t2 ON t2.ProposalNumber = t1.[Number]
+		LEFT JOIN m_brand mb ON mb.BrandCode = t1.BrandCode
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY t1.Number`
+
+	var out []ProposalReconciliation
+	query = loadFiscalCalendar().expandFiscalSQLFor(query, time.Now(), f.FsYear)
+	err := auditedQuery(src, database, query, args, func(rows *sql.Rows) error {
+		var r ProposalReconciliation
+		if err := rows.Scan(&r.Number, &r.BrandCode, &r.Brand, &r.Status, &r.Costing, &r.Realisasi, &r.DNIn, &r.DNPaid, &r.CN); err != nil {
+			return err
+		}
+		out = append(out, r)
+		return nil
+	})
+	return out, err
+}
+
+// AnpReconciliationHandler membandingkan costing, realisasi, DN dibuat, DN
+// dibayar dan CN per proposal. Query: fs_year (default tahun fiskal berjalan), brand,
+// status, tolerance, tolerance_pct, all=1 untuk menampilkan juga proposal yang
+// cocok, format=xlsx untuk ekspor finance.
+func AnpReconciliationHandler(c *fiber.Ctx) error {
+	f := reconFilter{
+		BrandCode: strings.TrimSpace(c.Query("brand")),
+		Status:    strings.TrimSpace(c.Query("status")),
+	}
+	cal, now := loadFiscalCalendar(), time.Now()
+	y, err := cal.resolveFsYear(c.Query("fs_year"), now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: err.Error()})
+	}
+	if f.FsYear = y; f.FsYear == 0 {
+		f.FsYear = cal.Current(now).Year
+	}
+	tol := reconTolerance{
+		Abs: float64(envInt(anpReconToleranceEnv, defaultAnpReconTolerance)),
+		Pct: float64(envInt(anpReconTolerancePctEnv, defaultAnpReconTolerancePct)),
+	}
+	for _, p := range []struct {
+		name string
+		dst  *float64
+	}{{"tolerance", &tol.Abs}, {"tolerance_pct", &tol.Pct}} {
+		if v := c.Query(p.name); v != "" {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil || n < 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid " + p.name + "."})
+			}
+			*p.dst = n
+		}
+	}
+	showAll := c.Query("all") == "1"
+
+	rows, err := loadProposalReconciliation(auditSourceFromCtx(c), db.GetDB(), f)
+	if err != nil {
+		log.Printf("Error loading proposal reconciliation: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to load reconciliation."})
+	}
+
+	result := make([]ProposalReconciliation, 0, len(rows))
+	summary := map[string]int{}
+	for i := range rows {
+		reconcileProposal(&rows[i], tol)
+		for _, code := range rows[i].Reasons {
+			summary[code]++
+		}
+		if showAll || len(rows[i].Reasons) > 0 {
+			result = append(result, rows[i])
+		}
+	}
+
+	if strings.EqualFold(c.Query("format"), "xlsx") {
+		f, err := buildReconciliationWorkbook(result, summary, tol)
+		if err != nil {
+			log.Printf("Error building reconciliation workbook: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to build export")
+		}
+		defer f.Close()
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			log.Printf("Error writing reconciliation workbook: %v", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to build export")
+		}
+		c.Attachment(fmt.Sprintf("rekonsiliasi_anp_%s.xlsx", time.Now().Format("20060102_150405")))
+		return c.Send(buf.Bytes())
+	}
+
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"checked":   len(rows),
+		"count":     len(result),
+		"tolerance": tol,
+		"summary":   summary,
+		"data":      result,
+	})
+}
+
+var reconExportHeaders = []string{
+	"No Proposal", "Brand", "Status", "Costing", "Realisasi", "DN Dibuat", "DN Dibayar",
+	"CN", "Klaim (DN+CN)", "Selisih Klaim-Realisasi", "Toleransi", "Kode Alasan", "Keterangan",
+}
+
+// buildReconciliationWorkbook menyusun sheet detail dan sheet ringkasan kode alasan.
+func buildReconciliationWorkbook(rows []ProposalReconciliation, summary map[string]int, tol reconTolerance) (*excelize.File, error) {
+	const detailSheet, summarySheet = "Rekonsiliasi", "Ringkasan"
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", detailSheet); err != nil {
+		return nil, err
+	}
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"305496"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	numFmt := "#,##0"
+	numStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: &numFmt})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, h := range reconExportHeaders {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(detailSheet, cell, h)
+	}
+	last, _ := excelize.CoordinatesToCellName(len(reconExportHeaders), 1)
+	f.SetCellStyle(detailSheet, "A1", last, headerStyle)
+
+	for i, r := range rows {
+		texts := make([]string, 0, len(r.Reasons))
+		for _, code := range r.Reasons {
+			texts = append(texts, reconReasonText[code])
+		}
+		values := []interface{}{
+			r.Number, valueOr(r.Brand, r.BrandCode), strings.ToUpper(r.Status), r.Costing, r.Realisasi, r.DNIn, r.DNPaid,
+			r.CN, r.Claimed, r.Claimed - r.Realisasi, r.Tolerance, strings.Join(r.Reasons, ", "), strings.Join(texts, "; "),
+		}
+		row := i + 2
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		if err := f.SetSheetRow(detailSheet, cell, &values); err != nil {
+			return nil, err
+		}
+		from, _ := excelize.CoordinatesToCellName(4, row)
+		to, _ := excelize.CoordinatesToCellName(11, row)
+		f.SetCellStyle(detailSheet, from, to, numStyle)
+	}
+	f.SetColWidth(detailSheet, "A", "C", 16)
+	f.SetColWidth(detailSheet, "D", "K", 15)
+	f.SetColWidth(detailSheet, "L", "M", 40)
+	f.SetPanes(detailSheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+
+	if _, err := f.NewSheet(summarySheet); err != nil {
+		return nil, err
+	}
+	f.SetSheetRow(summarySheet, "A1", &[]interface{}{"Kode Alasan", "Keterangan", "Jumlah Proposal"})
+	f.SetCellStyle(summarySheet, "A1", "C1", headerStyle)
+	codes := make([]string, 0, len(reconReasonText))
+	for code := range reconReasonText {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for i, code := range codes {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		f.SetSheetRow(summarySheet, cell, &[]interface{}{code, reconReasonText[code], summary[code]})
+	}
+	note, _ := excelize.CoordinatesToCellName(1, len(codes)+3)
+	f.SetCellValue(summarySheet, note, fmt.Sprintf("Toleransi: maks(Rp %s, %g%% dari costing)", formatRupiah(tol.Abs), tol.Pct))
+	f.SetColWidth(summarySheet, "A", "A", 26)
+	f.SetColWidth(summarySheet, "B", "B", 42)
+	f.SetColWidth(summarySheet, "C", "C", 16)
+	return f, nil
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReconcileProposal(t *testing.T) {
+	tol := reconTolerance{Abs: 1000, Pct: 1}
+	cases := []struct {
+		name string
+		in   ProposalReconciliation
+		want []string
+	}{
+		{"matched", ProposalReconciliation{Costing: 1000000, Realisasi: 900000, DNIn: 895000, DNPaid: 895000}, []string{}},
+		{"within pct tolerance", ProposalReconciliation{Costing: 1000000, Realisasi: 1009000, DNIn: 1000000, DNPaid: 1000000}, []string{}},
+		{"claim over costing", ProposalReconciliation{Costing: 100000, Realisasi: 100000, DNIn: 80000, CN: 40000, DNPaid: 80000},
+			[]string{reconClaimOverCosting, reconClaimVsRealisasi}},
+		{"paid without dn", ProposalReconciliation{Costing: 100000, DNPaid: 50000}, []string{reconPaidWithoutDN}},
+		{"paid over dn", ProposalReconciliation{Costing: 100000, Realisasi: 50000, DNIn: 50000, DNPaid: 60000}, []string{reconPaidOverDN}},
+		{"closed unpaid", ProposalReconciliation{Status: "CLOSED", Costing: 100000, Realisasi: 50000, DNIn: 50000, DNPaid: 10000}, []string{reconUnpaidClosed}},
+	}
+	for _, tc := range cases {
+		r := tc.in
+		reconcileProposal(&r, tol)
+		if !reflect.DeepEqual(r.Reasons, tc.want) {
+			t.Errorf("%s: reasons = %v, want %v", tc.name, r.Reasons, tc.want)
+		}
+	}
+}
+
+func TestBuildReconciliationWorkbook(t *testing.T) {
+	rows := []ProposalReconciliation{{Number: "P1", Brand: "A", Costing: 10, Reasons: []string{reconPaidOverDN}}}
+	f, err := buildReconciliationWorkbook(rows, map[string]int{reconPaidOverDN: 1}, reconTolerance{Abs: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if v, _ := f.GetCellValue("Rekonsiliasi", "L2"); v != reconPaidOverDN {
+		t.Errorf("reason cell = %q", v)
+	}
+}
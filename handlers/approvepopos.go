@@ -68,8 +68,8 @@ func ApproveOrderHandler(c *fiber.Ctx) error {
 	}
 
 	// Execute the UPDATE query.
-	// db.DB.Exec is used for queries that do not return rows (like INSERT, UPDATE, DELETE).
-	result, err := db.DB.Exec(query, params...)
+	// auditedExec runs db.DB.Exec (for INSERT, UPDATE, DELETE) and records it in the SQL audit trail.
+	result, err := auditedExec(auditSourceFromCtx(c), db.DB, query, params...)
 	if err != nil {
 		log.Printf("Error updating order %s: %v", noOrder, err)
 		// Return a JSON error response if the database query fails.
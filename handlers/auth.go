@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adminTokenEnv is the environment variable holding the shared secret for
+// admin-only endpoints. When it is empty every admin check fails.
+const adminTokenEnv = "ADMIN_TOKEN"
+
+// userTokenSecretEnv holds the HMAC key for user tokens (X-User-Token). The
+// login application requests a token from /admin/usertoken after it has
+// checked the password; when the key is empty every user token is rejected.
+const (
+	userTokenSecretEnv  = "USER_TOKEN_SECRET"
+	defaultUserTokenTTL = 12 * time.Hour
+)
+
+// requestUser returns the user code the caller identifies itself with: the
+// verified user token when present, otherwise the X-User-Code header or the
+// user_code / user_code_kam query parameter older pages still send. The
+// fallback is spoofable, so use it for filters and audit only; authorisation
+// must use authenticatedUser.
+func requestUser(c *fiber.Ctx) string {
+	if u := authenticatedUser(c); u != "" {
+		return u
+	}
+	if u := strings.TrimSpace(c.Get("X-User-Code")); u != "" {
+		return u
+	}
+	for _, key := range []string{"user_code", "user_code_kam", "username"} {
+		if u := strings.TrimSpace(c.Query(key)); u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// isAdminRequest reports whether the request carries the admin token in the
+// X-Admin-Token header.
+func isAdminRequest(c *fiber.Ctx) bool {
+	expected := os.Getenv(adminTokenEnv)
+	if expected == "" {
+		return false
+	}
+	given := c.Get("X-Admin-Token")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// RequireAdmin is a middleware that rejects requests without a valid admin token.
+func RequireAdmin(c *fiber.Ctx) error {
+	if !isAdminRequest(c) {
+		return c.Status(fiber.StatusForbidden).JSON(Response{
+			Success: false,
+			Message: "Admin access required.",
+		})
+	}
+	return c.Next()
+}
+
+// issueUserToken signs a token for user that is valid for ttl. The token is
+// "<base64url user>.<unix expiry>.<hex hmac>".
+func issueUserToken(user string, ttl time.Duration, now time.Time) (string, error) {
+	secret := os.Getenv(userTokenSecretEnv)
+	if secret == "" {
+		return "", fmt.Errorf("%s is not set", userTokenSecretEnv)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(user))
+	exp := strconv.FormatInt(now.Add(ttl).Unix(), 10)
+	return encoded + "." + exp + "." + signUserToken(secret, encoded, exp), nil
+}
+
+func signUserToken(secret, encodedUser, exp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encodedUser + "|" + exp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyUserToken returns the user code of a valid, unexpired token.
+func verifyUserToken(token string, now time.Time) (string, bool) {
+	secret := os.Getenv(userTokenSecretEnv)
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if secret == "" || len(parts) != 3 {
+		return "", false
+	}
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() > exp {
+		return "", false
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(signUserToken(secret, parts[0], parts[1]))) {
+		return "", false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(user) == 0 {
+		return "", false
+	}
+	return string(user), true
+}
+
+// authenticatedUser returns the user code from a valid X-User-Token header
+// (or "Authorization: Bearer <token>"), "" when the caller is not logged in.
+func authenticatedUser(c *fiber.Ctx) string {
+	token := c.Get("X-User-Token")
+	if token == "" {
+		if auth := c.Get(fiber.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	if token == "" {
+		return ""
+	}
+	user, ok := verifyUserToken(token, time.Now())
+	if !ok {
+		return ""
+	}
+	return user
+}
+
+// RequireUser is a middleware that rejects requests without a valid user
+// token. Admin requests pass as well; handlers that need to know who acts
+// call authenticatedUser.
+func RequireUser(c *fiber.Ctx) error {
+	if authenticatedUser(c) == "" && !isAdminRequest(c) {
+		return c.Status(fiber.StatusUnauthorized).JSON(Response{
+			Success: false,
+			Message: "Login required (X-User-Token).",
+		})
+	}
+	return c.Next()
+}
+
+// IssueUserTokenHandler issues a user token for the login application.
+//
+//	POST /admin/usertoken?user=<user_code>[&ttl_hours=12]
+func IssueUserTokenHandler(c *fiber.Ctx) error {
+	user := strings.TrimSpace(c.Query("user"))
+	if user == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Parameter 'user' is required."})
+	}
+	ttl := defaultUserTokenTTL
+	if raw := c.Query("ttl_hours"); raw != "" {
+		hours, err := strconv.Atoi(raw)
+		if err != nil || hours <= 0 || hours > 24*30 {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "ttl_hours must be between 1 and 720."})
+		}
+		ttl = time.Duration(hours) * time.Hour
+	}
+	now := time.Now()
+	token, err := issueUserToken(user, ttl, now)
+	if err != nil {
+		log.Printf("Error issuing user token for %q: %v", user, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "User tokens are not configured."})
+	}
+	return c.JSON(fiber.Map{
+		"success":    true,
+		"user":       user,
+		"token":      token,
+		"expires_at": now.Add(ttl),
+	})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUserTokenRoundTrip(t *testing.T) {
+	t.Setenv(userTokenSecretEnv, "rahasia")
+	now := time.Now()
+
+	token, err := issueUserToken("KA019", time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := verifyUserToken(token, now); !ok || user != "KA019" {
+		t.Fatalf("expected KA019, got %q %v", user, ok)
+	}
+	if _, ok := verifyUserToken(token, now.Add(2*time.Hour)); ok {
+		t.Fatal("expired token accepted")
+	}
+
+	// Mengganti user tanpa tanda tangan baru harus ditolak.
+	forged := "S0EwMDY" + token[strings.Index(token, "."):]
+	if _, ok := verifyUserToken(forged, now); ok {
+		t.Fatal("forged token accepted")
+	}
+
+	t.Setenv(userTokenSecretEnv, "lain")
+	if _, ok := verifyUserToken(token, now); ok {
+		t.Fatal("token signed with another secret accepted")
+	}
+}
+
+func TestRequireUserIgnoresUserCodeHeader(t *testing.T) {
+	t.Setenv(userTokenSecretEnv, "rahasia")
+	t.Setenv(adminTokenEnv, "admin")
+	app := fiber.New()
+	app.Get("/", RequireUser, func(c *fiber.Ctx) error { return c.SendString(requestUser(c)) })
+
+	token, _ := issueUserToken("KA019", time.Hour, time.Now())
+	cases := []struct {
+		header, value string
+		want          int
+	}{
+		{"X-User-Code", "KA019", fiber.StatusUnauthorized},
+		{"X-User-Token", "salah", fiber.StatusUnauthorized},
+		{"X-User-Token", token, fiber.StatusOK},
+		{"Authorization", "Bearer " + token, fiber.StatusOK},
+		{"X-Admin-Token", "admin", fiber.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(tc.header, tc.value)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.header, tc.want, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,500 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// Aturan budget ANP, satu-satunya tempat perhitungan ini dilakukan:
+//
+//   - Budget reguler punya dua tingkat. Tingkat activity: awal =
+//     MAX(BudgetActivity) di tb_operating_activity untuk activity/brand/budget
+//     code. Tingkat budget: awal = budget_total_tahunan proposal dengan
+//     brand/budget code yang sama.
+//   - Budget on_top hanya punya tingkat budget: awal = SUM(budget_on_top) di
+//     tb_budget_on_top untuk budget code tersebut.
+//   - Committed = SUM(TotalCosting) proposal yang tidak canceled, used =
+//     SUM(realisasi) proposal yang sama, remaining = awal - committed.
+//   - Proposal on_top hanya mengurangi budget on_top, proposal lain hanya
+//     mengurangi budget reguler.
+//
+// Budget code berlaku untuk satu tahun fiskal; fs_year diambil dari tb_operating.
+const (
+	budgetLevelBudget   = "budget"
+	budgetLevelActivity = "activity"
+)
+
+// BudgetBalance adalah posisi satu budget (tingkat budget atau activity).
+type BudgetBalance struct {
+	Level        string  `json:"level"`
+	BudgetType   string  `json:"budget_type"`
+	BrandCode    string  `json:"brand_code"`
+	BudgetCode   string  `json:"budget_code"`
+	ActivityCode int     `json:"activity_code,omitempty"`
+	FsYear       int     `json:"fs_year,omitempty"`
+	Initial      float64 `json:"initial"`
+	Used         float64 `json:"used"`
+	Committed    float64 `json:"committed"`
+	Remaining    float64 `json:"remaining"`
+	Proposals    int     `json:"proposals"`
+}
+
+// BudgetFilter membatasi budget yang dimuat loadBudgetLedgerFor.
+type BudgetFilter struct {
+	FsYear       int
+	BrandCode    string
+	BudgetCode   string
+	ActivityCode int
+}
+
+type budgetKey struct {
+	Level        string
+	BudgetType   string
+	BrandCode    string // kosong untuk on_top
+	BudgetCode   string
+	ActivityCode int // 0 untuk tingkat budget
+}
+
+// budgetProposal adalah satu baris tb_operating_proposal dalam ledger.
+type budgetProposal struct {
+	Number       string
+	BrandCode    string
+	BudgetCode   string
+	ActivityCode int
+	BudgetType   string
+	Costing      float64
+	Realisasi    float64
+	Canceled     bool
+}
+
+// budgetLedger menyimpan proposal dan budget awal untuk sekumpulan budget code.
+type budgetLedger struct {
+	balances  map[budgetKey]*BudgetBalance
+	proposals map[string]budgetProposal
+	byPool    map[budgetKey][]budgetProposal // tingkat budget, urut nomor proposal
+}
+
+func normalizeBudgetType(t string) string {
+	if strings.EqualFold(strings.TrimSpace(t), budgetTypeOnTop) {
+		return budgetTypeOnTop
+	}
+	return budgetTypeRegular
+}
+
+// budgetKeyCode menormalkan brand/budget code untuk kunci ledger. Kode yang
+// sama bisa ditulis dengan huruf berbeda di tabel lain (collation SQL Server
+// tidak case-sensitive), jadi kunci selalu huruf besar.
+func budgetKeyCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func poolKey(budgetType, brand, budgetCode string) budgetKey {
+	if budgetType == budgetTypeOnTop {
+		brand = ""
+	}
+	return budgetKey{Level: budgetLevelBudget, BudgetType: budgetType, BrandCode: budgetKeyCode(brand), BudgetCode: budgetKeyCode(budgetCode)}
+}
+
+func activityKey(brand, budgetCode string, activity int) budgetKey {
+	return budgetKey{Level: budgetLevelActivity, BudgetType: budgetTypeRegular, BrandCode: budgetKeyCode(brand),
+		BudgetCode: budgetKeyCode(budgetCode), ActivityCode: activity}
+}
+
+func newBudgetLedger() *budgetLedger {
+	return &budgetLedger{
+		balances:  map[budgetKey]*BudgetBalance{},
+		proposals: map[string]budgetProposal{},
+		byPool:    map[budgetKey][]budgetProposal{},
+	}
+}
+
+func (l *budgetLedger) balance(k budgetKey, brand string) *BudgetBalance {
+	b, ok := l.balances[k]
+	if !ok {
+		b = &BudgetBalance{Level: k.Level, BudgetType: k.BudgetType, BrandCode: brand, BudgetCode: k.BudgetCode, ActivityCode: k.ActivityCode}
+		l.balances[k] = b
+	}
+	if b.BrandCode == "" {
+		b.BrandCode = brand
+	}
+	return b
+}
+
+// addProposal mencatat satu proposal ke pool budget dan (untuk reguler) activity-nya.
+func (l *budgetLedger) addProposal(p budgetProposal, annualTotal sql.NullFloat64) {
+	p.BudgetType = normalizeBudgetType(p.BudgetType)
+	l.proposals[p.Number] = p
+
+	pk := poolKey(p.BudgetType, p.BrandCode, p.BudgetCode)
+	targets := []*BudgetBalance{l.balance(pk, p.BrandCode)}
+	l.byPool[pk] = append(l.byPool[pk], p)
+	if p.BudgetType == budgetTypeRegular {
+		if annualTotal.Valid && annualTotal.Float64 > targets[0].Initial {
+			targets[0].Initial = annualTotal.Float64
+		}
+		targets = append(targets, l.balance(activityKey(p.BrandCode, p.BudgetCode, p.ActivityCode), p.BrandCode))
+	}
+	for _, b := range targets {
+		b.Proposals++
+		if !p.Canceled {
+			b.Committed += p.Costing
+			b.Used += p.Realisasi
+		}
+	}
+}
+
+// finish menghitung sisa dan mengurutkan proposal per pool.
+func (l *budgetLedger) finish() {
+	for _, b := range l.balances {
+		b.Remaining = b.Initial - b.Committed
+	}
+	for k := range l.byPool {
+		list := l.byPool[k]
+		sort.Slice(list, func(i, j int) bool { return list[i].Number < list[j].Number })
+	}
+}
+
+// Balances mengembalikan posisi budget yang cocok dengan filter, terurut.
+func (l *budgetLedger) Balances(f BudgetFilter, level string) []BudgetBalance {
+	out := []BudgetBalance{}
+	for _, b := range l.balances {
+		if level != "" && b.Level != level {
+			continue
+		}
+		if f.BrandCode != "" && !strings.EqualFold(b.BrandCode, f.BrandCode) {
+			continue
+		}
+		if f.BudgetCode != "" && !strings.EqualFold(b.BudgetCode, f.BudgetCode) {
+			continue
+		}
+		if f.ActivityCode != 0 && b.Level == budgetLevelActivity && b.ActivityCode != f.ActivityCode {
+			continue
+		}
+		if f.FsYear != 0 && b.FsYear != 0 && b.FsYear != f.FsYear {
+			continue
+		}
+		out = append(out, *b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.BrandCode != b.BrandCode {
+			return a.BrandCode < b.BrandCode
+		}
+		if a.BudgetCode != b.BudgetCode {
+			return a.BudgetCode < b.BudgetCode
+		}
+		if a.Level != b.Level {
+			return a.Level == budgetLevelBudget
+		}
+		return a.ActivityCode < b.ActivityCode
+	})
+	return out
+}
+
+// Pool mengembalikan posisi tingkat budget (reguler atau on_top).
+func (l *budgetLedger) Pool(budgetType, brand, budgetCode string) (BudgetBalance, bool) {
+	b, ok := l.balances[poolKey(normalizeBudgetType(budgetType), brand, budgetCode)]
+	if !ok {
+		return BudgetBalance{}, false
+	}
+	return *b, true
+}
+
+// Activity mengembalikan posisi budget reguler satu activity.
+func (l *budgetLedger) Activity(brand, budgetCode string, activity int) (BudgetBalance, bool) {
+	b, ok := l.balances[activityKey(brand, budgetCode, activity)]
+	if !ok {
+		return BudgetBalance{}, false
+	}
+	return *b, true
+}
+
+// ProposalActivity mengembalikan posisi budget activity milik proposal reguler.
+func (l *budgetLedger) ProposalActivity(number string) (BudgetBalance, bool) {
+	p, ok := l.proposals[number]
+	if !ok || p.BudgetType != budgetTypeRegular {
+		return BudgetBalance{}, false
+	}
+	return l.Activity(p.BrandCode, p.BudgetCode, p.ActivityCode)
+}
+
+// ProposalPosition mengembalikan budget awal pool proposal dan sisa budget
+// setelah proposal itu, dihitung kumulatif sampai nomor tersebut (kolom
+// Total_Budget/Balance di daftar proposal).
+func (l *budgetLedger) ProposalPosition(number string) (total, balance float64, ok bool) {
+	p, ok := l.proposals[number]
+	if !ok {
+		return 0, 0, false
+	}
+	pk := poolKey(p.BudgetType, p.BrandCode, p.BudgetCode)
+	pool := l.balances[pk]
+	committed := 0.0
+	for _, q := range l.byPool[pk] {
+		if q.Number > number {
+			break
+		}
+		if !q.Canceled {
+			committed += q.Costing
+		}
+	}
+	return pool.Initial, pool.Initial - committed, true
+}
+
+// loadBudgetLedger memuat proposal dan budget awal untuk budget code yang diberikan.
+func loadBudgetLedger(src sqlAuditSource, q sqlQueryer, codes []string) (*budgetLedger, error) {
+	l := newBudgetLedger()
+	codes = uniqueNonEmpty(codes)
+	if len(codes) == 0 {
+		return l, nil
+	}
+	codeList := strings.Join(codes, ",")
+	inCodes := "IN (SELECT value FROM STRING_SPLIT(@p1, ','))"
+
+	err := auditedQuery(src, q, `
+		SELECT ProposalNumber, BrandCode, BudgetCode, ISNULL(ActivityCode, 0), Budget_type,
+			ISNULL(TotalCosting, 0), ISNULL(realisasi, 0), status_proposal, budget_total_tahunan
+		FROM tb_operating_proposal
+		WHERE ProposalNumber IS NOT NULL AND BudgetCode `+inCodes, []interface{}{codeList}, func(rows *sql.Rows) error {
+		var p budgetProposal
+		var number, brand, code, budgetType, status sql.NullString
+		var annual sql.NullFloat64
+		if err := rows.Scan(&number, &brand, &code, &p.ActivityCode, &budgetType, &p.Costing, &p.Realisasi, &status, &annual); err != nil {
+			return err
+		}
+		p.Number, p.BrandCode, p.BudgetCode, p.BudgetType = number.String, brand.String, code.String, budgetType.String
+		p.Canceled = strings.EqualFold(strings.TrimSpace(status.String), "canceled")
+		l.addProposal(p, annual)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("budget proposals: %w", err)
+	}
+
+	err = auditedQuery(src, q, `
+		SELECT BrandCode, BudgetCode, ActivityCode, MAX(BudgetActivity)
+		FROM tb_operating_activity
+		WHERE BudgetCode `+inCodes+`
+		GROUP BY BrandCode, BudgetCode, ActivityCode`, []interface{}{codeList}, func(rows *sql.Rows) error {
+		var brand, code sql.NullString
+		var activity sql.NullInt64
+		var initial sql.NullFloat64
+		if err := rows.Scan(&brand, &code, &activity, &initial); err != nil {
+			return err
+		}
+		l.balance(activityKey(brand.String, code.String, int(activity.Int64)), brand.String).Initial = initial.Float64
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("activity budgets: %w", err)
+	}
+
+	err = auditedQuery(src, q, `
+		SELECT MAX(brand_code), budget_code, SUM(budget_on_top)
+		FROM tb_budget_on_top
+		WHERE budget_code `+inCodes+`
+		GROUP BY budget_code`, []interface{}{codeList}, func(rows *sql.Rows) error {
+		var brand, code sql.NullString
+		var initial sql.NullFloat64
+		if err := rows.Scan(&brand, &code, &initial); err != nil {
+			return err
+		}
+		l.balance(poolKey(budgetTypeOnTop, brand.String, code.String), brand.String).Initial = initial.Float64
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("on top budgets: %w", err)
+	}
+
+	years := map[string]int{}
+	err = auditedQuery(src, q, `
+		SELECT BudgetCode, MAX(fs_year)
+		FROM tb_operating
+		WHERE BudgetCode `+inCodes+`
+		GROUP BY BudgetCode`, []interface{}{codeList}, func(rows *sql.Rows) error {
+		var code sql.NullString
+		var year sql.NullInt64
+		if err := rows.Scan(&code, &year); err != nil {
+			return err
+		}
+		years[strings.ToUpper(code.String)] = int(year.Int64)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("budget fiscal years: %w", err)
+	}
+	for _, b := range l.balances {
+		b.FsYear = years[strings.ToUpper(b.BudgetCode)]
+	}
+
+	l.finish()
+	return l, nil
+}
+
+// loadBudgetLedgerFor mencari budget code yang cocok dengan filter lalu memuat ledger-nya.
+func loadBudgetLedgerFor(src sqlAuditSource, q sqlQueryer, f BudgetFilter) (*budgetLedger, error) {
+	if f.BudgetCode != "" {
+		return loadBudgetLedger(src, q, []string{f.BudgetCode})
+	}
+
+	var args boundArgs
+	var cond []string
+	if f.BrandCode != "" {
+		cond = append(cond, "BrandCode = "+args.bind(f.BrandCode))
+	}
+	if f.FsYear != 0 {
+		cond = append(cond, "fs_year = "+args.bind(f.FsYear))
+	}
+	where := ""
+	if len(cond) > 0 {
+		where = " AND " + strings.Join(cond, " AND ")
+	}
+	onTopWhere := ""
+	if f.BrandCode != "" {
+		onTopWhere = " AND brand_code = " + args.bind(f.BrandCode)
+	}
+	if f.FsYear != 0 {
+		onTopWhere += " AND budget_code IN (SELECT BudgetCode FROM tb_operating WHERE fs_year = " + args.bind(f.FsYear) + ")"
+	}
+
+	var codes []string
+	err := auditedQuery(src, q, `
+		SELECT BudgetCode FROM tb_operating WHERE BudgetCode IS NOT NULL AND BudgetCode != ''`+where+`
+		UNION
+		SELECT budget_code FROM tb_budget_on_top WHERE budget_code IS NOT NULL AND budget_code != ''`+onTopWhere,
+		args, func(rows *sql.Rows) error {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				return err
+			}
+			codes = append(codes, code)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("budget codes: %w", err)
+	}
+	return loadBudgetLedger(src, q, codes)
+}
+
+// loadBudgetLedgerForProposals memuat ledger untuk budget code milik proposal-proposal tersebut.
+func loadBudgetLedgerForProposals(src sqlAuditSource, q sqlQueryer, numbers []string) (*budgetLedger, error) {
+	numbers = uniqueNonEmpty(numbers)
+	if len(numbers) == 0 {
+		return newBudgetLedger(), nil
+	}
+	var codes []string
+	err := auditedQuery(src, q, `
+		SELECT DISTINCT BudgetCode FROM tb_operating_proposal
+		WHERE BudgetCode IS NOT NULL AND ProposalNumber IN (SELECT value FROM STRING_SPLIT(@p1, ','))`,
+		[]interface{}{strings.Join(numbers, ",")}, func(rows *sql.Rows) error {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				return err
+			}
+			codes = append(codes, code)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("proposal budget codes: %w", err)
+	}
+	return loadBudgetLedger(src, q, codes)
+}
+
+func uniqueNonEmpty(values []string) []string {
+	seen := map[string]bool{}
+	out := values[:0:0]
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Contains(v, ",") || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+// rowString membaca kolom hasil fetchDataFromDB sebagai string; angka
+// dikembalikan tanpa desimal berlebih.
+func rowString(row map[string]interface{}, col string) string {
+	switch v := row[col].(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return strings.TrimSpace(fmt.Sprint(v))
+	}
+}
+
+// proposalBudgetColumns adalah QueryResultHook yang mengisi kolom total dan
+// sisa budget setiap baris proposal dari ledger, berdasarkan kolom nomor.
+// activityCol (boleh kosong) diisi budget awal activity proposal tersebut.
+func proposalBudgetColumns(numberCol, totalCol, balanceCol, activityCol string) QueryResultHook {
+	return func(src sqlAuditSource, rows []map[string]interface{}) error {
+		numbers := make([]string, 0, len(rows))
+		for _, r := range rows {
+			numbers = append(numbers, rowString(r, numberCol))
+		}
+		ledger, err := loadBudgetLedgerForProposals(src, db.GetDB(), numbers)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			number := rowString(r, numberCol)
+			if activityCol != "" {
+				r[activityCol] = 0.0
+				if a, ok := ledger.ProposalActivity(number); ok {
+					r[activityCol] = a.Initial
+				}
+			}
+			total, balance, ok := ledger.ProposalPosition(number)
+			if !ok {
+				r[totalCol], r[balanceCol] = nil, nil
+				continue
+			}
+			r[totalCol], r[balanceCol] = total, balance
+		}
+		return nil
+	}
+}
+
+// BudgetBalanceHandler mengembalikan posisi budget (initial, used, committed,
+// remaining) per brand/budget code/activity. Filter: fs_year, brand,
+// budget_code, activity, level (budget|activity).
+func BudgetBalanceHandler(c *fiber.Ctx) error {
+	f := BudgetFilter{
+		BrandCode:  strings.TrimSpace(c.Query("brand")),
+		BudgetCode: strings.TrimSpace(c.Query("budget_code")),
+	}
+	var err error
+	if f.FsYear, err = loadFiscalCalendar().resolveFsYear(c.Query("fs_year"), time.Now()); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: err.Error()})
+	}
+	if v := c.Query("activity"); v != "" {
+		if f.ActivityCode, err = strconv.Atoi(v); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid activity."})
+		}
+	}
+	level := strings.ToLower(c.Query("level"))
+	if level != "" && level != budgetLevelBudget && level != budgetLevelActivity {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "level must be budget or activity."})
+	}
+
+	ledger, err := loadBudgetLedgerFor(auditSourceFromCtx(c), db.GetDB(), f)
+	if err != nil {
+		log.Printf("Error loading budget balances: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to compute budget balance."})
+	}
+	balances := ledger.Balances(f, level)
+	return c.JSON(fiber.Map{"success": true, "count": len(balances), "balances": balances})
+}
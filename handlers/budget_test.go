@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestBudgetLedger(t *testing.T) {
+	l := newBudgetLedger()
+	annual := sql.NullFloat64{Float64: 1000, Valid: true}
+	l.balance(activityKey("B01", "BGT25", 3), "B01").Initial = 600
+	l.balance(poolKey(budgetTypeOnTop, "B01", "OT25"), "B01").Initial = 200
+
+	l.addProposal(budgetProposal{Number: "P0002", BrandCode: "B01", BudgetCode: "BGT25", ActivityCode: 3, BudgetType: "regular", Costing: 300, Realisasi: 100}, annual)
+	l.addProposal(budgetProposal{Number: "P0001", BrandCode: "B01", BudgetCode: "BGT25", ActivityCode: 3, BudgetType: "", Costing: 100}, annual)
+	l.addProposal(budgetProposal{Number: "P0003", BrandCode: "B01", BudgetCode: "BGT25", ActivityCode: 4, BudgetType: "regular", Costing: 500, Canceled: true}, annual)
+	l.addProposal(budgetProposal{Number: "P0004", BrandCode: "B01", BudgetCode: "OT25", BudgetType: "ON_TOP", Costing: 150}, sql.NullFloat64{})
+	l.finish()
+
+	pool, ok := l.Pool(budgetTypeRegular, "B01", "BGT25")
+	if !ok || pool.Initial != 1000 || pool.Committed != 400 || pool.Used != 100 || pool.Remaining != 600 || pool.Proposals != 3 {
+		t.Errorf("regular pool = %+v", pool)
+	}
+	act, ok := l.Activity("B01", "BGT25", 3)
+	if !ok || act.Initial != 600 || act.Committed != 400 || act.Remaining != 200 {
+		t.Errorf("activity 3 = %+v", act)
+	}
+	onTop, ok := l.Pool(budgetTypeOnTop, "", "OT25")
+	if !ok || onTop.Committed != 150 || onTop.Remaining != 50 {
+		t.Errorf("on top pool = %+v", onTop)
+	}
+
+	// Balance kumulatif sampai nomor proposal, canceled tidak dihitung.
+	for number, want := range map[string]float64{"P0001": 900, "P0002": 600, "P0003": 600, "P0004": 50} {
+		if _, balance, ok := l.ProposalPosition(number); !ok || balance != want {
+			t.Errorf("ProposalPosition(%s) balance = %v, %v; want %v", number, balance, ok, want)
+		}
+	}
+	if _, ok := l.ProposalActivity("P0004"); ok {
+		t.Error("on top proposal should not have an activity budget")
+	}
+
+	if got := l.Balances(BudgetFilter{BudgetCode: "bgt25"}, budgetLevelActivity); len(got) != 2 || got[0].ActivityCode != 3 {
+		t.Errorf("Balances activity = %+v", got)
+	}
+}
+
+func TestBudgetLedgerKeysIgnoreCase(t *testing.T) {
+	l := newBudgetLedger()
+	l.balance(activityKey("b01", "bgt25", 3), "b01").Initial = 600
+	l.balance(poolKey(budgetTypeOnTop, "", "ot25 "), "").Initial = 200
+	l.addProposal(budgetProposal{Number: "P0001", BrandCode: "B01", BudgetCode: "BGT25", ActivityCode: 3, Costing: 100}, sql.NullFloat64{})
+	l.addProposal(budgetProposal{Number: "P0002", BrandCode: "B01", BudgetCode: "OT25", BudgetType: budgetTypeOnTop, Costing: 50}, sql.NullFloat64{})
+	l.finish()
+
+	if act, ok := l.Activity("B01", "Bgt25", 3); !ok || act.Initial != 600 || act.Remaining != 500 {
+		t.Errorf("activity looked up with other case = %+v, %v", act, ok)
+	}
+	if pool, ok := l.Pool(budgetTypeOnTop, "", "OT25"); !ok || pool.Initial != 200 || pool.Remaining != 150 {
+		t.Errorf("on top pool looked up with other case = %+v, %v", pool, ok)
+	}
+}
@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// Metode proyeksi burn-down budget.
+const (
+	forecastLinear   = "linear"   // laju rata-rata bulan yang sudah lewat
+	forecastSeasonal = "seasonal" // pola bulanan tahun fiskal sebelumnya
+
+	forecastLevelBrand    = "brand"
+	forecastLevelActivity = "activity"
+
+	forecastFlagOverCommitted = "OVER_COMMITTED" // costing sudah melebihi budget
+	forecastFlagOverspend     = "OVERSPEND"      // proyeksi akhir tahun melebihi budget
+)
+
+// BudgetBurnMonth adalah satu bulan fiskal dalam burn-down.
+type BudgetBurnMonth struct {
+	Month               string  `json:"month"` // YYYY-MM
+	Planned             float64 `json:"planned"`
+	Cumulative          float64 `json:"cumulative"`
+	Remaining           float64 `json:"remaining"`
+	Projected           float64 `json:"projected"`
+	ProjectedCumulative float64 `json:"projected_cumulative"`
+	Elapsed             bool    `json:"elapsed"`
+}
+
+// BudgetForecast adalah burn-down dan proyeksi satu brand (atau brand/activity).
+type BudgetForecast struct {
+	Level              string            `json:"level"`
+	BrandCode          string            `json:"brand_code"`
+	ActivityCode       int               `json:"activity_code,omitempty"`
+	Method             string            `json:"method"`
+	Initial            float64           `json:"initial"`
+	Committed          float64           `json:"committed"`
+	ToDate             float64           `json:"to_date"`
+	Projected          float64           `json:"projected"`
+	ProjectedRemaining float64           `json:"projected_remaining"`
+	ExhaustMonth       string            `json:"exhaust_month,omitempty"`
+	Flags              []string          `json:"flags"`
+	Months             []BudgetBurnMonth `json:"months"`
+}
+
+type forecastKey struct {
+	BrandCode    string
+	ActivityCode int
+}
+
+// forecastGroup mengumpulkan budget awal dan costing bulanan satu kunci.
+type forecastGroup struct {
+	Initial float64
+	Monthly []float64
+}
+
+// proposalPeriod adalah periode proposal dari tb_proposal.
+type proposalPeriod struct {
+	Start, End time.Time
+}
+
+// fiscalMonths mengembalikan awal setiap bulan tahun fiskal.
+func fiscalMonths(fy FiscalYear) []time.Time {
+	months := make([]time.Time, 12)
+	for i := range months {
+		months[i] = fy.Start.AddDate(0, i, 0)
+	}
+	return months
+}
+
+// spreadCosting membagi amount ke bulan-bulan fiskal sesuai jumlah hari
+// periode di tiap bulan. Bagian di luar tahun fiskal dibagi ulang ke bulan
+// yang beririsan; periode kosong atau sepenuhnya di luar tahun masuk ke bulan
+// terdekat.
+func spreadCosting(p proposalPeriod, amount float64, months []time.Time) []float64 {
+	out := make([]float64, len(months))
+	if len(months) == 0 || amount == 0 {
+		return out
+	}
+	if p.Start.IsZero() {
+		out[0] = amount
+		return out
+	}
+	end := p.End
+	if end.IsZero() || end.Before(p.Start) {
+		end = p.Start
+	}
+	end = end.AddDate(0, 0, 1) // periode inklusif
+
+	total := 0.0
+	days := make([]float64, len(months))
+	for i, m := range months {
+		from, to := m, m.AddDate(0, 1, 0)
+		if p.Start.After(from) {
+			from = p.Start
+		}
+		if end.Before(to) {
+			to = end
+		}
+		if to.After(from) {
+			days[i] = to.Sub(from).Hours() / 24
+			total += days[i]
+		}
+	}
+	if total == 0 {
+		if p.Start.Before(months[0]) {
+			out[0] = amount
+		} else {
+			out[len(out)-1] = amount
+		}
+		return out
+	}
+	for i := range days {
+		out[i] = amount * days[i] / total
+	}
+	return out
+}
+
+// elapsedFiscalMonths menghitung bulan fiskal yang sudah berjalan per now
+// (bulan berjalan dihitung).
+func elapsedFiscalMonths(months []time.Time, now time.Time) int {
+	n := 0
+	for _, m := range months {
+		if !m.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// forecastGroupResult menghitung burn-down dan proyeksi satu kelompok. prev
+// adalah costing bulanan tahun sebelumnya untuk metode seasonal (boleh nil;
+// jatuh ke linear bila tidak ada pola).
+func forecastGroupResult(g *forecastGroup, prev []float64, months []time.Time, elapsed int, method string) BudgetForecast {
+	r := BudgetForecast{Method: method, Initial: g.Initial, Flags: []string{}, Months: make([]BudgetBurnMonth, len(months))}
+	for i, v := range g.Monthly {
+		r.Committed += v
+		if i < elapsed {
+			r.ToDate += v
+		}
+	}
+
+	// Laju bulanan untuk bulan yang belum lewat.
+	rates := make([]float64, len(months))
+	if elapsed > 0 && elapsed < len(months) {
+		prevTotal, prevToDate := 0.0, 0.0
+		for i, v := range prev {
+			prevTotal += v
+			if i < elapsed {
+				prevToDate += v
+			}
+		}
+		if method == forecastSeasonal && prevTotal > 0 && prevToDate > 0 {
+			yearEnd := r.ToDate * prevTotal / prevToDate
+			for i := elapsed; i < len(months); i++ {
+				rates[i] = yearEnd * prev[i] / prevTotal
+			}
+		} else {
+			if method == forecastSeasonal {
+				r.Method = forecastLinear
+			}
+			for i := elapsed; i < len(months); i++ {
+				rates[i] = r.ToDate / float64(elapsed)
+			}
+		}
+	}
+
+	cumulative, projected := 0.0, 0.0
+	for i, m := range months {
+		planned := g.Monthly[i]
+		cumulative += planned
+		proj := planned
+		if i >= elapsed && rates[i] > proj {
+			proj = rates[i]
+		}
+		projected += proj
+		r.Months[i] = BudgetBurnMonth{
+			Month:               m.Format("2006-01"),
+			Planned:             planned,
+			Cumulative:          cumulative,
+			Remaining:           g.Initial - cumulative,
+			Projected:           proj,
+			ProjectedCumulative: projected,
+			Elapsed:             i < elapsed,
+		}
+		if r.ExhaustMonth == "" && projected > g.Initial {
+			r.ExhaustMonth = r.Months[i].Month
+		}
+	}
+	r.Projected = projected
+	r.ProjectedRemaining = g.Initial - projected
+
+	if r.Committed > g.Initial {
+		r.Flags = append(r.Flags, forecastFlagOverCommitted)
+	}
+	if r.Projected > g.Initial {
+		r.Flags = append(r.Flags, forecastFlagOverspend)
+	}
+	return r
+}
+
+// budgetForecastKey mengembalikan kunci kelompok proposal untuk level tertentu;
+// level activity hanya memuat proposal reguler. BrandCode dinormalisasi seperti
+// kunci budget ledger agar costing dan budget awal jatuh ke kelompok yang sama.
+func budgetForecastKey(level string, p budgetProposal) (forecastKey, bool) {
+	if level == forecastLevelActivity {
+		if p.BudgetType != budgetTypeRegular {
+			return forecastKey{}, false
+		}
+		return forecastKey{BrandCode: budgetKeyCode(p.BrandCode), ActivityCode: p.ActivityCode}, true
+	}
+	return forecastKey{BrandCode: budgetKeyCode(p.BrandCode)}, true
+}
+
+// groupLedgerCosting menjumlah costing bulanan proposal yang tidak canceled per kelompok.
+func groupLedgerCosting(l *budgetLedger, periods map[string]proposalPeriod, months []time.Time, level, brand string, activity int) map[forecastKey][]float64 {
+	out := map[forecastKey][]float64{}
+	for _, p := range l.proposals {
+		if p.Canceled || (brand != "" && !strings.EqualFold(p.BrandCode, brand)) {
+			continue
+		}
+		k, ok := budgetForecastKey(level, p)
+		if !ok || (activity != 0 && p.ActivityCode != activity) {
+			continue
+		}
+		if out[k] == nil {
+			out[k] = make([]float64, len(months))
+		}
+		for i, v := range spreadCosting(periods[p.Number], p.Costing, months) {
+			out[k][i] += v
+		}
+	}
+	return out
+}
+
+// loadProposalPeriods memuat StartDatePeriode/EndDatePeriode proposal.
+func loadProposalPeriods(src sqlAuditSource, q sqlQueryer, numbers []string) (map[string]proposalPeriod, error) {
+	out := map[string]proposalPeriod{}
+	numbers = uniqueNonEmpty(numbers)
+	if len(numbers) == 0 {
+		return out, nil
+	}
+	err := auditedQuery(src, q, `
+		SELECT Number, StartDatePeriode, EndDatePeriode
+		FROM tb_proposal
+		WHERE Number IN (SELECT value FROM STRING_SPLIT(@p1, ','))`,
+		[]interface{}{strings.Join(numbers, ",")}, func(rows *sql.Rows) error {
+			var number string
+			var start, end sql.NullTime
+			if err := rows.Scan(&number, &start, &end); err != nil {
+				return err
+			}
+			out[number] = proposalPeriod{Start: start.Time, End: end.Time}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("proposal periods: %w", err)
+	}
+	return out, nil
+}
+
+// BudgetForecastHandler menampilkan burn-down bulanan dan proyeksi pemakaian
+// akhir tahun per brand (level=brand) atau brand/activity (level=activity).
+// Query: fs_year (default tahun fiskal berjalan), brand, activity,
+// method=linear|seasonal, flagged=1 untuk hanya yang diproyeksikan overspend.
+func BudgetForecastHandler(c *fiber.Ctx) error {
+	cal, now := loadFiscalCalendar(), time.Now()
+	year, err := cal.resolveFsYear(c.Query("fs_year"), now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: err.Error()})
+	}
+	if year == 0 {
+		year = cal.Current(now).Year
+	}
+	brand := strings.TrimSpace(c.Query("brand"))
+	activity := 0
+	if v := c.Query("activity"); v != "" {
+		if activity, err = strconv.Atoi(v); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid activity."})
+		}
+	}
+	level := strings.ToLower(c.Query("level", forecastLevelBrand))
+	if level != forecastLevelBrand && level != forecastLevelActivity {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "level must be brand or activity."})
+	}
+	if activity != 0 {
+		level = forecastLevelActivity
+	}
+	method := strings.ToLower(c.Query("method", forecastLinear))
+	if method != forecastLinear && method != forecastSeasonal {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "method must be linear or seasonal."})
+	}
+
+	forecasts, err := runBudgetForecast(auditSourceFromCtx(c), db.GetDB(), cal, now, year, brand, activity, level, method)
+	if err != nil {
+		log.Printf("Error computing budget forecast: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to compute budget forecast."})
+	}
+	if c.Query("flagged") == "1" {
+		flagged := forecasts[:0]
+		for _, f := range forecasts {
+			if len(f.Flags) > 0 {
+				flagged = append(flagged, f)
+			}
+		}
+		forecasts = flagged
+	}
+
+	fy := cal.Year(year)
+	return c.JSON(fiber.Map{
+		"success":   true,
+		"fs_year":   year,
+		"start":     fy.Start.Format("2006-01-02"),
+		"end":       fy.End.Format("2006-01-02"),
+		"as_of":     now.Format("2006-01-02"),
+		"level":     level,
+		"count":     len(forecasts),
+		"forecasts": forecasts,
+	})
+}
+
+func runBudgetForecast(src sqlAuditSource, database *sql.DB, cal FiscalCalendar, now time.Time, year int, brand string, activity int, level, method string) ([]BudgetForecast, error) {
+	filter := BudgetFilter{FsYear: year, BrandCode: brand}
+	ledger, err := loadBudgetLedgerFor(src, database, filter)
+	if err != nil {
+		return nil, err
+	}
+	var prevLedger *budgetLedger
+	if method == forecastSeasonal {
+		if prevLedger, err = loadBudgetLedgerFor(src, database, BudgetFilter{FsYear: year - 1, BrandCode: brand}); err != nil {
+			return nil, err
+		}
+	}
+
+	numbers := make([]string, 0, len(ledger.proposals))
+	for n := range ledger.proposals {
+		numbers = append(numbers, n)
+	}
+	if prevLedger != nil {
+		for n := range prevLedger.proposals {
+			numbers = append(numbers, n)
+		}
+	}
+	periods, err := loadProposalPeriods(src, database, numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	months := fiscalMonths(cal.Year(year))
+	groups := map[forecastKey]*forecastGroup{}
+	group := func(k forecastKey) *forecastGroup {
+		g, ok := groups[k]
+		if !ok {
+			g = &forecastGroup{Monthly: make([]float64, len(months))}
+			groups[k] = g
+		}
+		return g
+	}
+
+	// Budget awal: level brand memakai pool (reguler dan on_top), level activity
+	// memakai budget activity.
+	balanceLevel := budgetLevelBudget
+	if level == forecastLevelActivity {
+		balanceLevel = budgetLevelActivity
+	}
+	for _, b := range ledger.Balances(filter, balanceLevel) {
+		k := forecastKey{BrandCode: budgetKeyCode(b.BrandCode)}
+		if level == forecastLevelActivity {
+			if activity != 0 && b.ActivityCode != activity {
+				continue
+			}
+			k.ActivityCode = b.ActivityCode
+		}
+		group(k).Initial += b.Initial
+	}
+	for k, monthly := range groupLedgerCosting(ledger, periods, months, level, brand, activity) {
+		g := group(k)
+		for i, v := range monthly {
+			g.Monthly[i] += v
+		}
+	}
+
+	var prev map[forecastKey][]float64
+	if prevLedger != nil {
+		prev = groupLedgerCosting(prevLedger, periods, fiscalMonths(cal.Year(year-1)), level, brand, activity)
+	}
+
+	elapsed := elapsedFiscalMonths(months, now)
+	out := make([]BudgetForecast, 0, len(groups))
+	for k, g := range groups {
+		r := forecastGroupResult(g, prev[k], months, elapsed, method)
+		r.Level, r.BrandCode, r.ActivityCode = level, k.BrandCode, k.ActivityCode
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].BrandCode != out[j].BrandCode {
+			return out[i].BrandCode < out[j].BrandCode
+		}
+		return out[i].ActivityCode < out[j].ActivityCode
+	})
+	return out, nil
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSpreadCosting(t *testing.T) {
+	months := fiscalMonths(FiscalCalendar{StartMonth: time.January, loc: time.UTC}.Year(2025))
+	day := func(m time.Month, d int) time.Time { return time.Date(2025, m, d, 0, 0, 0, 0, time.UTC) }
+
+	got := spreadCosting(proposalPeriod{Start: day(1, 17), End: day(2, 14)}, 290, months)
+	if math.Abs(got[0]-150) > 0.01 || math.Abs(got[1]-140) > 0.01 {
+		t.Errorf("spread = %v", got[:3])
+	}
+	got = spreadCosting(proposalPeriod{Start: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)}, 100, months)
+	if got[0] != 100 {
+		t.Errorf("period before fiscal year should land in first month: %v", got[:2])
+	}
+}
+
+func TestForecastGroupResult(t *testing.T) {
+	months := fiscalMonths(FiscalCalendar{StartMonth: time.January, loc: time.UTC}.Year(2025))
+	g := &forecastGroup{Initial: 1000, Monthly: make([]float64, 12)}
+	g.Monthly[0], g.Monthly[1], g.Monthly[2] = 100, 100, 100
+	g.Monthly[11] = 50 // proposal terjadwal Desember
+
+	r := forecastGroupResult(g, nil, months, 3, forecastLinear)
+	// 3 bulan x 100, lalu laju 100/bulan untuk 9 bulan sisanya.
+	if r.ToDate != 300 || r.Committed != 350 || r.Projected != 1200 {
+		t.Errorf("linear: to_date=%v committed=%v projected=%v", r.ToDate, r.Committed, r.Projected)
+	}
+	if r.ExhaustMonth != "2025-11" || len(r.Flags) != 1 || r.Flags[0] != forecastFlagOverspend {
+		t.Errorf("linear: exhaust=%q flags=%v", r.ExhaustMonth, r.Flags)
+	}
+
+	// Tahun lalu 60% pemakaian di Q1: akhir tahun = 300 / 0.6 = 500, sisa 200
+	// tersebar 22.2/bulan; Desember memakai costing terjadwal 50.
+	prev := make([]float64, 12)
+	prev[0], prev[1], prev[2] = 200, 200, 200
+	for i := 3; i < 12; i++ {
+		prev[i] = 400.0 / 9
+	}
+	r = forecastGroupResult(g, prev, months, 3, forecastSeasonal)
+	if r.Method != forecastSeasonal || math.Abs(r.Projected-(300+8*200.0/9+50)) > 0.01 || len(r.Flags) != 0 {
+		t.Errorf("seasonal: method=%s projected=%v flags=%v", r.Method, r.Projected, r.Flags)
+	}
+
+	// Tanpa pola tahun lalu, seasonal jatuh ke linear.
+	if r = forecastGroupResult(g, nil, months, 3, forecastSeasonal); r.Method != forecastLinear {
+		t.Errorf("seasonal without history should fall back to linear, got %s", r.Method)
+	}
+}
+
+func TestGroupLedgerCostingNormalizesBrand(t *testing.T) {
+	months := fiscalMonths(FiscalCalendar{StartMonth: time.January, loc: time.UTC}.Year(2025))
+	jan := proposalPeriod{Start: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)}
+	l := &budgetLedger{proposals: map[string]budgetProposal{
+		"P1": {Number: "P1", BrandCode: "b01 ", ActivityCode: 7, BudgetType: budgetTypeRegular, Costing: 100},
+		"P2": {Number: "P2", BrandCode: "B01", ActivityCode: 7, BudgetType: budgetTypeRegular, Costing: 50},
+	}}
+	periods := map[string]proposalPeriod{"P1": jan, "P2": jan}
+
+	got := groupLedgerCosting(l, periods, months, forecastLevelBrand, "", 0)
+	if len(got) != 1 || got[forecastKey{BrandCode: "B01"}][0] != 150 {
+		t.Errorf("brand level = %v", got)
+	}
+	got = groupLedgerCosting(l, periods, months, forecastLevelActivity, "", 0)
+	if len(got) != 1 || got[forecastKey{BrandCode: "B01", ActivityCode: 7}][0] != 150 {
+		t.Errorf("activity level = %v", got)
+	}
+}
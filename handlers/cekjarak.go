@@ -123,7 +123,7 @@ func getDistance(destLon, destLat float64) (float64, error) {
 // updateCustomerJarak updates customer's 'jarak' (distance) in the database.
 func updateCustomerJarak(dbConn *sql.DB, customerID int, jarak float64) error {
 	query := `UPDATE [pksrv-sap].pk_express.dbo.master_customer SET jarak = @p1 WHERE id = @p2;`
-	_, err := dbConn.Exec(query, jarak, customerID)
+	_, err := auditedExec(auditSourceJob("jarak"), dbConn, query, jarak, customerID)
 	if err != nil {
 		return fmt.Errorf("error updating jarak for customer ID %d: %w", customerID, err)
 	}
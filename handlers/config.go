@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envInt reads an integer setting from the environment, falling back to def
+// when the variable is unset or not a number.
+func envInt(name string, def int) int {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return def
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using default %d", raw, name, def)
+		return def
+	}
+	return v
+}
+
+// envBool reads a boolean setting ("1", "true", "yes") from the environment.
+func envBool(name string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(name))) {
+	case "":
+		return def
+	case "1", "true", "yes", "y":
+		return true
+	default:
+		return false
+	}
+}
+
+// envString reads a string setting from the environment.
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
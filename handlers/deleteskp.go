@@ -1,59 +1,298 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/robfig/cron/v3"
 	"my-fiber-app/db"
 )
 
-func DeleteskpHandler(c *fiber.Ctx) error {
-	if db.DB == nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(Response{
-			Success: false,
-			Message: "Koneksi database tidak tersedia.",
-		})
+// Pembersihan tb_proposal_skp: baris tanpa gambar (img kosong atau berisi "?")
+// yang lebih tua dari SKP_CLEANUP_MIN_AGE_HOURS dipindah ke tabel arsip lalu
+// dihapus. Setiap run (termasuk dry run) dicatat di tabel riwayat.
+const (
+	skpCleanupCronEnv    = "SKP_CLEANUP_CRON"
+	skpCleanupMinAgeEnv  = "SKP_CLEANUP_MIN_AGE_HOURS"
+	skpCleanupMaxRowsEnv = "SKP_CLEANUP_MAX_ROWS"
+
+	defaultSkpCleanupCron    = "30 2 * * *" // setiap hari 02:30
+	defaultSkpCleanupMinAge  = 24
+	defaultSkpCleanupMaxRows = 5000
+	skpCleanupPreviewRows    = 200
+
+	skpArchiveTable        = "dbo.tb_proposal_skp_archive"
+	skpCleanupHistoryTable = "dbo.tb_skp_cleanup_history"
+
+	skpNoImageCondition = `(img IS NULL OR img = '' OR img LIKE '%?%')`
+	// skpCleanupCondition memilih SKP tanpa gambar yang sudah melewati batas umur (@p1 jam).
+	// Baris tanpa CreatedAt tidak pernah dihapus karena umurnya tidak diketahui;
+	// jumlahnya dilaporkan terpisah (skpUndatedCondition).
+	skpCleanupCondition = skpNoImageCondition + `
+		AND CreatedAt IS NOT NULL AND CreatedAt < DATEADD(HOUR, -@p1, GETDATE())`
+	skpUndatedCondition = skpNoImageCondition + ` AND CreatedAt IS NULL`
+)
+
+var (
+	skpCleanupTables tableSetup
+	skpCleanupRunMu  sync.Mutex
+)
+
+// SkpCleanupRow adalah satu baris SKP yang akan (atau sudah) dibersihkan.
+type SkpCleanupRow struct {
+	ID             int64          `json:"id"`
+	ProposalNumber sql.NullString `json:"proposal_number"`
+	NoSKP          sql.NullString `json:"no_skp"`
+	Img            sql.NullString `json:"img"`
+	CreatedAt      sql.NullTime   `json:"created_at"`
+}
+
+// SkpCleanupRun adalah satu baris riwayat job pembersihan.
+type SkpCleanupRun struct {
+	ID          int64        `json:"id"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  sql.NullTime `json:"finished_at"`
+	DryRun      bool         `json:"dry_run"`
+	TriggeredBy string       `json:"triggered_by"`
+	MinAgeHours int          `json:"min_age_hours"`
+	Matched     int64        `json:"matched"`
+	Archived    int64        `json:"archived"`
+	Deleted     int64        `json:"deleted"`
+	Error       string       `json:"error,omitempty"`
+
+	// Undated adalah SKP tanpa gambar yang tidak punya CreatedAt (tidak dihapus).
+	Undated int64 `json:"undated"`
+
+	Rows        []SkpCleanupRow `json:"rows,omitempty"`
+	UndatedRows []SkpCleanupRow `json:"undated_rows,omitempty"`
+}
+
+// ensureSkpCleanupTables membuat tabel arsip (struktur sama dengan
+// tb_proposal_skp tanpa IDENTITY, ditambah archived_at dan cleanup_run_id)
+// dan tabel riwayat bila belum ada.
+func ensureSkpCleanupTables(database *sql.DB) error {
+	return skpCleanupTables.ensure(func() error {
+		_, err := database.Exec(fmt.Sprintf(`
+			IF OBJECT_ID('%[1]s', 'U') IS NULL
+			BEGIN
+				-- UNION ALL mencegah kolom id mewarisi IDENTITY.
+				SELECT * INTO %[1]s FROM (
+					SELECT TOP 0 * FROM tb_proposal_skp
+					UNION ALL
+					SELECT TOP 0 * FROM tb_proposal_skp
+				) s;
+				ALTER TABLE %[1]s ADD archived_at DATETIME NULL, cleanup_run_id BIGINT NULL;
+			END;
+			IF OBJECT_ID('%[2]s', 'U') IS NULL
+			CREATE TABLE %[2]s (
+				id BIGINT IDENTITY(1,1) PRIMARY KEY,
+				started_at DATETIME NOT NULL,
+				finished_at DATETIME NULL,
+				dry_run BIT NOT NULL,
+				triggered_by NVARCHAR(100) NOT NULL,
+				min_age_hours INT NOT NULL,
+				matched INT NOT NULL DEFAULT 0,
+				archived INT NOT NULL DEFAULT 0,
+				deleted INT NOT NULL DEFAULT 0,
+				error NVARCHAR(2000) NULL
+			);`, skpArchiveTable, skpCleanupHistoryTable))
+		return err
+	})
+}
+
+// StartSkpCleanupJob menjadwalkan pembersihan SKP (default setiap hari 02:30,
+// atur lewat SKP_CLEANUP_CRON; "off" untuk mematikan).
+func StartSkpCleanupJob() {
+	spec := envString(skpCleanupCronEnv, defaultSkpCleanupCron)
+	if strings.EqualFold(spec, "off") {
+		log.Println("SKP cleanup job disabled.")
+		return
 	}
-	query := `DELETE FROM tb_proposal_skp WHERE img IS NULL OR img = ''`
-	result, err := db.DB.Exec(query)
+	c := cron.New()
+	_, err := c.AddFunc(spec, func() {
+		run, err := RunSkpCleanup(false, "job:deleteskp", envInt(skpCleanupMinAgeEnv, defaultSkpCleanupMinAge))
+		if err != nil {
+			log.Printf("SKP cleanup failed: %v", err)
+			return
+		}
+		log.Printf("SKP cleanup finished: %d matched, %d archived, %d deleted, %d without CreatedAt skipped", run.Matched, run.Archived, run.Deleted, run.Undated)
+	})
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(Response{
-			Success: false,
-			Message: fmt.Sprintf("Gagal menghapus data: %v", err),
+		log.Printf("Error scheduling SKP cleanup job (%q): %v", spec, err)
+		return
+	}
+	c.Start()
+	log.Printf("SKP cleanup job scheduled (%s)", spec)
+}
+
+// RunSkpCleanup mengarsipkan lalu menghapus SKP tanpa gambar yang lebih tua
+// dari minAgeHours, dalam satu statement DELETE ... OUTPUT INTO sehingga hanya
+// baris yang benar-benar terhapus yang masuk arsip. Dengan dryRun hanya
+// menghitung dan menampilkan baris yang akan dihapus.
+func RunSkpCleanup(dryRun bool, triggeredBy string, minAgeHours int) (*SkpCleanupRun, error) {
+	skpCleanupRunMu.Lock()
+	defer skpCleanupRunMu.Unlock()
+
+	database := db.GetDB()
+	if database == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if err := ensureSkpCleanupTables(database); err != nil {
+		return nil, fmt.Errorf("ensure SKP cleanup tables: %w", err)
+	}
+	if minAgeHours < 1 {
+		minAgeHours = 1 // jangan pernah menyentuh upload yang sedang berjalan
+	}
+
+	src := auditSourceJob("deleteskp")
+	run := &SkpCleanupRun{StartedAt: time.Now(), DryRun: dryRun, TriggeredBy: triggeredBy, MinAgeHours: minAgeHours}
+	err := auditedQuery(src, database, fmt.Sprintf(`
+		INSERT INTO %s (started_at, dry_run, triggered_by, min_age_hours)
+		OUTPUT INSERTED.id
+		VALUES (@p1, @p2, @p3, @p4)`, skpCleanupHistoryTable),
+		[]interface{}{run.StartedAt, dryRun, triggeredBy, minAgeHours}, func(rows *sql.Rows) error {
+			return rows.Scan(&run.ID)
 		})
+	if err != nil {
+		return nil, fmt.Errorf("start history: %w", err)
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	runErr := runSkpCleanup(src, database, run)
+	finishSkpCleanupRun(src, database, run, runErr)
+	return run, runErr
+}
+
+func runSkpCleanup(src sqlAuditSource, database *sql.DB, run *SkpCleanupRun) error {
+	err := auditedQuery(src, database, `SELECT COUNT(*) FROM tb_proposal_skp WHERE `+skpCleanupCondition,
+		[]interface{}{run.MinAgeHours}, func(rows *sql.Rows) error { return rows.Scan(&run.Matched) })
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(Response{
-			Success: false,
-			Message: "Gagal mengonfirmasi penghapusan.",
-		})
+		return fmt.Errorf("count: %w", err)
 	}
-	if rowsAffected == 0 {
-		return c.Status(fiber.StatusOK).JSON(Response{
-			Success: true,
-			Message: "Tidak ada baris yang terpengaruh, tidak ada data yang perlu dihapus.",
-		})
+	err = auditedQuery(src, database, `SELECT COUNT(*) FROM tb_proposal_skp WHERE `+skpUndatedCondition,
+		nil, func(rows *sql.Rows) error { return rows.Scan(&run.Undated) })
+	if err != nil {
+		return fmt.Errorf("count undated: %w", err)
 	}
-	return c.Status(fiber.StatusOK).JSON(Response{
-		Success: true,
-		Message: fmt.Sprintf("%d baris berhasil dihapus.", rowsAffected),
-	})
+
+	if run.DryRun {
+		if run.Rows, err = previewSkpCleanupRows(src, database, skpCleanupCondition, run.MinAgeHours); err != nil {
+			return err
+		}
+		run.UndatedRows, err = previewSkpCleanupRows(src, database, skpUndatedCondition)
+		return err
+	}
+	if run.Matched == 0 {
+		return nil
+	}
+
+	res, err := auditedExec(src, database, fmt.Sprintf(`
+		DELETE TOP (@p2) FROM tb_proposal_skp
+		OUTPUT DELETED.*, GETDATE(), @p3 INTO %s
+		WHERE %s`, skpArchiveTable, skpCleanupCondition),
+		run.MinAgeHours, envInt(skpCleanupMaxRowsEnv, defaultSkpCleanupMaxRows), run.ID)
+	if err != nil {
+		return fmt.Errorf("archive and delete: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		run.Deleted = n
+		run.Archived = n
+	}
+	return nil
 }
 
-func RunDeleteSkpTask() {
-	if db.DB == nil {
-		return
+// previewSkpCleanupRows mengambil contoh baris (maks skpCleanupPreviewRows) untuk dry run.
+func previewSkpCleanupRows(src sqlAuditSource, database *sql.DB, condition string, args ...interface{}) ([]SkpCleanupRow, error) {
+	out := []SkpCleanupRow{}
+	err := auditedQuery(src, database, fmt.Sprintf(`
+		SELECT TOP %d id, ProposalNumber, NoSKP, img, CreatedAt
+		FROM tb_proposal_skp WHERE %s ORDER BY id`, skpCleanupPreviewRows, condition),
+		args, func(rows *sql.Rows) error {
+			var r SkpCleanupRow
+			if err := rows.Scan(&r.ID, &r.ProposalNumber, &r.NoSKP, &r.Img, &r.CreatedAt); err != nil {
+				return err
+			}
+			out = append(out, r)
+			return nil
+		})
+	return out, err
+}
+
+func finishSkpCleanupRun(src sqlAuditSource, database *sql.DB, run *SkpCleanupRun, runErr error) {
+	run.FinishedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	var errText interface{}
+	if runErr != nil {
+		run.Error = runErr.Error()
+		errText = run.Error
 	}
-	query := `DELETE FROM tb_proposal_skp WHERE img IS NULL OR img = '' or img like '%?%'`
-	result, err := db.DB.Exec(query)
+	_, err := auditedExec(src, database, fmt.Sprintf(`
+		UPDATE %s SET finished_at = @p2, matched = @p3, archived = @p4, deleted = @p5, error = @p6
+		WHERE id = @p1`, skpCleanupHistoryTable),
+		run.ID, run.FinishedAt.Time, run.Matched, run.Archived, run.Deleted, errText)
 	if err != nil {
-		return
+		log.Printf("Error recording SKP cleanup run %d: %v", run.ID, err)
+	}
+}
+
+// SkpCleanupHandler: GET menampilkan laporan dry run, POST menjalankan
+// pembersihan sekarang. ?min_age_hours= mengganti batas umur default.
+func SkpCleanupHandler(c *fiber.Ctx) error {
+	minAge := envInt(skpCleanupMinAgeEnv, defaultSkpCleanupMinAge)
+	if v := c.Query("min_age_hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "min_age_hours must be a positive number."})
+		}
+		minAge = n
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	dryRun := c.Method() != fiber.MethodPost
+	by := "admin"
+	if user := requestUser(c); user != "" {
+		by = "admin:" + user
+	}
+	run, err := RunSkpCleanup(dryRun, by, minAge)
 	if err != nil {
-		return
+		log.Printf("Error running SKP cleanup (dry run %v): %v", dryRun, err)
+		if run == nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"success": false, "message": err.Error(), "run": run})
 	}
-	if rowsAffected > 0 {
-		return
+	return c.JSON(fiber.Map{"success": true, "run": run})
+}
+
+// SkpCleanupHistoryHandler mengembalikan riwayat run pembersihan SKP (?limit=, default 50).
+func SkpCleanupHistoryHandler(c *fiber.Ctx) error {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	database := db.GetDB()
+	if err := ensureSkpCleanupTables(database); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: err.Error()})
+	}
+
+	runs := []SkpCleanupRun{}
+	err := auditedQuery(auditSourceFromCtx(c), database, fmt.Sprintf(`
+		SELECT TOP %d id, started_at, finished_at, dry_run, triggered_by, min_age_hours, matched, archived, deleted, ISNULL(error, '')
+		FROM %s ORDER BY id DESC`, limit, skpCleanupHistoryTable), nil, func(rows *sql.Rows) error {
+		var r SkpCleanupRun
+		if err := rows.Scan(&r.ID, &r.StartedAt, &r.FinishedAt, &r.DryRun, &r.TriggeredBy, &r.MinAgeHours,
+			&r.Matched, &r.Archived, &r.Deleted, &r.Error); err != nil {
+			return err
+		}
+		runs = append(runs, r)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error loading SKP cleanup history: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to retrieve history."})
 	}
+	return c.JSON(fiber.Map{"success": true, "runs": runs})
 }
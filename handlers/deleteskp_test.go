@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSkpCleanupConditionSkipsUndatedRows(t *testing.T) {
+	if strings.Contains(skpCleanupCondition, "CreatedAt IS NULL") {
+		t.Fatalf("cleanup must not select rows without CreatedAt: %s", skpCleanupCondition)
+	}
+	if !strings.Contains(skpCleanupCondition, "CreatedAt IS NOT NULL") {
+		t.Fatalf("cleanup must require CreatedAt: %s", skpCleanupCondition)
+	}
+	for _, cond := range []string{skpCleanupCondition, skpUndatedCondition} {
+		if !strings.HasPrefix(cond, skpNoImageCondition) {
+			t.Errorf("condition does not start with the no-image filter: %s", cond)
+		}
+	}
+	if !strings.HasSuffix(skpUndatedCondition, "CreatedAt IS NULL") {
+		t.Errorf("undated report must select rows without CreatedAt: %s", skpUndatedCondition)
+	}
+}
+
+func TestSkpCleanupHandlerRejectsInvalidMinAge(t *testing.T) {
+	app := fiber.New()
+	app.Get("/skp/cleanup", SkpCleanupHandler)
+	for _, v := range []string{"0", "-3", "abc"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/skp/cleanup?min_age_hours="+v, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Errorf("min_age_hours=%s: expected 400, got %d", v, resp.StatusCode)
+		}
+	}
+}
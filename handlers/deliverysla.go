@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/robfig/cron/v3"
+	"my-fiber-app/db"
+)
+
+// Pengaturan job peringatan SLA pengiriman.
+const (
+	deliverySLACronEnv         = "DELIVERY_SLA_CRON"
+	deliverySLADefaultHoursEnv = "DELIVERY_SLA_DEFAULT_HOURS"
+	deliverySLADefaultWAEnv    = "DELIVERY_SLA_DEFAULT_WA"
+	deliverySLALookbackEnv     = "DELIVERY_SLA_LOOKBACK_DAYS"
+
+	defaultDeliverySLACron     = "0 * * * *" // setiap jam
+	defaultDeliverySLAHours    = 24
+	defaultDeliverySLALookback = 7
+	deliverySLADigestMaxLines  = 40
+
+	// deliverySLAConfigTable menyimpan batas jam dan nomor WhatsApp per dept.
+	deliverySLAConfigTable = "dbo.tb_delivery_sla"
+	// deliverySLALedgerTable mencatat SJ yang sudah dilaporkan agar tidak dikirim ulang.
+	deliverySLALedgerTable = "dbo.tb_delivery_sla_ledger"
+)
+
+var (
+	deliverySLATables tableSetup
+	deliverySLARunMu  sync.Mutex // satu proses digest dalam satu waktu
+)
+
+// deliverySLAConfig adalah batas SLA satu departemen.
+type deliverySLAConfig struct {
+	Hours    int
+	WANumber string
+}
+
+// DeliverySLABreach adalah satu SJ yang melewati SLA tanpa konfirmasi kirim.
+type DeliverySLABreach struct {
+	Dept      string `json:"dept"`
+	Manifes   int    `json:"manifes"`
+	SJ        int    `json:"sj"`
+	ShipDate  string `json:"ship_date"`
+	Cardname  string `json:"cardname"`
+	Driver    string `json:"driver"`
+	NoPol     string `json:"no_pol"`
+	HoursLate int    `json:"hours_late"`
+	SLAHours  int    `json:"sla_hours"`
+	WANumber  string `json:"wa_number,omitempty"`
+}
+
+// ensureDeliverySLATables membuat tabel konfigurasi dan ledger bila belum ada.
+func ensureDeliverySLATables(database *sql.DB) error {
+	return deliverySLATables.ensure(func() error {
+		_, err := database.Exec(fmt.Sprintf(`
+			IF OBJECT_ID('%[1]s', 'U') IS NULL
+			CREATE TABLE %[1]s (
+				dept NVARCHAR(50) NOT NULL PRIMARY KEY,
+				sla_hours INT NOT NULL,
+				wa_number NVARCHAR(30) NULL,
+				active BIT NOT NULL DEFAULT 1
+			);
+			IF OBJECT_ID('%[2]s', 'U') IS NULL
+			CREATE TABLE %[2]s (
+				no_manifes INT NOT NULL,
+				sj INT NOT NULL,
+				dept NVARCHAR(50) NULL,
+				ship_date DATE NULL,
+				hours_late INT NOT NULL,
+				wa_number NVARCHAR(30) NULL,
+				reported_at DATETIME NOT NULL DEFAULT GETDATE(),
+				CONSTRAINT PK_tb_delivery_sla_ledger PRIMARY KEY (no_manifes, sj)
+			);`, deliverySLAConfigTable, deliverySLALedgerTable))
+		return err
+	})
+}
+
+// StartDeliverySLAJob menjadwalkan digest pelanggaran SLA (default setiap jam,
+// atur lewat DELIVERY_SLA_CRON; "off" untuk mematikan).
+func StartDeliverySLAJob() {
+	spec := envString(deliverySLACronEnv, defaultDeliverySLACron)
+	if strings.EqualFold(spec, "off") {
+		log.Println("Delivery SLA job disabled.")
+		return
+	}
+
+	c := cron.New()
+	_, err := c.AddFunc(spec, func() {
+		sent, err := RunDeliverySLADigest(false)
+		if err != nil {
+			log.Printf("Delivery SLA digest failed: %v", err)
+			return
+		}
+		log.Printf("Delivery SLA digest finished, %d breach(es) reported", len(sent))
+	})
+	if err != nil {
+		log.Printf("Error scheduling delivery SLA job (%q): %v", spec, err)
+		return
+	}
+	c.Start()
+	log.Printf("Delivery SLA job scheduled (%s)", spec)
+}
+
+// RunDeliverySLADigest mencari SJ tanpa status me_manifest yang melewati batas
+// jam departemennya dan belum ada di ledger, lalu mengirim satu pesan WhatsApp
+// per departemen. Breach dicatat ke ledger hanya setelah pesan terkirim.
+// Dengan dryRun tidak ada pesan atau ledger yang ditulis.
+func RunDeliverySLADigest(dryRun bool) ([]DeliverySLABreach, error) {
+	deliverySLARunMu.Lock()
+	defer deliverySLARunMu.Unlock()
+
+	database := db.GetDB()
+	if database == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if err := ensureDeliverySLATables(database); err != nil {
+		return nil, fmt.Errorf("ensure SLA tables: %w", err)
+	}
+
+	src := auditSourceJob("deliverysla")
+	breaches, err := findDeliverySLABreaches(src, database, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if dryRun || len(breaches) == 0 {
+		return breaches, nil
+	}
+
+	byDept := map[string][]DeliverySLABreach{}
+	for _, b := range breaches {
+		byDept[b.Dept] = append(byDept[b.Dept], b)
+	}
+
+	var reported []DeliverySLABreach
+	for dept, list := range byDept {
+		number := list[0].WANumber
+		if number == "" {
+			log.Printf("Delivery SLA: no WhatsApp contact for dept %q, %d breach(es) not sent", dept, len(list))
+			continue
+		}
+		if _, err := sendToWhatsAppAPI(number, formatDeliverySLADigest(dept, list), ""); err != nil {
+			log.Printf("Delivery SLA: failed to send digest for dept %q to %s: %v", dept, number, err)
+			continue
+		}
+		if err := recordDeliverySLALedger(src, database, list); err != nil {
+			// Pesan sudah terkirim; catat error agar ledger bisa diperbaiki manual.
+			log.Printf("Delivery SLA: digest sent for dept %q but ledger write failed: %v", dept, err)
+			continue
+		}
+		reported = append(reported, list...)
+	}
+	return reported, nil
+}
+
+// findDeliverySLABreaches memuat SJ beberapa hari terakhir (berdasarkan
+// SHIP_DATE) tanpa status kirim dan memfilter yang melewati SLA dept-nya.
+func findDeliverySLABreaches(src sqlAuditSource, database *sql.DB, now time.Time) ([]DeliverySLABreach, error) {
+	configs, err := loadDeliverySLAConfigs(src, database)
+	if err != nil {
+		return nil, err
+	}
+	defaultCfg := deliverySLAConfig{
+		Hours:    envInt(deliverySLADefaultHoursEnv, defaultDeliverySLAHours),
+		WANumber: envString(deliverySLADefaultWAEnv, ""),
+	}
+
+	lookback := envInt(deliverySLALookbackEnv, defaultDeliverySLALookback)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	req := manifestListRequest{Dates: &manifestDateRange{Field: "ship_date", From: today.AddDate(0, 0, -lookback), To: today}}
+
+	var args boundArgs
+	query := fmt.Sprintf(`
+		SELECT d.dept, d.manifes, d.sj, d.ship_date, d.cardname, d.driver, d.no_pol
+		FROM (%s) AS d
+		WHERE d.gr_status_me = ''
+			AND NOT EXISTS (SELECT 1 FROM %s l WHERE l.no_manifes = d.manifes AND l.sj = d.sj)`,
+		manifestBaseQuery+manifestBaseFilter(req, &args), deliverySLALedgerTable)
+
+	var candidates []DeliverySLABreach
+	err = auditedQuery(src, database, query, args, func(rows *sql.Rows) error {
+		var b DeliverySLABreach
+		var dept, shipDate, cardname, driver, noPol sql.NullString
+		if err := rows.Scan(&dept, &b.Manifes, &b.SJ, &shipDate, &cardname, &driver, &noPol); err != nil {
+			return err
+		}
+		b.Dept, b.ShipDate, b.Cardname, b.Driver, b.NoPol = dept.String, shipDate.String, cardname.String, driver.String, noPol.String
+		candidates = append(candidates, b)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query SLA breaches: %w", err)
+	}
+	return filterDeliverySLABreaches(candidates, configs, defaultCfg, now), nil
+}
+
+// filterDeliverySLABreaches menyisakan SJ yang umurnya (jam sejak awal hari
+// SHIP_DATE) sudah mencapai batas dept-nya; dept tanpa konfigurasi memakai
+// defaultCfg dan batas <= 0 berarti SLA dimatikan.
+func filterDeliverySLABreaches(candidates []DeliverySLABreach, configs map[string]deliverySLAConfig, defaultCfg deliverySLAConfig, now time.Time) []DeliverySLABreach {
+	breaches := []DeliverySLABreach{}
+	for _, b := range candidates {
+		cfg, ok := configs[b.Dept]
+		if !ok {
+			cfg = defaultCfg
+		}
+		if cfg.Hours <= 0 {
+			continue
+		}
+		ship, err := time.ParseInLocation("2006-01-02", b.ShipDate, now.Location())
+		if err != nil {
+			continue
+		}
+		hours := int(now.Sub(ship).Hours())
+		if hours < cfg.Hours {
+			continue
+		}
+		b.HoursLate, b.SLAHours, b.WANumber = hours, cfg.Hours, cfg.WANumber
+		breaches = append(breaches, b)
+	}
+
+	sort.Slice(breaches, func(i, j int) bool {
+		a, b := breaches[i], breaches[j]
+		if a.Dept != b.Dept {
+			return a.Dept < b.Dept
+		}
+		if a.HoursLate != b.HoursLate {
+			return a.HoursLate > b.HoursLate
+		}
+		return a.SJ < b.SJ
+	})
+	return breaches
+}
+
+// loadDeliverySLAConfigs membaca konfigurasi aktif per departemen.
+func loadDeliverySLAConfigs(src sqlAuditSource, database *sql.DB) (map[string]deliverySLAConfig, error) {
+	configs := map[string]deliverySLAConfig{}
+	query := fmt.Sprintf("SELECT dept, sla_hours, wa_number FROM %s WHERE active = 1", deliverySLAConfigTable)
+	err := auditedQuery(src, database, query, nil, func(rows *sql.Rows) error {
+		var dept string
+		var cfg deliverySLAConfig
+		var wa sql.NullString
+		if err := rows.Scan(&dept, &cfg.Hours, &wa); err != nil {
+			return err
+		}
+		cfg.WANumber = wa.String
+		if cfg.WANumber == "" {
+			cfg.WANumber = envString(deliverySLADefaultWAEnv, "")
+		}
+		configs[dept] = cfg
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load SLA config: %w", err)
+	}
+	return configs, nil
+}
+
+// recordDeliverySLALedger mencatat breach yang sudah dilaporkan dalam satu transaksi.
+func recordDeliverySLALedger(src sqlAuditSource, database *sql.DB, list []DeliverySLABreach) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert := fmt.Sprintf(`
+		IF NOT EXISTS (SELECT 1 FROM %[1]s WHERE no_manifes = @p1 AND sj = @p2)
+		INSERT INTO %[1]s (no_manifes, sj, dept, ship_date, hours_late, wa_number)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6)`, deliverySLALedgerTable)
+	for _, b := range list {
+		if _, err := auditedTxExec(src, tx, insert, b.Manifes, b.SJ, b.Dept, b.ShipDate, b.HoursLate, b.WANumber); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// formatDeliverySLADigest menyusun pesan WhatsApp untuk satu departemen.
+func formatDeliverySLADigest(dept string, list []DeliverySLABreach) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*Peringatan SLA Pengiriman - %s*\n", valueOr(dept, "(tanpa dept)"))
+	fmt.Fprintf(&sb, "%d SJ belum ada konfirmasi kirim lebih dari %d jam setelah tanggal kirim:\n\n", len(list), list[0].SLAHours)
+	for i, b := range list {
+		if i == deliverySLADigestMaxLines {
+			fmt.Fprintf(&sb, "...dan %d SJ lainnya\n", len(list)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "%d. SJ %d / Manifes %d - %s\n   Kirim %s, %s (%s), %d jam\n",
+			i+1, b.SJ, b.Manifes, b.Cardname, b.ShipDate, b.Driver, b.NoPol, b.HoursLate)
+	}
+	sb.WriteString("\nMohon segera ditindaklanjuti.")
+	return sb.String()
+}
+
+// DeliverySLABreachesHandler: GET menampilkan breach yang akan dilaporkan
+// (dry run), POST menjalankan digest sekarang.
+func DeliverySLABreachesHandler(c *fiber.Ctx) error {
+	run := c.Method() == fiber.MethodPost
+	breaches, err := RunDeliverySLADigest(!run)
+	if err != nil {
+		log.Printf("Error running delivery SLA digest: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: err.Error()})
+	}
+	if breaches == nil {
+		breaches = []DeliverySLABreach{}
+	}
+	return c.JSON(fiber.Map{
+		"success":  true,
+		"dry_run":  !run,
+		"count":    len(breaches),
+		"breaches": breaches,
+	})
+}
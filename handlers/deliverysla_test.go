@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterDeliverySLABreaches(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	configs := map[string]deliverySLAConfig{
+		"JKT": {Hours: 24, WANumber: "62811"},
+		"OFF": {Hours: 0, WANumber: "62812"},
+	}
+	defaultCfg := deliverySLAConfig{Hours: 48, WANumber: "62800"}
+	candidates := []DeliverySLABreach{
+		{Dept: "JKT", SJ: 1, ShipDate: "2025-03-09"}, // 36 jam, lewat 24
+		{Dept: "JKT", SJ: 2, ShipDate: "2025-03-10"}, // 12 jam, belum
+		{Dept: "JKT", SJ: 3, ShipDate: "2025-03-08"}, // 60 jam
+		{Dept: "OFF", SJ: 4, ShipDate: "2025-03-01"}, // SLA dimatikan
+		{Dept: "SBY", SJ: 5, ShipDate: "2025-03-09"}, // default 48, belum
+		{Dept: "SBY", SJ: 6, ShipDate: "2025-03-08"}, // 60 jam
+		{Dept: "SBY", SJ: 7, ShipDate: ""},
+	}
+
+	got := filterDeliverySLABreaches(candidates, configs, defaultCfg, now)
+	var sjs []int
+	for _, b := range got {
+		sjs = append(sjs, b.SJ)
+	}
+	want := []int{3, 1, 6}
+	if len(sjs) != len(want) {
+		t.Fatalf("got SJ %v, want %v", sjs, want)
+	}
+	for i := range want {
+		if sjs[i] != want[i] {
+			t.Fatalf("got SJ %v, want %v", sjs, want)
+		}
+	}
+	if got[0].HoursLate != 60 || got[0].SLAHours != 24 || got[0].WANumber != "62811" {
+		t.Errorf("unexpected breach %+v", got[0])
+	}
+	if got[2].SLAHours != 48 || got[2].WANumber != "62800" {
+		t.Errorf("default config not applied: %+v", got[2])
+	}
+}
+
+func TestFormatDeliverySLADigestTruncates(t *testing.T) {
+	list := make([]DeliverySLABreach, deliverySLADigestMaxLines+5)
+	for i := range list {
+		list[i] = DeliverySLABreach{SJ: i + 1, SLAHours: 24}
+	}
+	msg := formatDeliverySLADigest("JKT", list)
+	if !strings.Contains(msg, "...dan 5 SJ lainnya") {
+		t.Errorf("digest not truncated:\n%s", msg)
+	}
+	if !strings.Contains(msg, "45 SJ belum ada konfirmasi") {
+		t.Errorf("digest count missing:\n%s", msg)
+	}
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// DriverStop adalah satu SJ yang harus diantar, dalam urutan baris manifes.
+type DriverStop struct {
+	Seq         int             `json:"seq"`
+	SJ          int             `json:"sj"`
+	POCustomer  string          `json:"po_customer"`
+	CardCode    string          `json:"cardcode"`
+	Cardname    string          `json:"cardname"`
+	ShipTo      string          `json:"ship_to"`
+	Address     string          `json:"address"`
+	City        string          `json:"city"`
+	ContactName string          `json:"contact_name"`
+	Phone       string          `json:"phone"`
+	Lat         sql.NullFloat64 `json:"lat"`
+	Lon         sql.NullFloat64 `json:"lon"`
+	Status      string          `json:"status"`
+	Reason      string          `json:"reason,omitempty"`
+	Outstanding bool            `json:"outstanding"`
+}
+
+// DriverManifest adalah satu manifes milik driver beserta daftar pemberhentiannya.
+type DriverManifest struct {
+	Manifes     int          `json:"manifes"`
+	ShipDate    string       `json:"ship_date"`
+	Driver      string       `json:"driver"`
+	NoPol       string       `json:"no_pol"`
+	KodeRute    string       `json:"kode_rute"`
+	Outstanding int          `json:"outstanding"`
+	Stops       []DriverStop `json:"stops"`
+}
+
+// DriverDeliveriesResponse adalah respons /pkexpress/driver/deliveries.
+type DriverDeliveriesResponse struct {
+	Success     bool             `json:"success"`
+	Date        string           `json:"date"`
+	Driver      string           `json:"driver,omitempty"`
+	Plate       string           `json:"plate,omitempty"`
+	Total       int              `json:"total"`
+	Outstanding int              `json:"outstanding"`
+	Manifests   []DriverManifest `json:"manifests"`
+}
+
+// DriverDeliveriesHandler mengembalikan manifes dan SJ hari ini untuk seorang
+// driver, dalam urutan antar, lengkap dengan kontak, alamat, koordinat dan
+// status. Pemanggil harus login (RequireUser): driver hanya melihat manifes
+// atas namanya sendiri (master_user.fullname = nama supir di manifes), ?plate=
+// (U_IDU_NoPol) mempersempit hasil. Admin boleh memilih ?plate= atau ?driver=.
+// ?date=YYYY-MM-DD untuk hari lain.
+func DriverDeliveriesHandler(c *fiber.Ctx) error {
+	plate := normalizePlate(c.Query("plate"))
+	driver := strings.TrimSpace(c.Query("driver"))
+	src := auditSourceFromCtx(c)
+
+	if user := authenticatedUser(c); user != "" {
+		name, err := driverNameForUser(src, db.GetDB(), user)
+		if err != nil {
+			log.Printf("Error resolving driver for user %q: %v", user, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to resolve driver."})
+		}
+		if name == "" {
+			return c.Status(fiber.StatusForbidden).JSON(Response{Success: false, Message: "User " + user + " is not registered as a driver."})
+		}
+		driver = name
+	} else if !isAdminRequest(c) {
+		return c.Status(fiber.StatusUnauthorized).JSON(Response{Success: false, Message: "Login required (X-User-Token)."})
+	}
+	if plate == "" && driver == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Provide plate or driver."})
+	}
+
+	day := time.Now()
+	if raw := c.Query("date"); raw != "" {
+		d, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid date, use YYYY-MM-DD."})
+		}
+		day = d
+	}
+
+	resp, err := loadDriverDeliveries(src, db.GetDB(), day, driver, plate)
+	if err != nil {
+		log.Printf("Error loading deliveries for driver %q plate %q: %v", driver, plate, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to retrieve deliveries."})
+	}
+	return c.JSON(resp)
+}
+
+// driverNameForUser memetakan user yang login ke nama supir di manifes
+// (master_user.fullname). "" berarti user tidak terdaftar.
+func driverNameForUser(src sqlAuditSource, database *sql.DB, user string) (string, error) {
+	var name sql.NullString
+	err := auditedQuery(src, database, "SELECT TOP 1 fullname FROM master_user WHERE user_code = @p1", []interface{}{user}, func(rows *sql.Rows) error {
+		return rows.Scan(&name)
+	})
+	return strings.TrimSpace(name.String), err
+}
+
+// normalizePlate menghapus spasi dan menyeragamkan huruf besar ("b 1234 xy" -> "B1234XY").
+func normalizePlate(p string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(p), ""))
+}
+
+func loadDriverDeliveries(src sqlAuditSource, database *sql.DB, day time.Time, driver, plate string) (*DriverDeliveriesResponse, error) {
+	var args boundArgs
+	from := args.bind(day.Format("20060102"))
+	to := args.bind(day.AddDate(0, 0, 1).Format("20060102"))
+	where := fmt.Sprintf("t2.U_IDU_TANGGAL >= %s AND t2.U_IDU_TANGGAL < %s", from, to)
+	if plate != "" {
+		where += " AND UPPER(REPLACE(t2.U_IDU_NoPol, ' ', '')) = " + args.bind(plate)
+	}
+	if driver != "" {
+		where += " AND t2.U_IDU_NAMASUPIR = " + args.bind(driver)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT t2.DocNum, CONVERT(VARCHAR, t2.U_IDU_TANGGAL, 23), t2.U_IDU_NAMASUPIR, t2.U_IDU_NoPol, t2.U_IDU_Kode_Rute,
+			t0.LineId, dd.DocNum, dd.NumAtCard, dd.CardCode, dd.CardName, dd.ShipToCode,
+			COALESCE(NULLIF(mc.alamat, ''), dd.Address2), BP1.City, BP.CntctPrsn, COALESCE(NULLIF(BP.Cellular, ''), BP.Phone1),
+			mc.lat, mc.lon, ms.[status], mr.reason
+		FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK)
+		INNER JOIN [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_d_manifest] t0 WITH (NOLOCK) ON t0.DocEntry = t2.DocEntry
+		INNER JOIN [pksrv-sap].[pandurasa_live].dbo.ODLN dd WITH (NOLOCK) ON dd.DocNum = t0.U_IDU_NomorDO AND dd.CANCELED = 'N'
+		INNER JOIN [pksrv-sap].[pandurasa_live].dbo.OCRD BP WITH (NOLOCK) ON BP.CardCode = dd.CardCode
+		LEFT JOIN [pksrv-sap].[pandurasa_live].dbo.CRD1 BP1 WITH (NOLOCK) ON BP1.CardCode = dd.CardCode AND BP1.[Address] = dd.ShipToCode AND BP1.AdresType = 'S'
+		OUTER APPLY (
+			SELECT TOP 1 c.alamat, c.lat, c.lon
+			FROM [pksrv-sap].pk_express.dbo.master_customer c
+			WHERE c.cardcode = dd.CardCode
+			ORDER BY CASE WHEN c.address = dd.ShipToCode THEN 0 ELSE 1 END, c.id
+		) mc
+		`+manifestLatestEvent("dd.DocNum", "t2.DocNum")+`
+		LEFT JOIN [pksrv-sap].[pk_express].[dbo].master_status ms ON mm.[status] = ms.id
+		LEFT JOIN [pksrv-sap].[pk_express].[dbo].master_reason mr ON mr.id = mm.reason
+		WHERE %s
+		ORDER BY t2.DocNum, t0.LineId`, where)
+
+	resp := &DriverDeliveriesResponse{
+		Success:   true,
+		Date:      day.Format("2006-01-02"),
+		Driver:    driver,
+		Plate:     plate,
+		Manifests: []DriverManifest{},
+	}
+	index := map[int]int{}
+	err := auditedQuery(src, database, query, args, func(rows *sql.Rows) error {
+		var m DriverManifest
+		var s DriverStop
+		var shipDate, drv, noPol, rute, po, cardname, shipTo, address, city, contact, phone, status, reason sql.NullString
+		var line sql.NullInt64
+		if err := rows.Scan(&m.Manifes, &shipDate, &drv, &noPol, &rute,
+			&line, &s.SJ, &po, &s.CardCode, &cardname, &shipTo,
+			&address, &city, &contact, &phone,
+			&s.Lat, &s.Lon, &status, &reason); err != nil {
+			return err
+		}
+		s.POCustomer, s.Cardname, s.ShipTo = po.String, cardname.String, shipTo.String
+		s.Address, s.City, s.ContactName, s.Phone = address.String, city.String, contact.String, phone.String
+		s.Status, s.Reason = status.String, reason.String
+		bucket := manifestStatusBucket(s.Status)
+		s.Outstanding = bucket == manifestStatusNone || bucket == manifestStatusPending
+
+		i, ok := index[m.Manifes]
+		if !ok {
+			m.ShipDate, m.Driver, m.NoPol, m.KodeRute = shipDate.String, drv.String, noPol.String, rute.String
+			m.Stops = []DriverStop{}
+			i = len(resp.Manifests)
+			index[m.Manifes] = i
+			resp.Manifests = append(resp.Manifests, m)
+		}
+		dm := &resp.Manifests[i]
+		s.Seq = len(dm.Stops) + 1
+		dm.Stops = append(dm.Stops, s)
+		resp.Total++
+		if s.Outstanding {
+			dm.Outstanding++
+			resp.Outstanding++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// explainParam is the query parameter (and X-Explain header) that switches
+// the generic query handlers into explain mode. It is excluded from cache keys.
+const explainParam = "explain"
+
+const (
+	explainModeSQL  = "sql"
+	explainModePlan = "plan"
+)
+
+// GenericQueryExplain describes what a generic query handler would do for
+// the current request, without executing the statement.
+type GenericQueryExplain struct {
+	Mode            string   `json:"mode"`
+	Route           string   `json:"route"`
+	SQL             string   `json:"sql"`
+	Params          []string `json:"params"`
+	Fingerprint     string   `json:"fingerprint"`
+	CacheKey        string   `json:"cache_key"`
+	CacheFile       string   `json:"cache_file"`
+	CacheBehavior   string   `json:"cache_behavior"`
+	CacheDurationS  float64  `json:"cache_duration_seconds"`
+	CacheState      string   `json:"cache_state"`
+	CacheAgeSeconds *float64 `json:"cache_age_seconds,omitempty"`
+	PlanXML         string   `json:"plan_xml,omitempty"`
+	PlanError       string   `json:"plan_error,omitempty"`
+}
+
+// explainMode returns explainModeSQL or explainModePlan when the request asks
+// for explain mode via ?explain=1|plan or the X-Explain header, "" otherwise.
+func explainMode(c *fiber.Ctx) string {
+	v := c.Query(explainParam)
+	if v == "" {
+		v = c.Get("X-Explain")
+	}
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "0", "false":
+		return ""
+	case explainModePlan:
+		return explainModePlan
+	default:
+		return explainModeSQL
+	}
+}
+
+// explainGenericQuery answers an explain request for GenericQueryHandler and
+// GenericHtmlQueryHandler. Only admins may use it since it reveals SQL.
+func explainGenericQuery(c *fiber.Ctx, mode, query string, params []interface{}, cacheKey, cacheFilePath string, cacheDuration time.Duration, cacheBehavior string) error {
+	if !isAdminRequest(c) {
+		return c.Status(fiber.StatusForbidden).JSON(Response{
+			Success: false,
+			Message: "Explain mode requires admin access.",
+		})
+	}
+
+	fingerprint, _ := sqlFingerprint(query)
+	out := GenericQueryExplain{
+		Mode:           mode,
+		Route:          auditSourceFromCtx(c).Route,
+		SQL:            query,
+		Params:         make([]string, 0, len(params)),
+		Fingerprint:    fingerprint,
+		CacheKey:       cacheKey,
+		CacheFile:      cacheFilePath,
+		CacheBehavior:  cacheBehavior,
+		CacheDurationS: cacheDuration.Seconds(),
+	}
+	for _, p := range params {
+		out.Params = append(out.Params, fmt.Sprintf("%T: %v", p, p))
+	}
+
+	switch info, err := os.Stat(cacheFilePath); {
+	case cacheDuration <= 0:
+		out.CacheState = "disabled"
+	case os.IsNotExist(err):
+		out.CacheState = "missing"
+	case err != nil:
+		out.CacheState = "error: " + err.Error()
+	default:
+		age := time.Since(info.ModTime()).Seconds()
+		out.CacheAgeSeconds = &age
+		if time.Since(info.ModTime()) < cacheDuration {
+			out.CacheState = "fresh"
+		} else {
+			out.CacheState = "stale"
+		}
+	}
+
+	if mode == explainModePlan {
+		plan, err := fetchEstimatedPlan(query, supportedDBParams(params)...)
+		if err != nil {
+			log.Printf("Error estimating plan for explain request on %s: %v", out.Route, err)
+			out.PlanError = err.Error()
+		} else {
+			out.PlanXML = plan
+		}
+	}
+
+	return c.JSON(out)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestExplainMode(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString(explainMode(c)) })
+
+	cases := []struct {
+		query, header, want string
+	}{
+		{"", "", ""},
+		{"?explain=0", "", ""},
+		{"?explain=false", "", ""},
+		{"?explain=1", "", explainModeSQL},
+		{"?explain=PLAN", "", explainModePlan},
+		{"", "plan", explainModePlan},
+		{"?explain=sql", "plan", explainModeSQL},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/"+tc.query, nil)
+		if tc.header != "" {
+			req.Header.Set("X-Explain", tc.header)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		n, _ := resp.Body.Read(buf)
+		if got := string(buf[:n]); got != tc.want {
+			t.Errorf("query %q header %q: got %q, want %q", tc.query, tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestExplainGenericQueryRequiresAdmin(t *testing.T) {
+	t.Setenv(adminTokenEnv, "rahasia")
+	cacheFile := filepath.Join(t.TempDir(), "x.json")
+
+	app := fiber.New()
+	app.Get("/q", func(c *fiber.Ctx) error {
+		return explainGenericQuery(c, explainMode(c), "SELECT * FROM tb_proposal WHERE Number = @p1",
+			[]interface{}{"P-001"}, "q_abc", cacheFile, 5*time.Minute, "read")
+	})
+
+	for _, token := range []string{"", "salah"} {
+		req := httptest.NewRequest("GET", "/q?explain=1", nil)
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != fiber.StatusForbidden {
+			t.Fatalf("token %q: expected 403, got %d", token, resp.StatusCode)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/q?explain=1", nil)
+	req.Header.Set("X-Admin-Token", "rahasia")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("admin: expected 200, got %d", resp.StatusCode)
+	}
+	var out GenericQueryExplain
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Mode != explainModeSQL || out.CacheKey != "q_abc" || out.CacheState != "missing" {
+		t.Fatalf("unexpected explain output %+v", out)
+	}
+	if len(out.Params) != 1 || out.Params[0] != "string: P-001" || out.Fingerprint == "" {
+		t.Fatalf("unexpected params/fingerprint %+v", out)
+	}
+	if out.PlanXML != "" || out.PlanError != "" {
+		t.Fatalf("sql mode must not estimate a plan: %+v", out)
+	}
+}
+
+func TestExplainParamExcludedFromCacheKey(t *testing.T) {
+	app := fiber.New()
+	var keys []string
+	app.Get("/", func(c *fiber.Ctx) error {
+		keys = append(keys, strings.Join(extractQueryParamsFromContext(c), "&"))
+		return nil
+	})
+	for _, target := range []string{"/?brand=105", "/?brand=105&explain=plan"} {
+		if _, err := app.Test(httptest.NewRequest("GET", target, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 2 || keys[0] != keys[1] {
+		t.Fatalf("explain must not change the cache key: %v", keys)
+	}
+}
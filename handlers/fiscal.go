@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Pengaturan kalender fiskal ANP. Tahun fiskal Y dimulai tanggal 1 bulan
+// FISCAL_YEAR_START_MONTH tahun Y dan ditutup FISCAL_CLOSING_DAYS hari setelah
+// tahun fiskal berakhir. Data historis (DN dibayar, proposal tanpa SKP)
+// dibatasi FISCAL_HISTORY_YEARS tahun fiskal ke belakang.
+const (
+	fiscalStartMonthEnv  = "FISCAL_YEAR_START_MONTH"
+	fiscalClosingDaysEnv = "FISCAL_CLOSING_DAYS"
+	fiscalHistoryEnv     = "FISCAL_HISTORY_YEARS"
+
+	defaultFiscalStartMonth  = 1
+	defaultFiscalClosingDays = 31
+	defaultFiscalHistory     = 2
+)
+
+// FiscalCalendar menentukan batas tahun fiskal.
+type FiscalCalendar struct {
+	StartMonth   time.Month
+	ClosingDays  int
+	HistoryYears int
+	loc          *time.Location
+}
+
+// FiscalYear adalah satu tahun fiskal. End adalah hari terakhir (inklusif).
+type FiscalYear struct {
+	Year    int       `json:"fs_year"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Closing time.Time `json:"closing"`
+}
+
+// loadFiscalCalendar membaca kalender fiskal dari env.
+func loadFiscalCalendar() FiscalCalendar {
+	month := envInt(fiscalStartMonthEnv, defaultFiscalStartMonth)
+	if month < 1 || month > 12 {
+		month = defaultFiscalStartMonth
+	}
+	cal := FiscalCalendar{
+		StartMonth:   time.Month(month),
+		ClosingDays:  envInt(fiscalClosingDaysEnv, defaultFiscalClosingDays),
+		HistoryYears: envInt(fiscalHistoryEnv, defaultFiscalHistory),
+		loc:          time.Local,
+	}
+	if cal.HistoryYears < 0 {
+		cal.HistoryYears = defaultFiscalHistory
+	}
+	return cal
+}
+
+// Year mengembalikan tahun fiskal y.
+func (cal FiscalCalendar) Year(y int) FiscalYear {
+	loc := cal.loc
+	if loc == nil {
+		loc = time.Local
+	}
+	start := time.Date(y, cal.StartMonth, 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(1, 0, -1)
+	return FiscalYear{Year: y, Start: start, End: end, Closing: end.AddDate(0, 0, cal.ClosingDays)}
+}
+
+// YearOf mengembalikan tahun fiskal yang memuat tanggal t.
+func (cal FiscalCalendar) YearOf(t time.Time) FiscalYear {
+	y := t.Year()
+	if t.Month() < cal.StartMonth {
+		y--
+	}
+	return cal.Year(y)
+}
+
+// Current adalah tahun fiskal berjalan.
+func (cal FiscalCalendar) Current(now time.Time) FiscalYear { return cal.YearOf(now) }
+
+// Previous adalah tahun fiskal sebelum tahun berjalan.
+func (cal FiscalCalendar) Previous(now time.Time) FiscalYear {
+	return cal.Year(cal.YearOf(now).Year - 1)
+}
+
+// ActiveFrom adalah awal periode default daftar proposal: awal tahun fiskal
+// sebelumnya selama belum closing, selain itu awal tahun fiskal berjalan.
+func (cal FiscalCalendar) ActiveFrom(now time.Time) time.Time {
+	if prev := cal.Previous(now); !prev.Closed(now) {
+		return prev.Start
+	}
+	return cal.Current(now).Start
+}
+
+// HistoryFrom adalah batas bawah data historis.
+func (cal FiscalCalendar) HistoryFrom(now time.Time) time.Time {
+	return cal.Year(cal.YearOf(now).Year - cal.HistoryYears).Start
+}
+
+// Closed bernilai true bila closing date tahun fiskal sudah lewat.
+func (fy FiscalYear) Closed(now time.Time) bool {
+	return !now.Before(fy.Closing.AddDate(0, 0, 1))
+}
+
+// Until adalah batas atas eksklusif (awal tahun fiskal berikutnya), untuk
+// kondisi "kolom < @p".
+func (fy FiscalYear) Until() time.Time { return fy.End.AddDate(0, 0, 1) }
+
+// YearExpr mengembalikan ekspresi SQL tahun fiskal untuk kolom tanggal.
+func (cal FiscalCalendar) YearExpr(column string) string {
+	if cal.StartMonth == time.January {
+		return "YEAR(" + column + ")"
+	}
+	return fmt.Sprintf("YEAR(DATEADD(MONTH, -%d, %s))", int(cal.StartMonth)-1, column)
+}
+
+var fiscalYearToken = regexp.MustCompile(`FISCAL_YEAR\(([^()]+)\)`)
+
+// HistoryFloor adalah batas bawah data historis untuk query yang difilter
+// tahun fiskal fsYear: tahun fiskal yang diminta secara eksplisit selalu
+// terlihat walaupun lebih tua dari HistoryFrom. fsYear 0 berarti HistoryFrom.
+func (cal FiscalCalendar) HistoryFloor(now time.Time, fsYear int) time.Time {
+	from := cal.HistoryFrom(now)
+	if fsYear > 0 {
+		if start := cal.Year(fsYear).Start; start.Before(from) {
+			return start
+		}
+	}
+	return from
+}
+
+// expandFiscalSQL mengganti token kalender fiskal di query:
+// FISCAL_YEAR(kolom) menjadi ekspresi tahun fiskal dan FISCAL_HISTORY_FROM
+// menjadi literal tanggal HistoryFrom. Nilainya berasal dari konfigurasi,
+// bukan input user, jadi aman ditulis langsung.
+func (cal FiscalCalendar) expandFiscalSQL(query string, now time.Time) string {
+	return cal.expandFiscalSQLFor(query, now, 0)
+}
+
+// expandFiscalSQLFor sama dengan expandFiscalSQL, tetapi FISCAL_HISTORY_FROM
+// memakai HistoryFloor tahun fiskal fsYear yang difilter.
+func (cal FiscalCalendar) expandFiscalSQLFor(query string, now time.Time, fsYear int) string {
+	query = fiscalYearToken.ReplaceAllStringFunc(query, func(m string) string {
+		return cal.YearExpr(strings.TrimSpace(fiscalYearToken.FindStringSubmatch(m)[1]))
+	})
+	return strings.ReplaceAll(query, "FISCAL_HISTORY_FROM", "'"+cal.HistoryFloor(now, fsYear).Format("2006-01-02")+"'")
+}
+
+// resolveFsYear membaca parameter fs_year: angka tahun, "current" atau
+// "previous". String kosong menghasilkan 0 (tidak difilter).
+func (cal FiscalCalendar) resolveFsYear(raw string, now time.Time) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "":
+		return 0, nil
+	case "current":
+		return cal.Current(now).Year, nil
+	case "previous", "prev":
+		return cal.Previous(now).Year, nil
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || y < 1900 || y > 9999 {
+		return 0, fmt.Errorf("invalid fs_year, expected a year, current or previous")
+	}
+	return y, nil
+}
+
+// FiscalCalendarHandler menampilkan tahun fiskal berjalan, sebelumnya dan
+// batas default yang dipakai handler ANP.
+func FiscalCalendarHandler(c *fiber.Ctx) error {
+	cal := loadFiscalCalendar()
+	now := time.Now()
+	current, previous := cal.Current(now), cal.Previous(now)
+	return c.JSON(fiber.Map{
+		"success":         true,
+		"start_month":     int(cal.StartMonth),
+		"current":         current,
+		"previous":        previous,
+		"previous_closed": previous.Closed(now),
+		"active_from":     cal.ActiveFrom(now).Format("2006-01-02"),
+		"history_from":    cal.HistoryFrom(now).Format("2006-01-02"),
+	})
+}
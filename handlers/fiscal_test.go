@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFiscalCalendarJulyStart(t *testing.T) {
+	cal := FiscalCalendar{StartMonth: time.July, ClosingDays: 45, HistoryYears: 1, loc: time.UTC}
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+
+	fy := cal.YearOf(day(2025, 3, 10))
+	if fy.Year != 2024 || !fy.Start.Equal(day(2024, 7, 1)) || !fy.End.Equal(day(2025, 6, 30)) || !fy.Until().Equal(day(2025, 7, 1)) {
+		t.Errorf("YearOf = %+v", fy)
+	}
+	if !fy.Closing.Equal(day(2025, 8, 14)) {
+		t.Errorf("closing = %v", fy.Closing)
+	}
+
+	// Selama tahun fiskal sebelumnya belum closing, default daftar masih memuatnya.
+	if got := cal.ActiveFrom(day(2025, 8, 14)); !got.Equal(day(2024, 7, 1)) {
+		t.Errorf("ActiveFrom before closing = %v", got)
+	}
+	if got := cal.ActiveFrom(day(2025, 8, 15)); !got.Equal(day(2025, 7, 1)) {
+		t.Errorf("ActiveFrom after closing = %v", got)
+	}
+	if got := cal.HistoryFrom(day(2025, 8, 15)); !got.Equal(day(2024, 7, 1)) {
+		t.Errorf("HistoryFrom = %v", got)
+	}
+
+	q := cal.expandFiscalSQL("WHERE FISCAL_YEAR(tx.tglbyr) = FISCAL_YEAR(t1.StartDatePeriode) AND tx.tglbyr >= FISCAL_HISTORY_FROM", day(2025, 8, 15))
+	want := "WHERE YEAR(DATEADD(MONTH, -6, tx.tglbyr)) = YEAR(DATEADD(MONTH, -6, t1.StartDatePeriode)) AND tx.tglbyr >= '2024-07-01'"
+	if q != want {
+		t.Errorf("expandFiscalSQL = %s", q)
+	}
+}
+
+func TestResolveFsYear(t *testing.T) {
+	cal := FiscalCalendar{StartMonth: time.January, loc: time.UTC}
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	for raw, want := range map[string]int{"": 0, "current": 2026, "Previous": 2025, "2024": 2024} {
+		got, err := cal.resolveFsYear(raw, now)
+		if err != nil || got != want {
+			t.Errorf("resolveFsYear(%q) = %d, %v; want %d", raw, got, err, want)
+		}
+	}
+	if _, err := cal.resolveFsYear("20x4", now); err == nil || !strings.Contains(err.Error(), "fs_year") {
+		t.Errorf("expected fs_year error, got %v", err)
+	}
+}
+
+func TestHistoryFloorExplicitFsYear(t *testing.T) {
+	cal := FiscalCalendar{StartMonth: time.January, HistoryYears: 2, loc: time.UTC}
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	for fsYear, want := range map[int]string{0: "2024-01-01", 2025: "2024-01-01", 2021: "2021-01-01"} {
+		if got := cal.HistoryFloor(now, fsYear).Format("2006-01-02"); got != want {
+			t.Errorf("HistoryFloor(%d) = %s, want %s", fsYear, got, want)
+		}
+	}
+	// fs_year lama yang diminta eksplisit tidak terpotong jendela historis.
+	q := cal.expandFiscalSQLFor("tglbyr >= FISCAL_HISTORY_FROM", now, 2021)
+	if q != "tglbyr >= '2021-01-01'" {
+		t.Errorf("expandFiscalSQLFor = %s", q)
+	}
+}
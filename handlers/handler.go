@@ -29,6 +29,11 @@ const (
 	CacheBehaviorCreate = "create"
 )
 
+// QueryResultHook can be passed as an option to GenericQueryHandler and
+// GenericHtmlQueryHandler to fill or adjust rows after they are fetched and
+// before they are rendered and cached.
+type QueryResultHook func(src sqlAuditSource, rows []map[string]interface{}) error
+
 // GenericHtmlQueryHandler executes a SQL query and returns the results
 // formatted as an HTML table. It now implements a file-based caching mechanism
 // with dynamic expiration and behavior.
@@ -40,6 +45,7 @@ func GenericHtmlQueryHandler(c *fiber.Ctx, dbName string, query string, columnOr
 	var cacheDurationToUse time.Duration = defaultCacheDuration
 	var cacheBehaviorToUse string = CacheBehaviorAll
 	var directQueryParams []interface{} // Parameters passed directly to the handler call
+	var resultHooks []QueryResultHook
 
 	// Extract query parameters from Fiber context (GET, POST/JSON body, Form data)
 	// These are used for generating the cache file name to make it unique per request parameters.
@@ -67,6 +73,8 @@ func GenericHtmlQueryHandler(c *fiber.Ctx, dbName string, query string, columnOr
 			default:
 				directQueryParams = append(directQueryParams, opt)
 			}
+		case QueryResultHook:
+			resultHooks = append(resultHooks, v)
 		default:
 			directQueryParams = append(directQueryParams, opt)
 		}
@@ -104,6 +112,10 @@ func GenericHtmlQueryHandler(c *fiber.Ctx, dbName string, query string, columnOr
 	cacheFileName := fmt.Sprintf("%s.html", cacheBaseName)
 	cacheFilePath := filepath.Join("cache", cacheFileName)
 
+	if mode := explainMode(c); mode != "" {
+		return explainGenericQuery(c, mode, query, finalQueryParams, cacheBaseName, cacheFilePath, cacheDurationToUse, cacheBehaviorToUse)
+	}
+
 	if _, err := os.Stat("cache"); os.IsNotExist(err) {
 		err = os.MkdirAll("cache", 0755)
 		if err != nil {
@@ -139,11 +151,17 @@ func GenericHtmlQueryHandler(c *fiber.Ctx, dbName string, query string, columnOr
 	}
 
 	log.Printf("Fetching fresh data for HTML cache from DB for query: %s", query)
-	results, err := fetchDataFromDB(query, finalQueryParams...) 
+	results, err := fetchDataFromDB(auditSourceFromCtx(c), query, finalQueryParams...)
 	if err != nil {
 		log.Printf("Error fetching data from database '%s': %v", selectedDBName, err)
 		return c.Status(500).SendString(fmt.Sprintf("Database query error: %v", err))
 	}
+	for _, hook := range resultHooks {
+		if err := hook(auditSourceFromCtx(c), results); err != nil {
+			log.Printf("Error post-processing query results: %v", err)
+			return c.Status(500).SendString(fmt.Sprintf("Database query error: %v", err))
+		}
+	}
 
 	var effectiveColumnOrder []string
 	if columnOrder == nil || len(columnOrder) == 0 {
@@ -246,6 +264,7 @@ func GenericQueryHandler(c *fiber.Ctx, query string, opts ...interface{}) error
 	var cacheDurationToUse time.Duration = defaultCacheDuration
 	var cacheBehaviorToUse string = CacheBehaviorAll
 	var directQueryParams []interface{} 
+	var resultHooks []QueryResultHook
 	var cacheDurationProvided bool = false 
 
 	httpContextParams := extractQueryParamsFromContext(c)
@@ -269,6 +288,11 @@ func GenericQueryHandler(c *fiber.Ctx, query string, opts ...interface{}) error
 			default:
 				directQueryParams = append(directQueryParams, opt)
 			}
+		case boundArgs:
+			// Nilai filter dari user: selalu parameter query, tidak pernah opsi cache.
+			directQueryParams = append(directQueryParams, v...)
+		case QueryResultHook:
+			resultHooks = append(resultHooks, v)
 		default:
 			directQueryParams = append(directQueryParams, opt)
 		}
@@ -307,6 +331,10 @@ func GenericQueryHandler(c *fiber.Ctx, query string, opts ...interface{}) error
         cacheDurationToUse = defaultCacheDuration
     }
 
+	if mode := explainMode(c); mode != "" {
+		return explainGenericQuery(c, mode, query, finalQueryParams, cacheBaseName, cacheFilePath, cacheDurationToUse, cacheBehaviorToUse)
+	}
+
 	if cacheDurationToUse > 0 && (cacheBehaviorToUse == CacheBehaviorAll || cacheBehaviorToUse == CacheBehaviorRead) {
 		isFresh, err := isCacheFresh(cacheFilePath, cacheDurationToUse)
 		if err != nil {
@@ -336,11 +364,17 @@ func GenericQueryHandler(c *fiber.Ctx, query string, opts ...interface{}) error
 
 
 	log.Printf("Fetching fresh data for JSON cache from DB for query: %s", query)
-	results, err := fetchDataFromDB(query, finalQueryParams...) 
+	results, err := fetchDataFromDB(auditSourceFromCtx(c), query, finalQueryParams...)
 	if err != nil {
 		log.Printf("Error fetching data from database: %v", err)
 		return c.Status(500).SendString(fmt.Sprintf("Database query error: %v", err))
 	}
+	for _, hook := range resultHooks {
+		if err := hook(auditSourceFromCtx(c), results); err != nil {
+			log.Printf("Error post-processing query results: %v", err)
+			return c.Status(500).SendString(fmt.Sprintf("Database query error: %v", err))
+		}
+	}
 
 	jsonData, err := json.Marshal(results)
 	if err != nil {
@@ -367,19 +401,17 @@ func GenericQueryHandler(c *fiber.Ctx, query string, opts ...interface{}) error
 	return nil
 }
 
-func fetchDataFromDB(query string, params ...interface{}) ([]map[string]interface{}, error) {
+// fetchDataFromDB runs query and returns every row as a column->value map.
+// The execution is recorded in the SQL audit trail under src.
+func fetchDataFromDB(src sqlAuditSource, query string, params ...interface{}) (results []map[string]interface{}, err error) {
 	var rows *sql.Rows
-	var err error
 
-	var actualDBParams []interface{}
-	for _, p := range params {
-		switch p.(type) {
-		case string, int, int64, float64, bool, time.Time, nil:
-			actualDBParams = append(actualDBParams, p)
-		default:
-			log.Printf("Warning: Skipping unsupported parameter type for DB query: %T %v", p, p)
-		}
-	}
+	actualDBParams := supportedDBParams(params)
+
+	started := time.Now()
+	defer func() {
+		recordSQLAudit(src, "query", query, actualDBParams, started, int64(len(results)), err)
+	}()
 
 	rows, err = db.DB.Query(query, actualDBParams...)
 
@@ -388,7 +420,7 @@ func fetchDataFromDB(query string, params ...interface{}) ([]map[string]interfac
 	}
 	defer rows.Close()
 
-	results := []map[string]interface{}{}
+	results = []map[string]interface{}{}
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get columns: %w", err)
@@ -447,6 +479,20 @@ func fetchDataFromDB(query string, params ...interface{}) ([]map[string]interfac
 	return results, nil
 }
 
+// supportedDBParams drops parameters whose type the generic layer does not bind.
+func supportedDBParams(params []interface{}) []interface{} {
+	var actualDBParams []interface{}
+	for _, p := range params {
+		switch p.(type) {
+		case string, int, int64, float64, bool, time.Time, nil:
+			actualDBParams = append(actualDBParams, p)
+		default:
+			log.Printf("Warning: Skipping unsupported parameter type for DB query: %T %v", p, p)
+		}
+	}
+	return actualDBParams
+}
+
 func generateCacheFileName(query string, params ...interface{}) (string, error) {
 
 	dataToHash := query
@@ -523,6 +569,9 @@ func generateHashSuffix(input string) string {
 func extractQueryParamsFromContext(c *fiber.Ctx) []string {
 	var combinedParams []string
 	c.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+		if string(key) == explainParam {
+			return // explain mode must resolve to the same cache entry as a normal request
+		}
 		combinedParams = append(combinedParams, fmt.Sprintf("%s=%s", key, value))
 	})
 
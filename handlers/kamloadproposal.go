@@ -7,256 +7,91 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-type KamProposalApprovedParams struct {
-	Number      string   `query:"number"`
-	UserCodeKam string   `query:"user_code_kam"`
-	Brand       []string `query:"brand"`
-	Group       []string `query:"group"`
-	Activity    []string `query:"activity"`
-	StartDate   string   `query:"start_date"`
-	EndDate     string   `query:"end_date"`
-	SKP         *int     `query:"skp"`
-	Status      string   `query:"status"`
-}
-
-func Kamloadproposal(c *fiber.Ctx) error {
-	params := new(KamProposalApprovedParams)
-	if err := c.QueryParser(params); err != nil {
-		return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Error parsing query parameters: %v", err))
-	}
-
-	number := params.Number
-	userCodeKam := params.UserCodeKam
-
-	iskamall := 0
-	if userCodeKam == "KA019" || userCodeKam == "KA006" {
-		iskamall = 1
-	}
-
-	var sqlBuilder strings.Builder
-	var conditions []string
-	var paramsSQL []interface{}
-	paramIndex := 1
-
-	userInPicGroupAnp := true
-
-	if userCodeKam == "KA029" {
-		sqlBuilder.WriteString(fmt.Sprintf(`
-			SELECT
-			
+// kamProposalView adalah daftar proposal untuk KAM/RSM (user_code_kam). Scope
+// per user ada di kamProposalScope.
+var kamProposalView = proposalView{
+	name: "kam",
+	selectSQL: `
+		SELECT
 			(
 				select top 1 t4.GroupName from tb_proposal_customer t3
 				left join m_group t4 on t3.GroupCustomer = t4.GroupCode
-				where t0.[Number] = t3.ProposalNumber
+				where t1.[Number] = t3.ProposalNumber
 			)as [Group],
-				t0.id,
-				t0.Number,
-				(SELECT TOP 1 t1.BrandName FROM m_brand t1 WHERE t1.BrandCode = t0.brandcode) AS BrandName,
-				t0.StartDatePeriode,
-				t0.EndDatePeriode,
-				(SELECT TOP 1 t1.promo_name FROM m_promo t1 WHERE t1.id = t0.Activity) AS ActivityName,
-				t0.Status,
-				t0.CreatedBy,
-				t0.CreatedDate,
-				(SELECT TOP 1 totalcosting FROM tb_operating_proposal t1 WHERE t1.ProposalNumber = t0.Number) AS TotalCosting,
-				(SELECT COUNT(t1.id) FROM tb_proposal_group t1 WHERE t1.ProposalNumber = t0.Number) AS target_skp,
-				(SELECT COUNT(t1.id) FROM tb_proposal_skp t1 WHERE t1.ProposalNumber = t0.Number AND t1.NoSKP != '') AS jml_skp
-			FROM tb_proposal t0
-			WHERE EXISTS (
-				SELECT 1 FROM tb_operating_proposal t4
-				INNER JOIN tb_proposal_customer t5 ON t5.ProposalNumber = t0.Number
-				INNER JOIN [pk-query].[db_santosh].dbo.b_cust t6 ON t6.CardCode = t5.CustomerCode
-				INNER JOIN master_user t7 ON t7.fullname = t6.rsm
-				WHERE t7.user_code = @p%d AND t4.keperluan = 'internal'
-			) AND t0.[Status] != 'canceled'
-		`, paramIndex))
-		paramsSQL = append(paramsSQL, userCodeKam)
-		paramIndex++
+			t1.id,
+			t1.Number,
+			(SELECT TOP 1 mb.BrandName FROM m_brand mb WHERE mb.BrandCode = t1.BrandCode) AS BrandName,
+			t1.StartDatePeriode,
+			t1.EndDatePeriode,
+			(SELECT TOP 1 mp.promo_name FROM m_promo mp WHERE mp.id = t1.Activity) AS ActivityName,
+			t1.Status,
+			t1.CreatedBy,
+			t1.CreatedDate,
+			(SELECT TOP 1 totalcosting FROM tb_operating_proposal op WHERE op.ProposalNumber = t1.Number) AS TotalCosting,
+			(SELECT COUNT(pg.id) FROM tb_proposal_group pg WHERE pg.ProposalNumber = t1.Number) AS target_skp,
+			(SELECT COUNT(ps.id) FROM tb_proposal_skp ps WHERE ps.ProposalNumber = t1.Number AND ps.NoSKP != '') AS jml_skp
+		FROM tb_proposal t1`,
+	orderBy:        "t1.CreatedDate DESC",
+	userParam:      "user_code_kam",
+	activityCol:    "t1.Activity",
+	statusCol:      "t1.Status",
+	baseConditions: []string{"t1.[Status] != 'canceled'"},
+	defaultPeriod:  true,
+	periodAnyDate:  true,
+	scope:          kamProposalScope,
+}
 
-		if number != "" {
-			conditions = append(conditions, fmt.Sprintf("t0.Number = @p%d", paramIndex))
-			paramsSQL = append(paramsSQL, number)
-			paramIndex++
+// User KAM dengan aturan scope khusus.
+var (
+	kamSeeAllUsers   = map[string]bool{"KA019": true, "KA006": true}
+	kamRsmUsers      = map[string]string{"KA029": "", "KA032": "Suyanto"} // user -> RSM tambahan
+	kamRegisterUsers = map[string]bool{"FN005": true}
+)
+
+// kamProposalScope membatasi proposal internal sesuai KAM pelanggan. User RSM
+// dicocokkan lewat b_cust.rsm; user lain lewat b_cust.KAM kecuali yang boleh
+// melihat semua.
+func kamProposalScope(f ProposalFilter, args *boundArgs) []string {
+	if extraRsm, ok := kamRsmUsers[f.UserCode]; ok {
+		match := "t7.user_code = " + args.bind(f.UserCode)
+		if extraRsm != "" {
+			match = fmt.Sprintf("(%s OR t6.rsm = %s)", match, args.bind(extraRsm))
 		}
-	} else if userCodeKam == "KA032" {
-		sqlBuilder.WriteString(fmt.Sprintf(`
-			SELECT
-			
-			(
-				select top 1 t4.GroupName from tb_proposal_customer t3
-				left join m_group t4 on t3.GroupCustomer = t4.GroupCode
-				where t0.[Number] = t3.ProposalNumber
-			)as [Group],
-				t0.id,
-				t0.Number,
-				(SELECT TOP 1 t1.BrandName FROM m_brand t1 WHERE t1.BrandCode = t0.brandcode) AS BrandName,
-				t0.StartDatePeriode,
-				t0.EndDatePeriode,
-				(SELECT TOP 1 t1.promo_name FROM m_promo t1 WHERE t1.id = t0.Activity) AS ActivityName,
-				t0.Status,
-				t0.CreatedBy,
-				t0.CreatedDate,
-				(SELECT TOP 1 totalcosting FROM tb_operating_proposal t1 WHERE t1.ProposalNumber = t0.Number) AS TotalCosting,
-				(SELECT COUNT(t1.id) FROM tb_proposal_group t1 WHERE t1.ProposalNumber = t0.Number) AS target_skp,
-				(SELECT COUNT(t1.id) FROM tb_proposal_skp t1 WHERE t1.ProposalNumber = t0.Number AND t1.NoSKP != '') AS jml_skp
-			FROM tb_proposal t0
-			WHERE EXISTS (
+		return []string{fmt.Sprintf(`EXISTS (
 				SELECT 1 FROM tb_operating_proposal t4
-				INNER JOIN tb_proposal_customer t5 ON t5.ProposalNumber = t0.Number
+				INNER JOIN tb_proposal_customer t5 ON t5.ProposalNumber = t1.Number
 				INNER JOIN [pk-query].[db_santosh].dbo.b_cust t6 ON t6.CardCode = t5.CustomerCode
 				INNER JOIN master_user t7 ON t7.fullname = t6.rsm
-				WHERE (t7.user_code = @p%d OR t6.rsm = 'Suyanto') AND t4.keperluan = 'internal'
-			) AND t0.[Status] != 'canceled'
-		`, paramIndex))
-		paramsSQL = append(paramsSQL, userCodeKam)
-		paramIndex++
-
-		if number != "" {
-			conditions = append(conditions, fmt.Sprintf("t0.Number = @p%d", paramIndex))
-			paramsSQL = append(paramsSQL, number)
-			paramIndex++
-		}
-	} else {
-		sqlBuilder.WriteString(`
-			SELECT
-			
-			(
-				select top 1 t4.GroupName from tb_proposal_customer t3
-				left join m_group t4 on t3.GroupCustomer = t4.GroupCode
-				where t0.[Number] = t3.ProposalNumber
-			)as [Group],
-				t0.id,
-				t0.Number,
-				(SELECT TOP 1 t1.BrandName FROM m_brand t1 WHERE t1.BrandCode = t0.BrandCode) AS BrandName,
-				t0.StartDatePeriode,
-				t0.EndDatePeriode,
-				(SELECT TOP 1 t1.promo_name FROM m_promo t1 WHERE t1.id = t0.Activity) AS ActivityName,
-				t0.Status,
-				t0.CreatedBy,
-				t0.CreatedDate,
-				(SELECT TOP 1 totalcosting FROM tb_operating_proposal t1 WHERE t1.ProposalNumber = t0.Number) AS TotalCosting,
-				(SELECT COUNT(t1.id) FROM tb_proposal_group t1 WHERE t1.ProposalNumber = t0.Number) AS target_skp,
-				(SELECT COUNT(t1.id) FROM tb_proposal_skp t1 WHERE t1.ProposalNumber = t0.Number AND t1.NoSKP != '') AS jml_skp
-			FROM tb_proposal t0
-			WHERE t0.[Status] != 'canceled'
-		`)
-
-		var existsConditions []string
-
-		if userInPicGroupAnp {
-			existsConditions = append(existsConditions, `t4.ProposalNumber = t0.Number AND t4.keperluan = 'internal'`)
-			if iskamall == 0 {
-				existsConditions = append(existsConditions, fmt.Sprintf("t7.user_code = @p%d", paramIndex))
-				paramsSQL = append(paramsSQL, userCodeKam)
-				paramIndex++
-			}
-		} else {
-			existsConditions = append(existsConditions, `t4.ProposalNumber = t0.Number`)
-			if iskamall == 0 {
-				existsConditions = append(existsConditions, fmt.Sprintf("t7.user_code = @p%d AND (t6.SALES_HEAD = 'emma' OR t6.rsm = 'Aris')", paramIndex))
-				paramsSQL = append(paramsSQL, userCodeKam)
-				paramIndex++
-			}
-		}
+				WHERE %s AND t4.keperluan = 'internal'
+			)`, match)}
+	}
 
-		existsClause := fmt.Sprintf(`
-			EXISTS (
+	exists := []string{"t4.ProposalNumber = t1.Number AND t4.keperluan = 'internal'"}
+	if !kamSeeAllUsers[f.UserCode] {
+		exists = append(exists, "t7.user_code = "+args.bind(f.UserCode))
+	}
+	conditions := []string{fmt.Sprintf(`EXISTS (
 				SELECT 1 FROM tb_operating_proposal t4
-				LEFT JOIN tb_proposal_customer t5 ON t5.ProposalNumber = t0.Number
+				LEFT JOIN tb_proposal_customer t5 ON t5.ProposalNumber = t1.Number
 				LEFT JOIN [pk-query].db_santosh.dbo.b_cust t6 ON t6.CardCode = t5.CustomerCode
 				LEFT JOIN master_user t7 ON t7.fullname = t6.KAM
 				WHERE %s
-			)`, strings.Join(existsConditions, " AND "))
-		conditions = append(conditions, existsClause)
+			)`, strings.Join(exists, " AND "))}
 
-		if userCodeKam == "FN005" {
-			conditions = append(conditions, `(t0.Activity = 49 OR EXISTS (
+	if kamRegisterUsers[f.UserCode] {
+		conditions = append(conditions, `(t1.Activity = 49 OR EXISTS (
 				SELECT 1 FROM tb_proposal_mechanism tm
 				WHERE ((tm.Mechanism LIKE '%pom%')
-				OR (tm.Mechanism LIKE '%regi%') OR (tm.Mechanism LIKE '%hala%') ) AND t0.Activity != 25 AND tm.ProposalNumber = t0.Number
+				OR (tm.Mechanism LIKE '%regi%') OR (tm.Mechanism LIKE '%hala%') ) AND t1.Activity != 25 AND tm.ProposalNumber = t1.Number
 			))`)
-		}
-
-		conditions = append(conditions, "t0.Activity != 29 AND t0.Activity != 30 AND t0.Activity != 39 AND t0.Activity != 31")
-		conditions = append(conditions, "t0.StartDatePeriode >= '2023-12-31'")
-
-		if number != "" {
-			conditions = append(conditions, fmt.Sprintf("t0.Number = @p%d", paramIndex))
-			paramsSQL = append(paramsSQL, number)
-			paramIndex++
-		}
-	}
-
-	if params.SKP != nil {
-		switch *params.SKP {
-		case 0:
-			conditions = append(conditions, `t0.Number NOT IN (SELECT ProposalNumber FROM tb_proposal_skp)`)
-		case 1:
-			conditions = append(conditions, `EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t0.Number AND (t_skp.status_skp = '' OR t_skp.status_skp IS NULL))`)
-		case 2:
-			conditions = append(conditions, `EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t0.Number AND t_skp.status_skp = 'approve')`)
-		case 3:
-			conditions = append(conditions, `EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t0.Number AND t_skp.status_skp = 'canceled')`)
-		}
-	}
-
-	if len(params.Brand) > 0 {
-		conditions = append(conditions, fmt.Sprintf("t0.BrandCode IN (SELECT value FROM STRING_SPLIT(@p%d, ','))", paramIndex))
-		paramsSQL = append(paramsSQL, strings.Join(params.Brand, ","))
-		paramIndex++
-	}
-
-	if len(params.Group) > 0 {
-		conditions = append(conditions, fmt.Sprintf(`EXISTS (
-			SELECT 1 FROM tb_proposal_customer t5
-			INNER JOIN m_group t6 ON t6.GroupCode = t5.GroupCustomer
-			WHERE t5.ProposalNumber = t0.Number
-			AND t6.GroupCode IN (SELECT value FROM STRING_SPLIT(@p%d, ','))
-		)`, paramIndex))
-		paramsSQL = append(paramsSQL, strings.Join(params.Group, ","))
-		paramIndex++
-	}
-
-	if len(params.Activity) > 0 {
-		conditions = append(conditions, fmt.Sprintf("t0.Activity IN (SELECT value FROM STRING_SPLIT(@p%d, ','))", paramIndex))
-		paramsSQL = append(paramsSQL, strings.Join(params.Activity, ","))
-		paramIndex++
 	}
+	conditions = append(conditions,
+		"t1.Activity != 29 AND t1.Activity != 30 AND t1.Activity != 39 AND t1.Activity != 31",
+		"t1.StartDatePeriode >= FISCAL_HISTORY_FROM")
+	return conditions
+}
 
-	if params.StartDate != "" {
-		conditions = append(conditions, fmt.Sprintf("FORMAT(t0.StartDatePeriode, 'yyyyMMdd') >= FORMAT(CONVERT(DATE,@p%d), 'yyyyMMdd')", paramIndex))
-		paramsSQL = append(paramsSQL, params.StartDate)
-		paramIndex++
-	} else {
-		conditions = append(conditions, "(FORMAT(t0.StartDatePeriode, 'yyyyMMdd') >= '20250101' or FORMAT(t0.EndDatePeriode, 'yyyyMMdd') >= '20250101' or FORMAT(t0.CreatedDate, 'yyyyMMdd') >= '20250101')")
-	}
-
-	if params.EndDate != "" {
-		conditions = append(conditions, fmt.Sprintf("FORMAT(t0.EndDatePeriode, 'yyyyMMdd') <= FORMAT(CONVERT(DATE,@p%d), 'yyyyMMdd')", paramIndex))
-		paramsSQL = append(paramsSQL, params.EndDate)
-		paramIndex++
-	}
-
-	if params.Status != "" {
-		conditions = append(conditions, fmt.Sprintf("t0.Status = @p%d", paramIndex))
-		paramsSQL = append(paramsSQL, params.Status)
-		paramIndex++
-	}
-
-	if len(conditions) > 0 {
-		if !strings.Contains(sqlBuilder.String(), "WHERE") {
-			sqlBuilder.WriteString(" WHERE ")
-		} else {
-			sqlBuilder.WriteString(" AND ")
-		}
-		sqlBuilder.WriteString(strings.Join(conditions, " AND "))
-	}
-
-	sqlBuilder.WriteString(" ORDER BY t0.CreatedDate DESC")
-	finalSQL := sqlBuilder.String()
-
-	fmt.Println("Generated SQL:", finalSQL)
-	fmt.Println("SQL Parameters:", paramsSQL)
-
-	return GenericQueryHandler(c, finalSQL, paramsSQL...)
-}
\ No newline at end of file
+// Kamloadproposal menampilkan daftar proposal KAM dengan filter ProposalFilter.
+func Kamloadproposal(c *fiber.Ctx) error {
+	return proposalViewHandler(c, kamProposalView)
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// ManifestHeader adalah header manifes dari @idu_h_manifest.
+type ManifestHeader struct {
+	Manifes  int            `json:"manifes"`
+	ShipDate sql.NullTime   `json:"ship_date"`
+	Driver   sql.NullString `json:"driver"`
+	NoPol    sql.NullString `json:"no_pol"`
+	KodeRute sql.NullString `json:"kode_rute"`
+	TotalSJ  int            `json:"total_sj"`
+	Reported int            `json:"reported"` // jumlah SJ yang sudah punya status di me_manifest
+}
+
+// ManifestCustomer adalah alamat kirim dan koordinat dari master_customer.
+type ManifestCustomer struct {
+	ID       sql.NullInt64   `json:"id"`
+	Alamat   sql.NullString  `json:"alamat"`
+	Address  sql.NullString  `json:"address"`
+	ZipCode  sql.NullString  `json:"zipcode"`
+	Lat      sql.NullFloat64 `json:"lat"`
+	Lon      sql.NullFloat64 `json:"lon"`
+	JarakKm  sql.NullFloat64 `json:"jarak"`
+	HasCoord bool            `json:"has_coordinates"`
+}
+
+// ManifestLine adalah satu baris item SJ dari DLN1.
+type ManifestLine struct {
+	LineNum     int             `json:"line_num"`
+	ItemCode    string          `json:"item_code"`
+	Description sql.NullString  `json:"description"`
+	Quantity    sql.NullFloat64 `json:"quantity"`
+	UoM         sql.NullString  `json:"uom"`
+}
+
+// ManifestEvent adalah satu baris me_manifest untuk SJ, diurutkan sebagai timeline.
+type ManifestEvent struct {
+	StatusID     sql.NullInt64  `json:"status_id"`
+	Status       sql.NullString `json:"status"`
+	ReasonID     sql.NullInt64  `json:"reason_id"`
+	Reason       sql.NullString `json:"reason"`
+	Penerima     sql.NullString `json:"penerima"`
+	FotoBukti    sql.NullString `json:"foto_bukti"`
+	ImgSignature sql.NullString `json:"img_signature"`
+	Created      sql.NullTime   `json:"created"`
+
+	FotoBuktiURL    string `json:"foto_bukti_url,omitempty"`
+	ImgSignatureURL string `json:"img_signature_url,omitempty"`
+}
+
+// ManifestDelivery adalah satu SJ (delivery note) dalam manifes.
+type ManifestDelivery struct {
+	SJ         int              `json:"sj"`
+	DocDate    sql.NullTime     `json:"doc_date"`
+	Dept       sql.NullString   `json:"dept"`
+	CardCode   string           `json:"cardcode"`
+	Cardname   sql.NullString   `json:"cardname"`
+	POCustomer sql.NullString   `json:"po_customer"`
+	ShipTo     sql.NullString   `json:"ship_to"`
+	Customer   ManifestCustomer `json:"customer"`
+	Lines      []ManifestLine   `json:"lines"`
+	Timeline   []ManifestEvent  `json:"timeline"`
+	Current    *ManifestEvent   `json:"current_status"`
+}
+
+// ManifestDetail adalah respons /pkexpress/manifes/:no.
+type ManifestDetail struct {
+	Header     ManifestHeader     `json:"header"`
+	Deliveries []ManifestDelivery `json:"deliveries"`
+}
+
+// ManifesDetailHandler mengembalikan header manifes, setiap SJ beserta baris
+// DLN1, alamat/koordinat customer, dan timeline status me_manifest.
+func ManifesDetailHandler(c *fiber.Ctx) error {
+	no, err := strconv.Atoi(c.Params("no"))
+	if err != nil || no <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{
+			Success: false,
+			Message: "Invalid manifest number.",
+		})
+	}
+
+	detail, err := loadManifestDetail(auditSourceFromCtx(c), db.GetDB(), no)
+	if err != nil {
+		log.Printf("Error loading manifest %d: %v", no, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: "Failed to retrieve manifest.",
+		})
+	}
+	if detail == nil {
+		return c.Status(fiber.StatusNotFound).JSON(Response{
+			Success: false,
+			Message: fmt.Sprintf("Manifest %d not found.", no),
+		})
+	}
+	return c.JSON(detail)
+}
+
+// loadManifestDetail memuat detail manifes; nil tanpa error jika tidak ditemukan.
+func loadManifestDetail(src sqlAuditSource, database *sql.DB, no int) (*ManifestDetail, error) {
+	args := []interface{}{no}
+	detail := &ManifestDetail{Deliveries: []ManifestDelivery{}}
+	found := false
+
+	headerQuery := `
+		SELECT TOP 1 t2.DocNum, t2.U_IDU_TANGGAL, t2.U_IDU_NAMASUPIR, t2.U_IDU_NoPol, t2.U_IDU_Kode_Rute
+		FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK)
+		WHERE t2.DocNum = @p1`
+	err := auditedQuery(src, database, headerQuery, args, func(rows *sql.Rows) error {
+		found = true
+		h := &detail.Header
+		return rows.Scan(&h.Manifes, &h.ShipDate, &h.Driver, &h.NoPol, &h.KodeRute)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying manifest header: %w", err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	// Alamat kirim diambil dari master_customer; baris dengan address yang sama
+	// dengan ShipToCode diutamakan, jika tidak ada pakai baris pertama customer.
+	deliveryQuery := `
+		SELECT DISTINCT dd.DocNum, dd.DocDate, BP.U_IDU_DEPARTMENT, dd.CardCode, dd.CardName, dd.NumAtCard, dd.ShipToCode,
+			mc.id, mc.alamat, mc.address, mc.zipcode, mc.lat, mc.lon, mc.jarak
+		FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK)
+		INNER JOIN [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_d_manifest] t0 WITH (NOLOCK) ON t0.DocEntry = t2.DocEntry
+		INNER JOIN [pksrv-sap].[pandurasa_live].dbo.ODLN dd WITH (NOLOCK) ON dd.DocNum = t0.U_IDU_NomorDO AND dd.CANCELED = 'N'
+		INNER JOIN [pksrv-sap].[pandurasa_live].dbo.OCRD BP WITH (NOLOCK) ON BP.CardCode = dd.CardCode
+		OUTER APPLY (
+			SELECT TOP 1 c.id, c.alamat, c.address, c.zipcode, c.lat, c.lon, c.jarak
+			FROM [pksrv-sap].pk_express.dbo.master_customer c
+			WHERE c.cardcode = dd.CardCode
+			ORDER BY CASE WHEN c.address = dd.ShipToCode THEN 0 ELSE 1 END, c.id
+		) mc
+		WHERE t2.DocNum = @p1
+		ORDER BY dd.DocNum`
+	index := map[int]int{} // SJ -> posisi di detail.Deliveries
+	err = auditedQuery(src, database, deliveryQuery, args, func(rows *sql.Rows) error {
+		var d ManifestDelivery
+		cust := &d.Customer
+		if err := rows.Scan(&d.SJ, &d.DocDate, &d.Dept, &d.CardCode, &d.Cardname, &d.POCustomer, &d.ShipTo,
+			&cust.ID, &cust.Alamat, &cust.Address, &cust.ZipCode, &cust.Lat, &cust.Lon, &cust.JarakKm); err != nil {
+			return err
+		}
+		cust.HasCoord = cust.Lat.Valid && cust.Lon.Valid
+		d.Lines = []ManifestLine{}
+		d.Timeline = []ManifestEvent{}
+		index[d.SJ] = len(detail.Deliveries)
+		detail.Deliveries = append(detail.Deliveries, d)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying manifest deliveries: %w", err)
+	}
+
+	linesQuery := `
+		SELECT dd.DocNum, D.LineNum, D.ItemCode, D.Dscription, D.Quantity, D.unitMsr
+		FROM [pksrv-sap].[pandurasa_live].dbo.DLN1 D WITH (NOLOCK)
+		INNER JOIN [pksrv-sap].[pandurasa_live].dbo.ODLN dd WITH (NOLOCK) ON dd.DocEntry = D.DocEntry AND dd.CANCELED = 'N'
+		WHERE dd.DocNum IN (
+			SELECT t0.U_IDU_NomorDO
+			FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_d_manifest] t0 WITH (NOLOCK)
+			INNER JOIN [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK) ON t0.DocEntry = t2.DocEntry
+			WHERE t2.DocNum = @p1
+		)
+		ORDER BY dd.DocNum, D.LineNum`
+	err = auditedQuery(src, database, linesQuery, args, func(rows *sql.Rows) error {
+		var sj int
+		var l ManifestLine
+		if err := rows.Scan(&sj, &l.LineNum, &l.ItemCode, &l.Description, &l.Quantity, &l.UoM); err != nil {
+			return err
+		}
+		if i, ok := index[sj]; ok {
+			detail.Deliveries[i].Lines = append(detail.Deliveries[i].Lines, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying manifest lines: %w", err)
+	}
+
+	timelineQuery := `
+		SELECT mm.sj, mm.[status], ms.[status], mm.reason, mr.reason, mm.penerima, mm.foto_bukti, mm.img_signature, mm.created
+		FROM [pksrv-sap].[pk_express].[dbo].me_manifest mm
+		LEFT JOIN [pksrv-sap].[pk_express].[dbo].master_status ms ON mm.[status] = ms.id
+		LEFT JOIN [pksrv-sap].[pk_express].[dbo].master_reason mr ON mr.id = mm.reason
+		WHERE mm.no_manifes = @p1
+		ORDER BY mm.sj, mm.created`
+	err = auditedQuery(src, database, timelineQuery, args, func(rows *sql.Rows) error {
+		var sj int
+		var e ManifestEvent
+		if err := rows.Scan(&sj, &e.StatusID, &e.Status, &e.ReasonID, &e.Reason, &e.Penerima, &e.FotoBukti, &e.ImgSignature, &e.Created); err != nil {
+			return err
+		}
+		e.FotoBuktiURL = podImageURL(e.FotoBukti.String, 0)
+		e.ImgSignatureURL = podImageURL(e.ImgSignature.String, 0)
+		if i, ok := index[sj]; ok {
+			detail.Deliveries[i].Timeline = append(detail.Deliveries[i].Timeline, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying manifest timeline: %w", err)
+	}
+
+	detail.Header.TotalSJ = len(detail.Deliveries)
+	for i := range detail.Deliveries {
+		d := &detail.Deliveries[i]
+		if n := len(d.Timeline); n > 0 {
+			d.Current = &d.Timeline[n-1]
+			if d.Current.StatusID.Valid {
+				detail.Header.Reported++
+			}
+		}
+	}
+	return detail, nil
+}
@@ -9,23 +9,21 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"my-fiber-app/db" // Asumsi ini adalah package Anda untuk koneksi DB
 )
 
+// cacheDir adalah direktori cache daftar manifes (variabel agar test dapat
+// memakai direktori sementara).
+var cacheDir = "./cache_manifes"
+
 const (
-	cacheDir        = "./cache_manifes"
 	cacheTTL        = 30 * time.Minute
 	cleanupInterval = 1 * time.Minute
 )
 
-var (
-	cacheMutex sync.Mutex
-)
-
 // init dijalankan sekali saat package diimpor
 func init() {
 	if err := os.MkdirAll(cacheDir, 0755); err != nil {
@@ -61,97 +59,208 @@ type ManifesRecord struct {
 	FotoBukti    sql.NullString `json:"foto_bukti"`
 	ImgSignature sql.NullString `json:"img_signature"`
 	Created      sql.NullTime   `json:"created"`
+
+	// URL bertanda tangan untuk melihat gambar (lihat PODImageHandler).
+	FotoBuktiURL    string `json:"foto_bukti_url,omitempty"`
+	FotoBuktiThumb  string `json:"foto_bukti_thumb,omitempty"`
+	ImgSignatureURL string `json:"img_signature_url,omitempty"`
 }
 
-// AjaxManifesHandler sekarang akan mengelola caching dan hanya mengembalikan array data
-func AjaxManifesHandler(c *fiber.Ctx) error {
-	// --- PERBAIKAN 1: Deklarasikan variabel di awal fungsi ---
-	// Pindahkan deklarasi dan inisialisasi draw, start, length ke sini
-	draw, _ := strconv.Atoi(c.Query("draw"))
-	start, _ := strconv.Atoi(c.Query("start"))
-	length, _ := strconv.Atoi(c.Query("length"))
+// podListThumbWidth adalah lebar thumbnail gambar untuk tampilan daftar.
+const podListThumbWidth = 160
+
+// manifestOrder adalah satu kolom pengurutan DataTables (order[i]).
+type manifestOrder struct {
+	Column string
+	Dir    string
+}
+
+// manifestListRequest menampung parameter DataTables server-side untuk daftar manifes.
+type manifestListRequest struct {
+	Draw         int
+	Start        int
+	Length       int
+	Search       string
+	SearchType   string         // salah satu manifestSearchTypes
+	ColumnSearch map[int]string // indeks kolom (columnMap) -> columns[i][search][value]
+	Orders       []manifestOrder
+	Dept         string // filter departemen (BP.U_IDU_DEPARTMENT), kosong = semua
+	DateRange    string
+	DateField    string
+	Dates        *manifestDateRange // hasil parsing DateRange, nil jika tidak diisi
+}
+
+// maxManifestPageLength membatasi length per halaman (juga untuk length=-1 "semua").
+const maxManifestPageLength = 1000
+
+// Mode pencarian yang didukung lewat ?searchType=.
+const (
+	manifestSearchAll      = "all"
+	manifestSearchManifest = "manifest"
+	manifestSearchSJ       = "sj"
+	manifestSearchPlate    = "plate"
+	manifestSearchDriver   = "driver"
+	manifestSearchCustomer = "customer"
+)
+
+var manifestSearchTypes = map[string]bool{
+	manifestSearchAll:      true,
+	manifestSearchManifest: true,
+	manifestSearchSJ:       true,
+	manifestSearchPlate:    true,
+	manifestSearchDriver:   true,
+	manifestSearchCustomer: true,
+}
 
-	searchValue := c.Query("search[value]")
-	if searchValue == "" {
-		searchValue = c.Query("search") // Fallback jika hanya 'search' yang ada
+// manifestColumnExpr adalah whitelist kolom yang boleh dicari per kolom,
+// dipetakan dari alias columnMap ke ekspresi sumbernya.
+var manifestColumnExpr = map[string]string{
+	"dept":         "BP.U_IDU_DEPARTMENT",
+	"manifes":      "T6.MANIFEST#",
+	"sj":           "dd.Docnum",
+	"ship_date":    "CONVERT(VARCHAR, T6.SHIP_DATE, 23)",
+	"no_pol":       "T6.U_IDU_NoPol",
+	"po_customer":  "dd.numatcard",
+	"cardname":     "dd.cardname",
+	"ship_to":      "dd.ShipToCode",
+	"driver":       "T6.DRIVER",
+	"gr_status_me": "ms.[status]",
+	"reason":       "mr.reason",
+	"created":      "CONVERT(VARCHAR, mm.created, 120)",
+}
+
+// manifestNumericColumns dicari dengan kesamaan (=) bila nilainya angka.
+var manifestNumericColumns = map[string]bool{"manifes": true, "sj": true}
+
+// boundArgs mengumpulkan parameter query dan mengembalikan placeholder @pN.
+type boundArgs []interface{}
+
+func (a *boundArgs) bind(v interface{}) string {
+	*a = append(*a, v)
+	return fmt.Sprintf("@p%d", len(*a))
+}
+
+// parseManifestListRequest membaca protokol DataTables dari query string:
+// draw, start, length, search[value], columns[i][search][value] dan order[i][column|dir].
+func parseManifestListRequest(c *fiber.Ctx) (req manifestListRequest, err error) {
+	req = manifestListRequest{
+		SearchType:   strings.ToLower(strings.TrimSpace(c.Query("searchType", manifestSearchAll))),
+		ColumnSearch: map[int]string{},
+		DateRange:    c.Query("dateRange"),
+		DateField:    c.Query("dateField"),
+		Dept:         strings.TrimSpace(c.Query("dept")),
 	}
-	orderColumnIndexStr := c.Query("order[0][column]")
-	orderDir := c.Query("order[0][dir]")
-	dateRange := c.Query("dateRange")
+	req.Draw, _ = strconv.Atoi(c.Query("draw"))
+	req.Start, _ = strconv.Atoi(c.Query("start"))
+	req.Length, _ = strconv.Atoi(c.Query("length"))
 
 	// Default values jika konversi gagal atau 0
-	if length == 0 {
-		length = 10 // Default DataTables length
+	if req.Start < 0 {
+		req.Start = 0
+	}
+	if req.Length == 0 {
+		req.Length = 10 // Default DataTables length
+	}
+	if req.Length < 0 || req.Length > maxManifestPageLength {
+		req.Length = maxManifestPageLength // length=-1 berarti "semua" di DataTables
 	}
 
-	sanitizedSearchValue := sanitizeFilename(searchValue)
-	todayDate := time.Now().Format("20060102")
-
-	cacheFilename := fmt.Sprintf("manifest_%s_search_%s_start_%s_len_%s_ordercol_%s_ordir_%s.json",
-		todayDate, sanitizedSearchValue, c.Query("start"), c.Query("length"), sanitizeFilename(orderColumnIndexStr), sanitizeFilename(orderDir))
-
-	cacheFilePath := filepath.Join(cacheDir, cacheFilename)
+	req.Search = strings.TrimSpace(c.Query("search[value]"))
+	if req.Search == "" {
+		req.Search = strings.TrimSpace(c.Query("search")) // Fallback jika hanya 'search' yang ada
+	}
+	if req.SearchType == "" {
+		req.SearchType = manifestSearchAll
+	}
+	if !manifestSearchTypes[req.SearchType] {
+		return req, fmt.Errorf("unsupported searchType %q", req.SearchType)
+	}
+	if req.Search != "" && (req.SearchType == manifestSearchManifest || req.SearchType == manifestSearchSJ) {
+		if _, err := strconv.Atoi(req.Search); err != nil {
+			return req, fmt.Errorf("searchType %s requires a numeric value", req.SearchType)
+		}
+	}
 
-	// ============ LOGIKA CACHE ============
-	cacheMutex.Lock() // Kunci mutex sebelum mengakses file cache
-	defer cacheMutex.Unlock() // Pastikan mutex dilepaskan setelah selesai
-
-	// 1. Coba baca dari cache
-	if data, err := readCacheFile(cacheFilePath); err == nil {
-		log.Printf("Cache hit for %s", cacheFilePath)
-		var cachedResponse DataTableResponse
-		if unmarshalErr := json.Unmarshal(data, &cachedResponse); unmarshalErr == nil {
-			return c.JSON(cachedResponse.Data) // Mengembalikan hanya data
-		} else {
-			log.Printf("Error unmarshalling cached data: %v. Re-querying.", unmarshalErr)
+	for i, col := range columnMap {
+		if manifestColumnExpr[col] == "" || c.Query(fmt.Sprintf("columns[%d][searchable]", i)) == "false" {
+			continue
+		}
+		if v := strings.TrimSpace(c.Query(fmt.Sprintf("columns[%d][search][value]", i))); v != "" {
+			req.ColumnSearch[i] = v
 		}
-	} else {
-		log.Printf("Cache miss or error for %s: %v. Re-querying.", cacheFilePath, err)
 	}
 
-	// 2. Jika cache miss atau error, lakukan query ke database
-	orderColumn := " created"
-	actualOrderDir := "DESC"
-	if orderColumnIndexStr != "" {
-		colIndex, err := strconv.Atoi(orderColumnIndexStr)
-		if err == nil && colIndex >= 0 && colIndex < len(columnMap) && columnMap[colIndex] != "" {
-			orderColumn = columnMap[colIndex]
+	for i := 0; ; i++ {
+		colStr := c.Query(fmt.Sprintf("order[%d][column]", i))
+		if colStr == "" {
+			break
+		}
+		colIndex, err := strconv.Atoi(colStr)
+		if err != nil || colIndex < 0 || colIndex >= len(columnMap) || columnMap[colIndex] == "" {
+			continue
 		}
+		dir := "DESC"
+		if strings.ToLower(c.Query(fmt.Sprintf("order[%d][dir]", i))) == "asc" {
+			dir = "ASC"
+		}
+		req.Orders = append(req.Orders, manifestOrder{Column: columnMap[colIndex], Dir: dir})
 	}
-	if strings.ToLower(orderDir) == "asc" {
-		actualOrderDir = "ASC"
-	} else {
-		actualOrderDir = "DESC"
+
+	req.Dates, err = parseManifestDateRange(req.DateRange, req.DateField, time.Now(), envInt(manifestMaxRangeEnv, defaultManifestMaxRangeDays))
+	if err != nil {
+		return req, err
 	}
+	return req, nil
+}
 
-	dateWhereClause := ""
-	if dateRange != "" {
-		log.Printf("Date range received: %s", dateRange)
-	} else {
-		if searchValue != "" {
-			dateWhereClause = " DD.[DOCDATE] BETWEEN DATEADD(DAY, -100, GETDATE()) AND GETDATE() "
-		} else {
-			dateWhereClause = " DD.[DOCDATE] BETWEEN DATEADD(DAY, -30, GETDATE()) AND GETDATE() "
+// cacheKey menghasilkan nama file cache yang unik untuk kombinasi filter,
+// pengurutan dan halaman. draw sengaja tidak ikut karena selalu berubah.
+func (r manifestListRequest) cacheKey() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "search=%s:%s|start=%d|len=%d|range=%s|dept=%s", r.SearchType, r.Search, r.Start, r.Length, r.Dates.Key(), r.Dept)
+	for i, col := range columnMap {
+		if v, ok := r.ColumnSearch[i]; ok {
+			fmt.Fprintf(&sb, "|col%d(%s)=%s", i, col, v)
 		}
 	}
+	for _, o := range r.Orders {
+		fmt.Fprintf(&sb, "|order=%s %s", o.Column, o.Dir)
+	}
+	return fmt.Sprintf("manifest_%s_%s.json", time.Now().Format("20060102"), generateHashSuffix(sb.String()))
+}
 
-	// Pastikan getManifesDatatableDirect menerima argumen yang benar
-	dataResponse, executedQuery, err := getManifesDatatableDirect(db.GetDB(), start, length, searchValue, orderColumn, actualOrderDir, dateWhereClause, draw)
+// AjaxManifesHandler mengembalikan respons DataTables server-side lengkap
+// (draw, recordsTotal, recordsFiltered, data) untuk daftar manifes, dengan cache file.
+func AjaxManifesHandler(c *fiber.Ctx) error {
+	req, err := parseManifestListRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// ============ LOGIKA CACHE ============
+	// Request dengan kunci yang sama digabung; kunci berbeda berjalan paralel.
+	key := req.cacheKey()
+	src := auditSourceFromCtx(c)
+	scope := manifestFeedScope{Dates: req.Dates, Dept: req.Dept}
+	dataResponse, shared, err := manifestCacheStore.get(key, scope, func() (*DataTableResponse, error) {
+		resp, executedQuery, err := getManifesDatatableDirect(src, db.GetDB(), req)
+		if err == nil {
+			log.Printf("Manifest query executed (%d of %d rows): %s", resp.RecordsFiltered, resp.RecordsTotal, executedQuery)
+		}
+		return resp, err
+	})
 	if err != nil {
 		log.Printf("Error getting manifest data: %v", err)
 		return c.Status(fiber.StatusInternalServerError).SendString("Failed to retrieve data")
 	}
-
-	dataResponse.SearchValue = searchValue
-	dataResponse.ExecutedQuery = executedQuery
-
-	// 3. Tulis hasil query ke cache (tetap simpan format lengkap untuk konsistensi)
-	if err := writeCacheFile(cacheFilePath, dataResponse); err != nil {
-		log.Printf("Error writing cache file %s: %v", cacheFilePath, err)
+	if shared {
+		log.Printf("Cache hit for %s", key)
 	}
 
-	// Kembalikan hanya bagian Data dari response
-	return c.JSON(dataResponse.Data)
+	// Salin sebelum mengubah draw karena respons bisa dipakai bersama request lain.
+	out := *dataResponse
+	out.Draw = req.Draw // draw harus selalu sama dengan request
+	return c.JSON(out)
 }
 
 // Fungsi pembantu untuk membaca file cache
@@ -173,6 +282,20 @@ func readCacheFile(filePath string) ([]byte, error) {
 	return data, nil
 }
 
+// readManifestCache membaca dan mendekode respons DataTables dari file cache.
+func readManifestCache(filePath string) (*DataTableResponse, error) {
+	data, err := readCacheFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var resp DataTableResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		log.Printf("Error unmarshalling cached data %s: %v. Re-querying.", filePath, err)
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Fungsi pembantu untuk menulis data ke file cache
 func writeCacheFile(filePath string, data interface{}) error {
 	jsonData, err := json.Marshal(data)
@@ -181,12 +304,22 @@ func writeCacheFile(filePath string, data interface{}) error {
 	}
 
 	// Tulis ke file sementara dulu, lalu rename untuk atomic write
-	tempFilePath := filePath + ".tmp"
-	if err := os.WriteFile(tempFilePath, jsonData, 0644); err != nil {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary cache file: %w", err)
+	}
+	tempFilePath := tmp.Name()
+	_, err = tmp.Write(jsonData)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilePath)
 		return fmt.Errorf("failed to write temporary cache file: %w", err)
 	}
 
 	if err := os.Rename(tempFilePath, filePath); err != nil {
+		os.Remove(tempFilePath)
 		return fmt.Errorf("failed to rename temporary cache file: %w", err)
 	}
 
@@ -200,38 +333,7 @@ func startCacheCleanup() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		cacheMutex.Lock()
-		// Pastikan mutex selalu dilepaskan di akhir setiap siklus pembersihan
-		// untuk mencegah kebuntuan.
-		defer cacheMutex.Unlock()
-
-		files, err := os.ReadDir(cacheDir)
-		if err != nil {
-			log.Printf("Error reading cache directory for cleanup: %v", err)
-			continue
-		}
-
-		for _, file := range files {
-			if file.IsDir() {
-				continue
-			}
-
-			filePath := filepath.Join(cacheDir, file.Name())
-			fileInfo, err := os.Stat(filePath)
-			if err != nil {
-				log.Printf("Error statting cache file %s during cleanup: %v", filePath, err)
-				continue
-			}
-
-			// Hapus file jika sudah lebih tua dari cacheTTL
-			if time.Since(fileInfo.ModTime()) > cacheTTL {
-				if err := os.Remove(filePath); err != nil {
-					log.Printf("Error removing expired cache file %s: %v", filePath, err)
-				} else {
-					log.Printf("Removed expired cache file: %s", filePath)
-				}
-			}
-		}
+		manifestCacheStore.cleanup()
 	}
 }
 
@@ -254,17 +356,22 @@ var columnMap = []string{
 	"created",
 }
 
-func getManifesDatatableDirect(database *sql.DB, start, length int, searchValue, orderColumn, orderDir, dateWhereClause string, draw int) (*DataTableResponse, string, error) {
-	if length <= 0 {
-		return &DataTableResponse{
-			Draw:            draw,
-			RecordsTotal:    0,
-			RecordsFiltered: 0,
-			Data:            []ManifesRecord{},
-		}, "No query executed due to zero or negative length", nil
-	}
+// manifestLatestEvent mengembalikan OUTER APPLY (alias mm) ke event
+// me_manifest terakhir untuk SJ dan nomor manifes. me_manifest menyimpan satu
+// baris per event POD, jadi join biasa menggandakan SJ yang punya beberapa event.
+func manifestLatestEvent(sj, manifest string) string {
+	return `OUTER APPLY (
+			SELECT TOP 1 m.[status], m.reason, m.penerima, m.foto_bukti, m.img_signature, m.created
+			FROM [pksrv-sap].[pk_express].[dbo].me_manifest m
+			WHERE m.sj = ` + sj + ` AND m.no_manifes = ` + manifest + `
+			ORDER BY m.created DESC
+		) mm`
+}
 
-	baseQuery := `
+// manifestBaseQuery adalah query dasar daftar manifes (satu baris per SJ),
+// dipakai bersama oleh daftar, ekspor dan analitik. Filter ditambahkan
+// dengan " AND ..." di belakangnya.
+var manifestBaseQuery = `
 		SELECT DISTINCT
 			BP.U_IDU_DEPARTMENT AS dept,
 			t6.MANIFEST# AS manifes,
@@ -326,8 +433,7 @@ func getManifesDatatableDirect(database *sql.DB, start, length int, searchValue,
 			[pksrv-sap].[pandurasa_live].dbo.OPRC QP1 WITH (NOLOCK) ON IT.U_IDU_CC_BRAND = QP1.PrcCode
 		LEFT JOIN
 			[pksrv-sap].[pandurasa_live].[dbo].[@IDU_NOMOR_POLISI] T7 WITH (NOLOCK) ON T6.U_IDU_NoPol = T7.Code
-		LEFT JOIN
-			[pksrv-sap].[pk_express].[dbo].me_manifest mm ON dd.Docnum = mm.sj AND t6.MANIFEST# = mm.no_manifes
+		` + manifestLatestEvent("dd.Docnum", "t6.MANIFEST#") + `
 		LEFT JOIN
 			[pksrv-sap].[pk_express].[dbo].master_status ms ON mm.[status] = ms.id
 		LEFT JOIN
@@ -336,57 +442,108 @@ func getManifesDatatableDirect(database *sql.DB, start, length int, searchValue,
 			T6.MANIFEST# IS NOT NULL AND T6.U_IDU_NoPol IS NOT NULL
 	`
 
-	if dateWhereClause != "" {
-		baseQuery += " AND " + dateWhereClause
+// manifestBaseFilter mengembalikan filter tanggal dan departemen untuk
+// manifestBaseQuery: rentang dari dateRange (parameter terikat), atau default
+// 30 hari terakhir (100 hari bila ada pencarian) agar tidak memindai semua data.
+func manifestBaseFilter(req manifestListRequest, args *boundArgs) string {
+	var filter string
+	if req.Dates != nil {
+		filter += " AND " + req.Dates.whereClause(args)
+	} else if req.Search != "" {
+		filter += " AND DD.[DOCDATE] BETWEEN DATEADD(DAY, -100, GETDATE()) AND GETDATE() "
+	} else {
+		filter += " AND DD.[DOCDATE] BETWEEN DATEADD(DAY, -30, GETDATE()) AND GETDATE() "
+	}
+	if req.Dept != "" {
+		filter += " AND BP.U_IDU_DEPARTMENT = " + args.bind(req.Dept)
+	}
+	return filter
+}
+
+// getManifesDatatableDirect menjalankan query halaman data beserta dua query COUNT
+// (total dan terfilter) dan mengembalikan respons DataTables serta SQL halaman data.
+func getManifesDatatableDirect(src sqlAuditSource, database *sql.DB, req manifestListRequest) (*DataTableResponse, string, error) {
+	if req.Length <= 0 {
+		return &DataTableResponse{
+			Draw:            req.Draw,
+			RecordsTotal:    0,
+			RecordsFiltered: 0,
+			Data:            []ManifesRecord{},
+		}, "No query executed due to zero or negative length", nil
+	}
+
+	var args boundArgs
+	baseQuery := manifestBaseQuery + manifestBaseFilter(req, &args)
+
+	baseArgs := append([]interface{}(nil), args...)
+
+	// Semua kondisi pencarian memakai parameter terikat; nama kolom hanya
+	// berasal dari whitelist manifestColumnExpr.
+	var conditions []string
+	if req.Search != "" {
+		conditions = append(conditions, manifestSearchCondition(&args, req.SearchType, req.Search))
+	}
+	for i, col := range columnMap {
+		v, ok := req.ColumnSearch[i]
+		if !ok {
+			continue
+		}
+		expr := manifestColumnExpr[col]
+		if n, err := strconv.Atoi(v); err == nil && manifestNumericColumns[col] {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", expr, args.bind(n)))
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("CAST(%s AS NVARCHAR(400)) LIKE %s", expr, args.bind("%"+escapeLikeValue(v)+"%")))
 	}
 
 	filteredQuery := baseQuery
-	if searchValue != "" {
-		if intVal, err := strconv.Atoi(searchValue); err == nil {
-			filteredQuery += fmt.Sprintf(" AND (T6.MANIFEST# = %d OR dd.Docnum = %d)", intVal, intVal)
-		} else {
-			var searchConditions []string
-			searchColumns := []string{
-				"CAST(t6.MANIFEST# AS VARCHAR)",
-				"dd.Docnum",
-				"dd.cardname",
-				"T6.DRIVER",
-				"T6.U_IDU_NoPol",
-				"ms.[status]",
-				"mr.reason",
-				"dd.ShipToCode",
-			}
-			escapedSearchValue := strings.ReplaceAll(searchValue, "[", "[[]")
-			escapedSearchValue = strings.ReplaceAll(escapedSearchValue, "%", "[%]")
-			escapedSearchValue = strings.ReplaceAll(escapedSearchValue, "_", "[_]")
-
-			for _, col := range searchColumns {
-				searchConditions = append(searchConditions, fmt.Sprintf("%s LIKE '%%%s%%' ESCAPE '['", col, escapedSearchValue))
-			}
-			filteredQuery += " AND (" + strings.Join(searchConditions, " OR ") + ")"
+	if len(conditions) > 0 {
+		filteredQuery += " AND " + strings.Join(conditions, " AND ")
+	}
+
+	recordsTotal, err := countManifestRows(src, database, fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS t", baseQuery), baseArgs...)
+	if err != nil {
+		return nil, "", err
+	}
+	recordsFiltered := recordsTotal
+	if len(conditions) > 0 {
+		recordsFiltered, err = countManifestRows(src, database, fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS f", filteredQuery), args...)
+		if err != nil {
+			return nil, "", err
 		}
 	}
 
+	// Kolom urut berasal dari columnMap (whitelist), arah hanya ASC/DESC.
+	var orderParts []string
+	for _, o := range req.Orders {
+		orderParts = append(orderParts, fmt.Sprintf("d.[%s] %s", o.Column, o.Dir))
+	}
+	if len(orderParts) == 0 {
+		orderParts = append(orderParts, "d.ship_date DESC", "d.dept ASC", "d.created DESC")
+	}
+	// Kolom unik sebagai pemutus seri agar OFFSET/FETCH stabil antar halaman.
+	orderParts = append(orderParts, "d.manifes DESC", "d.sj DESC")
+
 	sqlData := fmt.Sprintf(`
-		SELECT * FROM (
-			%s
-		) AS DataWithRowNumber
-		
-		ORDER BY ship_date DESC, dept ASC, %s %s
-		OFFSET %d ROWS
-		FETCH NEXT %d ROWS ONLY;
-	`, filteredQuery, orderColumn, orderDir, start, length)
-
-	rows, err := database.Query(sqlData)
+		SELECT * FROM (%s) AS d
+		ORDER BY %s
+		OFFSET %s ROWS
+		FETCH NEXT %s ROWS ONLY;
+	`, filteredQuery, strings.Join(orderParts, ", "), args.bind(req.Start), args.bind(req.Length))
+	params := []interface{}(args)
+
+	manifesRecords := []ManifesRecord{}
+	started := time.Now()
+	rows, err := database.Query(sqlData, params...)
+	defer func() { recordSQLAudit(src, "query", sqlData, params, started, int64(len(manifesRecords)), err) }()
 	if err != nil {
 		return nil, "", fmt.Errorf("error querying data: %w", err)
 	}
 	defer rows.Close()
 
-	var manifesRecords []ManifesRecord
 	for rows.Next() {
 		var record ManifesRecord
-		err := rows.Scan(
+		err = rows.Scan(
 			&record.Dept,
 			&record.Manifes,
 			&record.SJ,
@@ -406,6 +563,9 @@ func getManifesDatatableDirect(database *sql.DB, start, length int, searchValue,
 		if err != nil {
 			return nil, "", fmt.Errorf("error scanning row: %w", err)
 		}
+		record.FotoBuktiURL = podImageURL(record.FotoBukti.String, 0)
+		record.FotoBuktiThumb = podImageURL(record.FotoBukti.String, podListThumbWidth)
+		record.ImgSignatureURL = podImageURL(record.ImgSignature.String, 0)
 		manifesRecords = append(manifesRecords, record)
 	}
 
@@ -413,11 +573,62 @@ func getManifesDatatableDirect(database *sql.DB, start, length int, searchValue,
 		return nil, "", fmt.Errorf("error iterating rows: %w", err)
 	}
 
-	recordsFetched := len(manifesRecords)
 	return &DataTableResponse{
-		Draw:            draw,
-		RecordsTotal:    recordsFetched,
-		RecordsFiltered: recordsFetched,
+		Draw:            req.Draw,
+		RecordsTotal:    recordsTotal,
+		RecordsFiltered: recordsFiltered,
 		Data:            manifesRecords,
 	}, sqlData, nil
 }
+
+// manifestSearchCondition membangun kondisi pencarian global sesuai mode.
+// Mode manifest/sj memakai kesamaan angka dan plate memakai LIKE awalan
+// sehingga indeks tetap bisa dipakai; nilai selalu dikirim sebagai parameter.
+func manifestSearchCondition(args *boundArgs, searchType, value string) string {
+	contains := "%" + escapeLikeValue(value) + "%"
+	switch searchType {
+	case manifestSearchManifest:
+		n, _ := strconv.Atoi(value)
+		return "T6.MANIFEST# = " + args.bind(n)
+	case manifestSearchSJ:
+		n, _ := strconv.Atoi(value)
+		return "dd.Docnum = " + args.bind(n)
+	case manifestSearchPlate:
+		return fmt.Sprintf("T6.U_IDU_NoPol LIKE %s", args.bind(strings.ToUpper(escapeLikeValue(value))+"%"))
+	case manifestSearchDriver:
+		return fmt.Sprintf("T6.DRIVER LIKE %s", args.bind(contains))
+	case manifestSearchCustomer:
+		p := args.bind(contains)
+		return fmt.Sprintf("(dd.CardCode = %s OR dd.cardname LIKE %s)", args.bind(value), p)
+	}
+
+	if n, err := strconv.Atoi(value); err == nil {
+		p := args.bind(n)
+		return fmt.Sprintf("(T6.MANIFEST# = %s OR dd.Docnum = %s)", p, p)
+	}
+	p := args.bind(contains)
+	var parts []string
+	for _, expr := range []string{"dd.cardname", "T6.DRIVER", "T6.U_IDU_NoPol", "ms.[status]", "mr.reason", "dd.ShipToCode", "dd.numatcard"} {
+		parts = append(parts, fmt.Sprintf("%s LIKE %s", expr, p))
+	}
+	return "(" + strings.Join(parts, " OR ") + ")"
+}
+
+// countManifestRows menjalankan query COUNT(*) untuk recordsTotal/recordsFiltered.
+func countManifestRows(src sqlAuditSource, database *sql.DB, query string, args ...interface{}) (int, error) {
+	var count int
+	started := time.Now()
+	err := database.QueryRow(query, args...).Scan(&count)
+	recordSQLAudit(src, "query", query, args, started, 1, err)
+	if err != nil {
+		return 0, fmt.Errorf("error counting rows: %w", err)
+	}
+	return count, nil
+}
+
+// escapeLikeValue meng-escape karakter wildcard LIKE dengan.
+func escapeLikeValue(v string) string {
+	v = strings.ReplaceAll(v, "[", "[[]")
+	v = strings.ReplaceAll(v, "%", "[%]")
+	return strings.ReplaceAll(v, "_", "[_]")
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeLikeValue(t *testing.T) {
+	cases := map[string]string{
+		"ABC":     "ABC",
+		"50%":     "50[%]",
+		"B_1":     "B[_]1",
+		"[x]":     "[[]x]",
+		"a[%_]%b": "a[[][%][_]][%]b",
+	}
+	for in, want := range cases {
+		if got := escapeLikeValue(in); got != want {
+			t.Errorf("escapeLikeValue(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestManifestSearchConditionLikePattern(t *testing.T) {
+	var args boundArgs
+	cond := manifestSearchCondition(&args, manifestSearchDriver, "10%_[A]")
+	if strings.Contains(cond, "ESCAPE") {
+		t.Errorf("bracket escaping must not be combined with an ESCAPE clause: %s", cond)
+	}
+	if len(args) != 1 || args[0] != "%10[%][_][[]A]%" {
+		t.Errorf("pattern = %v", args)
+	}
+
+	args = nil
+	manifestSearchCondition(&args, manifestSearchPlate, "b_12")
+	if len(args) != 1 || args[0] != "B[_]12%" {
+		t.Errorf("plate pattern = %v", args)
+	}
+}
+
+// Dua POD untuk satu SJ menghasilkan dua baris me_manifest. Query dasar
+// daftar/ekspor/KPI harus tetap satu baris per SJ: me_manifest hanya boleh
+// dibaca lewat OUTER APPLY TOP 1 event terakhir, bukan join biasa.
+func TestManifestBaseQueryUsesLatestEventOnly(t *testing.T) {
+	q := strings.ToLower(manifestBaseQuery)
+	if n := strings.Count(q, "me_manifest"); n != 1 {
+		t.Fatalf("expected me_manifest to be read once, found %d references", n)
+	}
+	apply := strings.ToLower(manifestLatestEvent("dd.Docnum", "t6.MANIFEST#"))
+	if !strings.Contains(q, apply) {
+		t.Fatal("base query must read me_manifest through manifestLatestEvent")
+	}
+	for _, frag := range []string{"outer apply (", "select top 1", "order by m.created desc", ") mm"} {
+		if !strings.Contains(apply, frag) {
+			t.Errorf("latest event apply missing %q", frag)
+		}
+	}
+	if strings.Contains(q, "join\n\t\t\t[pksrv-sap].[pk_express].[dbo].me_manifest") || strings.Contains(q, "join [pksrv-sap].[pk_express].[dbo].me_manifest") {
+		t.Error("base query still joins every me_manifest row")
+	}
+}
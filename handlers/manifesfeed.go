@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// Pengaturan feed status manifes (Server-Sent Events).
+const (
+	// manifestFeedPollEnv adalah interval (detik) polling me_manifest; 0 mematikan polling
+	// sehingga event hanya datang dari endpoint bukti kirim.
+	manifestFeedPollEnv = "MANIFEST_FEED_POLL_SECONDS"
+	// manifestFeedColumnEnv adalah kolom datetime me_manifest yang diperbarui
+	// setiap kali baris berubah (mis. modified yang diisi trigger). Wajib diisi
+	// agar polling berjalan: created tidak berubah saat status di-UPDATE
+	// aplikasi lain, sehingga perubahan itu tidak akan pernah terdeteksi.
+	manifestFeedColumnEnv = "MANIFEST_FEED_CHANGE_COLUMN"
+
+	defaultManifestFeedPollSeconds = 15
+	manifestFeedHeartbeat          = 25 * time.Second
+	manifestFeedBuffer             = 64
+	manifestFeedDedupeTTL          = 24 * time.Hour
+)
+
+var sqlIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ManifestStatusEvent dikirim ke pelanggan feed saat status SJ berubah.
+type ManifestStatusEvent struct {
+	Manifes  int       `json:"manifes"`
+	SJ       int       `json:"sj"`
+	Dept     string    `json:"dept"`
+	ShipDate string    `json:"ship_date"`
+	DocDate  string    `json:"doc_date"`
+	Status   string    `json:"status"`
+	Reason   string    `json:"reason,omitempty"`
+	Penerima string    `json:"penerima,omitempty"`
+	Changed  time.Time `json:"changed"`
+	Source   string    `json:"source"` // "pod" atau "poll"
+}
+
+// manifestFeedScope adalah lingkup langganan: rentang tanggal dan departemen.
+type manifestFeedScope struct {
+	Dates *manifestDateRange
+	Dept  string
+}
+
+func (s manifestFeedScope) matches(ev ManifestStatusEvent) bool {
+	if s.Dept != "" && s.Dept != ev.Dept {
+		return false
+	}
+	if s.Dates == nil {
+		return true
+	}
+	date := ev.DocDate
+	if s.Dates.Field == "ship_date" {
+		date = ev.ShipDate
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, s.Dates.From.Location())
+	if err != nil {
+		return false
+	}
+	return !d.Before(s.Dates.From) && !d.After(s.Dates.To)
+}
+
+type manifestFeedSub struct {
+	scope  manifestFeedScope
+	events chan ManifestStatusEvent
+	// lagging diset bila buffer penuh; klien diminta memuat ulang daftar.
+	lagging chan struct{}
+}
+
+// manifestFeedHub menyebarkan event ke pelanggan yang lingkupnya cocok.
+type manifestFeedHub struct {
+	mu   sync.Mutex
+	subs map[*manifestFeedSub]struct{}
+	last map[string]manifestFeedSeen // "manifes:sj" -> event terakhir yang dikirim
+}
+
+type manifestFeedSeen struct {
+	signature string
+	at        time.Time
+}
+
+var manifestFeed = &manifestFeedHub{
+	subs: map[*manifestFeedSub]struct{}{},
+	last: map[string]manifestFeedSeen{},
+}
+
+func (h *manifestFeedHub) subscribe(scope manifestFeedScope) *manifestFeedSub {
+	sub := &manifestFeedSub{
+		scope:   scope,
+		events:  make(chan ManifestStatusEvent, manifestFeedBuffer),
+		lagging: make(chan struct{}, 1),
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *manifestFeedHub) unsubscribe(sub *manifestFeedSub) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+func (h *manifestFeedHub) subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// publish mengirim event ke pelanggan. Event yang sama dengan event terakhir
+// untuk SJ tersebut (mis. dari endpoint bukti kirim lalu terdeteksi lagi oleh
+// polling) diabaikan. Mengembalikan false jika event duplikat.
+func (h *manifestFeedHub) publish(ev ManifestStatusEvent) bool {
+	key := fmt.Sprintf("%d:%d", ev.Manifes, ev.SJ)
+	signature := ev.Status + "|" + ev.Reason + "|" + ev.Penerima
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if seen, ok := h.last[key]; ok && seen.signature == signature {
+		return false
+	}
+	now := time.Now()
+	h.last[key] = manifestFeedSeen{signature: signature, at: now}
+	for k, seen := range h.last {
+		if now.Sub(seen.at) > manifestFeedDedupeTTL {
+			delete(h.last, k)
+		}
+	}
+
+	for sub := range h.subs {
+		if !sub.scope.matches(ev) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			select {
+			case sub.lagging <- struct{}{}:
+			default:
+			}
+		}
+	}
+	return true
+}
+
+// ManifestFeedHandler membuka stream SSE event status manifes.
+//
+//	GET /pkexpress/manifes/feed?dateRange=today&dateField=ship_date&dept=MT
+//
+// Tanpa dateRange, lingkupnya pengiriman hari ini (dateField default ship_date,
+// agar SJ yang dibuat hari sebelumnya tetap muncul). Event: "ready", "status"
+// (JSON ManifestStatusEvent) dan "resync" bila klien tertinggal dan perlu memuat ulang.
+func ManifestFeedHandler(c *fiber.Ctx) error {
+	dateRange := c.Query("dateRange", "today")
+	dateField := c.Query("dateField", "ship_date")
+	dates, err := parseManifestDateRange(dateRange, dateField, time.Now(), envInt(manifestMaxRangeEnv, defaultManifestMaxRangeDays))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	scope := manifestFeedScope{Dates: dates, Dept: c.Query("dept")}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	sub := manifestFeed.subscribe(scope)
+	log.Printf("Manifest feed subscriber joined (range %s, dept %q), %d active", dates.Key(), scope.Dept, manifestFeed.subscribers())
+
+	// Jangan memakai c di dalam stream writer: ctx sudah dikembalikan ke pool.
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			manifestFeed.unsubscribe(sub)
+			log.Printf("Manifest feed subscriber left, %d active", manifestFeed.subscribers())
+		}()
+
+		heartbeat := time.NewTicker(manifestFeedHeartbeat)
+		defer heartbeat.Stop()
+
+		if err := writeSSE(w, "ready", map[string]string{"scope": dates.Key(), "dept": scope.Dept}); err != nil {
+			return
+		}
+		for {
+			var err error
+			select {
+			case ev := <-sub.events:
+				err = writeSSE(w, "status", ev)
+			case <-sub.lagging:
+				err = writeSSE(w, "resync", map[string]string{"reason": "too many events, reload the list"})
+			case <-heartbeat.C:
+				_, err = w.WriteString(": ping\n\n")
+				if err == nil {
+					err = w.Flush()
+				}
+			}
+			if err != nil {
+				return // klien terputus
+			}
+		}
+	})
+	return nil
+}
+
+func writeSSE(w *bufio.Writer, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// manifestEventQuery memuat status me_manifest beserta dept dan tanggal SJ.
+// %s pertama adalah kolom perubahan, %s kedua kondisi WHERE.
+const manifestEventQuery = `
+	SELECT mm.no_manifes, mm.sj, BP.U_IDU_DEPARTMENT,
+		CONVERT(VARCHAR, t2.U_IDU_TANGGAL, 23), CONVERT(VARCHAR, dd.DocDate, 23),
+		ms.[status], mr.reason, mm.penerima, mm.[%[1]s]
+	FROM [pksrv-sap].[pk_express].[dbo].me_manifest mm
+	LEFT JOIN [pksrv-sap].[pk_express].[dbo].master_status ms ON mm.[status] = ms.id
+	LEFT JOIN [pksrv-sap].[pk_express].[dbo].master_reason mr ON mr.id = mm.reason
+	LEFT JOIN [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK) ON t2.DocNum = mm.no_manifes
+	LEFT JOIN [pksrv-sap].[pandurasa_live].dbo.ODLN dd WITH (NOLOCK) ON dd.DocNum = mm.sj AND dd.CANCELED = 'N'
+	LEFT JOIN [pksrv-sap].[pandurasa_live].dbo.OCRD BP WITH (NOLOCK) ON BP.CardCode = dd.CardCode
+	WHERE %[2]s
+	ORDER BY mm.[%[1]s]`
+
+// loadManifestEvents memuat event me_manifest; changeCol adalah kolom
+// datetime yang dipakai sebagai waktu perubahan dan urutan.
+func loadManifestEvents(src sqlAuditSource, database *sql.DB, changeCol, where string, args ...interface{}) ([]ManifestStatusEvent, error) {
+	query := fmt.Sprintf(manifestEventQuery, changeCol, where)
+	var events []ManifestStatusEvent
+	err := auditedQuery(src, database, query, args, func(rows *sql.Rows) error {
+		var ev ManifestStatusEvent
+		var dept, shipDate, docDate, status, reason, penerima sql.NullString
+		var changed sql.NullTime
+		if err := rows.Scan(&ev.Manifes, &ev.SJ, &dept, &shipDate, &docDate, &status, &reason, &penerima, &changed); err != nil {
+			return err
+		}
+		ev.Dept, ev.ShipDate, ev.DocDate = dept.String, shipDate.String, docDate.String
+		ev.Status, ev.Reason, ev.Penerima = status.String, reason.String, penerima.String
+		ev.Changed = changed.Time
+		events = append(events, ev)
+		return nil
+	})
+	return events, err
+}
+
+// manifestFeedColumn mengembalikan kolom perubahan dari MANIFEST_FEED_CHANGE_COLUMN;
+// ok bernilai false bila tidak diisi atau bukan identifier yang valid.
+func manifestFeedColumn() (col string, ok bool) {
+	col = envString(manifestFeedColumnEnv, "")
+	if col == "" {
+		return "", false
+	}
+	if !sqlIdentifierPattern.MatchString(col) {
+		log.Printf("Invalid %s %q", manifestFeedColumnEnv, col)
+		return "", false
+	}
+	return col, true
+}
+
+// manifestFeedCursor adalah posisi polling. Query memakai kolom >= marker
+// (bukan >) agar baris lain dengan waktu yang sama dengan marker tidak
+// terlewat; baris di marker yang sudah diproses diingat di seen.
+type manifestFeedCursor struct {
+	marker time.Time
+	seen   map[string]bool
+}
+
+func manifestFeedRowKey(ev ManifestStatusEvent) string {
+	return fmt.Sprintf("%d:%d|%s|%s|%s", ev.Manifes, ev.SJ, ev.Status, ev.Reason, ev.Penerima)
+}
+
+// advance membuang baris yang sudah diproses dan memajukan marker ke waktu
+// perubahan terbaru. events harus terurut menurut waktu perubahan.
+func (cur *manifestFeedCursor) advance(events []ManifestStatusEvent) []ManifestStatusEvent {
+	var fresh []ManifestStatusEvent
+	for _, ev := range events {
+		key := manifestFeedRowKey(ev)
+		if ev.Changed.Before(cur.marker) || (ev.Changed.Equal(cur.marker) && cur.seen[key]) {
+			continue
+		}
+		if ev.Changed.After(cur.marker) {
+			cur.marker = ev.Changed
+			cur.seen = map[string]bool{}
+		}
+		if ev.Changed.Equal(cur.marker) {
+			cur.seen[key] = true
+		}
+		fresh = append(fresh, ev)
+	}
+	return fresh
+}
+
+// notifyManifestChange dipanggil endpoint bukti kirim setelah commit: cache
+// daftar manifes untuk dept/tanggal SJ dibuang, lalu pelanggan feed langsung
+// menerima status baru tanpa menunggu polling.
+func notifyManifestChange(src sqlAuditSource, no, sj int) {
+	events, err := loadManifestEvents(src, db.GetDB(), "created", "mm.no_manifes = @p1 AND mm.sj = @p2", no, sj)
+	if err != nil || len(events) == 0 {
+		if err != nil {
+			log.Printf("Error loading manifest %d SJ %d for feed: %v", no, sj, err)
+		}
+		InvalidateManifestCache(ManifestStatusEvent{Manifes: no, SJ: sj})
+		return
+	}
+	// Setiap POD adalah baris event baru; yang terakhir adalah status terkini.
+	ev := events[len(events)-1]
+	InvalidateManifestCache(ev)
+	ev.Source = "pod"
+	ev.Changed = time.Now()
+	manifestFeed.publish(ev)
+}
+
+// StartManifestFeedPoller memantau kolom perubahan me_manifest dan
+// menyebarkan perubahan yang dibuat aplikasi lain. Polling tetap berjalan
+// tanpa pelanggan karena cache daftar manifes yang terdampak ikut dibuang.
+// Tanpa MANIFEST_FEED_CHANGE_COLUMN poller tidak dijalankan.
+func StartManifestFeedPoller() {
+	seconds := envInt(manifestFeedPollEnv, defaultManifestFeedPollSeconds)
+	if seconds <= 0 {
+		log.Printf("Manifest feed polling disabled (%s=%d)", manifestFeedPollEnv, seconds)
+		return
+	}
+	col, ok := manifestFeedColumn()
+	if !ok {
+		log.Printf("Manifest feed polling not started: set %s to a me_manifest column updated on every change", manifestFeedColumnEnv)
+		return
+	}
+
+	src := auditSourceJob("manifestfeed")
+	ticker := time.NewTicker(time.Duration(seconds) * time.Second)
+	defer ticker.Stop()
+
+	var cur *manifestFeedCursor
+	for range ticker.C {
+		database := db.GetDB()
+		if database == nil {
+			continue
+		}
+		if cur == nil {
+			var latest sql.NullTime
+			query := fmt.Sprintf("SELECT MAX([%s]) FROM [pksrv-sap].[pk_express].[dbo].me_manifest", col)
+			err := auditedQuery(src, database, query, nil, func(rows *sql.Rows) error { return rows.Scan(&latest) })
+			if err != nil {
+				log.Printf("Error initialising manifest feed poller: %v", err)
+				continue
+			}
+			// Mulai setelah baris terbaru: baris di marker dianggap sudah dilihat.
+			cur = &manifestFeedCursor{marker: latest.Time, seen: map[string]bool{}}
+			initial, err := loadManifestEvents(src, database, col, fmt.Sprintf("mm.[%s] >= @p1", col), formatFeedMarker(latest.Time))
+			if err != nil {
+				log.Printf("Error initialising manifest feed poller: %v", err)
+				cur = nil
+				continue
+			}
+			cur.advance(initial)
+			continue
+		}
+		events, err := loadManifestEvents(src, database, col, fmt.Sprintf("mm.[%s] >= @p1", col), formatFeedMarker(cur.marker))
+		if err != nil {
+			log.Printf("Error polling manifest changes: %v", err)
+			continue
+		}
+		var changed []ManifestStatusEvent
+		for _, ev := range cur.advance(events) {
+			ev.Source = "poll"
+			if manifestFeed.publish(ev) {
+				changed = append(changed, ev)
+			}
+		}
+		if len(changed) > 0 {
+			InvalidateManifestCache(changed...)
+		}
+	}
+}
+
+// formatFeedMarker memformat waktu sebagai literal datetime yang tidak ambigu
+// sehingga SQL Server mengonversi parameter, bukan kolomnya.
+func formatFeedMarker(t time.Time) string {
+	if t.IsZero() {
+		return "1900-01-01T00:00:00.000"
+	}
+	return t.Format("2006-01-02T15:04:05.000")
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManifestFeedHubScopeAndDedupe(t *testing.T) {
+	h := &manifestFeedHub{subs: map[*manifestFeedSub]struct{}{}, last: map[string]manifestFeedSeen{}}
+	now := time.Date(2025, 3, 13, 9, 0, 0, 0, time.Local)
+	today, err := parseManifestDateRange("today", "ship_date", now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mt := h.subscribe(manifestFeedScope{Dates: today, Dept: "MT"})
+	all := h.subscribe(manifestFeedScope{})
+
+	ev := ManifestStatusEvent{Manifes: 1, SJ: 10, Dept: "MT", ShipDate: "2025-03-13", Status: "TERKIRIM"}
+	if !h.publish(ev) {
+		t.Fatal("first event should be published")
+	}
+	if h.publish(ev) {
+		t.Fatal("identical event should be deduplicated")
+	}
+	h.publish(ManifestStatusEvent{Manifes: 2, SJ: 20, Dept: "GT", ShipDate: "2025-03-13", Status: "GAGAL"})
+	h.publish(ManifestStatusEvent{Manifes: 3, SJ: 30, Dept: "MT", ShipDate: "2025-03-12", Status: "GAGAL"})
+
+	if n := len(mt.events); n != 1 {
+		t.Fatalf("scoped subscriber got %d events, want 1", n)
+	}
+	if n := len(all.events); n != 3 {
+		t.Fatalf("unscoped subscriber got %d events, want 3", n)
+	}
+
+	h.unsubscribe(mt)
+	if h.subscribers() != 1 {
+		t.Fatal("unsubscribe failed")
+	}
+}
+
+func TestManifestFeedCursorKeepsRowsAtMarker(t *testing.T) {
+	t0 := time.Date(2025, 3, 13, 9, 0, 0, 0, time.Local)
+	t1 := t0.Add(time.Second)
+	cur := &manifestFeedCursor{marker: t0, seen: map[string]bool{}}
+
+	a := ManifestStatusEvent{Manifes: 1, SJ: 10, Status: "TERKIRIM", Changed: t0}
+	if got := cur.advance([]ManifestStatusEvent{a}); len(got) != 1 {
+		t.Fatalf("expected first row at the marker, got %d", len(got))
+	}
+
+	// Baris kedua dengan waktu yang sama dengan marker tetap diproses,
+	// baris yang sudah diproses tidak diulang.
+	b := ManifestStatusEvent{Manifes: 2, SJ: 20, Status: "GAGAL", Changed: t0}
+	got := cur.advance([]ManifestStatusEvent{a, b})
+	if len(got) != 1 || got[0].Manifes != 2 {
+		t.Fatalf("expected only the new row at the marker, got %+v", got)
+	}
+
+	c := ManifestStatusEvent{Manifes: 1, SJ: 10, Status: "GAGAL", Changed: t1}
+	got = cur.advance([]ManifestStatusEvent{a, b, c})
+	if len(got) != 1 || got[0].Status != "GAGAL" || !cur.marker.Equal(t1) {
+		t.Fatalf("expected the later row and a moved marker, got %+v marker %v", got, cur.marker)
+	}
+	if got := cur.advance([]ManifestStatusEvent{c}); len(got) != 0 {
+		t.Fatalf("row at the new marker repeated: %+v", got)
+	}
+}
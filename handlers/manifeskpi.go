@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// deliveryOnTimeGraceEnv adalah jumlah hari setelah SHIP_DATE yang masih
+// dihitung tepat waktu (default 0: terkirim di hari yang sama).
+const deliveryOnTimeGraceEnv = "DELIVERY_ONTIME_GRACE_DAYS"
+
+const defaultKPITop = 50
+
+// DeliveryKPI adalah angka kinerja untuk satu kelompok (driver, kendaraan, dst).
+//   - completion_rate: SJ terkirim / total SJ
+//   - failure_rate: SJ gagal / total SJ
+//   - on_time_rate: SJ terkirim tepat waktu / SJ terkirim
+//   - avg_upload_lag_hours: rata-rata jam dari awal hari SHIP_DATE sampai
+//     bukti kirim tercatat (me_manifest.created)
+type DeliveryKPI struct {
+	Key               string   `json:"key"`
+	Total             int      `json:"total"`
+	Delivered         int      `json:"delivered"`
+	Failed            int      `json:"failed"`
+	Pending           int      `json:"pending"`
+	NoStatus          int      `json:"no_status"`
+	OnTime            int      `json:"on_time"`
+	CompletionRate    float64  `json:"completion_rate"`
+	FailureRate       float64  `json:"failure_rate"`
+	OnTimeRate        float64  `json:"on_time_rate"`
+	AvgUploadLagHours *float64 `json:"avg_upload_lag_hours"`
+
+	lagSum   float64
+	lagCount int
+}
+
+// FailureReasonCount adalah jumlah SJ gagal per alasan (master_reason).
+type FailureReasonCount struct {
+	Reason string  `json:"reason"`
+	Count  int     `json:"count"`
+	Share  float64 `json:"share"`
+}
+
+// WeeklyKPI adalah KPI per minggu ISO berdasarkan SHIP_DATE.
+type WeeklyKPI struct {
+	Week      string `json:"week"`
+	WeekStart string `json:"week_start"`
+	DeliveryKPI
+}
+
+// DeliveryKPIReport adalah respons /pkexpress/manifes/kpi.
+type DeliveryKPIReport struct {
+	From           string               `json:"from,omitempty"`
+	To             string               `json:"to,omitempty"`
+	DateField      string               `json:"date_field,omitempty"`
+	Dept           string               `json:"dept,omitempty"`
+	OnTimeGrace    int                  `json:"on_time_grace_days"`
+	Overall        DeliveryKPI          `json:"overall"`
+	ByDriver       []DeliveryKPI        `json:"by_driver"`
+	ByVehicle      []DeliveryKPI        `json:"by_vehicle"`
+	ByDept         []DeliveryKPI        `json:"by_dept"`
+	ByCustomer     []DeliveryKPI        `json:"by_customer"`
+	FailureReasons []FailureReasonCount `json:"failure_reasons"`
+	Weekly         []WeeklyKPI          `json:"weekly"`
+}
+
+// deliveryFact adalah satu SJ dari manifestBaseQuery yang dipakai untuk KPI.
+type deliveryFact struct {
+	Dept     string
+	ShipDate string
+	NoPol    string
+	Cardname string
+	Driver   string
+	Status   string
+	Reason   sql.NullString
+	Created  sql.NullTime
+}
+
+// DeliveryKPIHandler mengembalikan KPI pengiriman per driver, kendaraan,
+// departemen dan customer, rincian alasan gagal serta tren mingguan.
+// Filter: dateRange/dateField dan dept seperti daftar manifes; top membatasi
+// jumlah baris per pengelompokan (default 50).
+func DeliveryKPIHandler(c *fiber.Ctx) error {
+	req, err := parseManifestListRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: err.Error()})
+	}
+	top, _ := strconv.Atoi(c.Query("top"))
+	if top <= 0 {
+		top = defaultKPITop
+	}
+
+	facts, err := loadDeliveryFacts(auditSourceFromCtx(c), db.GetDB(), req)
+	if err != nil {
+		log.Printf("Error loading delivery KPI data: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to compute delivery KPI."})
+	}
+
+	report := buildDeliveryKPIReport(facts, envInt(deliveryOnTimeGraceEnv, 0), top)
+	report.Dept = req.Dept
+	if req.Dates != nil {
+		report.From = req.Dates.From.Format("2006-01-02")
+		report.To = req.Dates.To.Format("2006-01-02")
+		report.DateField = req.Dates.Field
+	}
+	return c.JSON(report)
+}
+
+func loadDeliveryFacts(src sqlAuditSource, database *sql.DB, req manifestListRequest) ([]deliveryFact, error) {
+	var args boundArgs
+	// Pencarian tidak dipakai di analitik; filter hanya tanggal dan dept.
+	req.Search = ""
+	query := fmt.Sprintf(`
+		SELECT d.dept, d.ship_date, d.no_pol, d.cardname, d.driver, d.gr_status_me, d.reason, d.created
+		FROM (%s) AS d`, manifestBaseQuery+manifestBaseFilter(req, &args))
+
+	var facts []deliveryFact
+	err := auditedQuery(src, database, query, args, func(rows *sql.Rows) error {
+		var f deliveryFact
+		var dept, shipDate, noPol, cardname, driver, status sql.NullString
+		if err := rows.Scan(&dept, &shipDate, &noPol, &cardname, &driver, &status, &f.Reason, &f.Created); err != nil {
+			return err
+		}
+		f.Dept, f.ShipDate, f.NoPol = dept.String, shipDate.String, noPol.String
+		f.Cardname, f.Driver, f.Status = cardname.String, driver.String, status.String
+		facts = append(facts, f)
+		return nil
+	})
+	return facts, err
+}
+
+// buildDeliveryKPIReport menghitung seluruh KPI dari data per SJ.
+func buildDeliveryKPIReport(facts []deliveryFact, graceDays, top int) DeliveryKPIReport {
+	report := DeliveryKPIReport{OnTimeGrace: graceDays, Overall: DeliveryKPI{Key: "all"}}
+	byDriver := map[string]*DeliveryKPI{}
+	byVehicle := map[string]*DeliveryKPI{}
+	byDept := map[string]*DeliveryKPI{}
+	byCustomer := map[string]*DeliveryKPI{}
+	weekly := map[string]*WeeklyKPI{}
+	reasons := map[string]int{}
+
+	group := func(m map[string]*DeliveryKPI, key string) *DeliveryKPI {
+		if key == "" {
+			key = "(kosong)"
+		}
+		k, ok := m[key]
+		if !ok {
+			k = &DeliveryKPI{Key: key}
+			m[key] = k
+		}
+		return k
+	}
+
+	for _, f := range facts {
+		bucket := manifestStatusBucket(f.Status)
+		// Tanggal dari SQL Server tanpa zona waktu; samakan lokasinya dengan created.
+		loc := time.Local
+		if f.Created.Valid {
+			loc = f.Created.Time.Location()
+		}
+		shipDate, shipErr := time.ParseInLocation("2006-01-02", f.ShipDate, loc)
+		onTime := false
+		var lag *float64
+		if shipErr == nil && f.Created.Valid {
+			h := f.Created.Time.Sub(shipDate).Hours()
+			lag = &h
+			deadline := shipDate.AddDate(0, 0, graceDays+1)
+			onTime = bucket == manifestStatusDelivered && f.Created.Time.Before(deadline)
+		}
+
+		targets := []*DeliveryKPI{
+			&report.Overall,
+			group(byDriver, f.Driver),
+			group(byVehicle, f.NoPol),
+			group(byDept, f.Dept),
+			group(byCustomer, f.Cardname),
+		}
+		if shipErr == nil {
+			year, week := shipDate.ISOWeek()
+			key := fmt.Sprintf("%d-W%02d", year, week)
+			w, ok := weekly[key]
+			if !ok {
+				monday := shipDate.AddDate(0, 0, -((int(shipDate.Weekday()) + 6) % 7))
+				w = &WeeklyKPI{Week: key, WeekStart: monday.Format("2006-01-02"), DeliveryKPI: DeliveryKPI{Key: key}}
+				weekly[key] = w
+			}
+			targets = append(targets, &w.DeliveryKPI)
+		}
+		for _, k := range targets {
+			k.add(bucket, onTime, lag)
+		}
+
+		if bucket == manifestStatusFailed {
+			reason := f.Reason.String
+			if reason == "" {
+				reason = "(tanpa alasan)"
+			}
+			reasons[reason]++
+		}
+	}
+
+	report.Overall.finish()
+	report.ByDriver = sortedKPIs(byDriver, top)
+	report.ByVehicle = sortedKPIs(byVehicle, top)
+	report.ByDept = sortedKPIs(byDept, 0)
+	report.ByCustomer = sortedKPIs(byCustomer, top)
+
+	report.FailureReasons = []FailureReasonCount{}
+	for reason, n := range reasons {
+		report.FailureReasons = append(report.FailureReasons, FailureReasonCount{
+			Reason: reason,
+			Count:  n,
+			Share:  ratio(n, report.Overall.Failed),
+		})
+	}
+	sort.Slice(report.FailureReasons, func(i, j int) bool {
+		a, b := report.FailureReasons[i], report.FailureReasons[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Reason < b.Reason)
+	})
+
+	report.Weekly = []WeeklyKPI{}
+	for _, w := range weekly {
+		w.finish()
+		report.Weekly = append(report.Weekly, *w)
+	}
+	sort.Slice(report.Weekly, func(i, j int) bool { return report.Weekly[i].WeekStart < report.Weekly[j].WeekStart })
+	return report
+}
+
+func (k *DeliveryKPI) add(bucket string, onTime bool, lag *float64) {
+	k.Total++
+	switch bucket {
+	case manifestStatusDelivered:
+		k.Delivered++
+	case manifestStatusFailed:
+		k.Failed++
+	case manifestStatusPending:
+		k.Pending++
+	default:
+		k.NoStatus++
+	}
+	if onTime {
+		k.OnTime++
+	}
+	if lag != nil {
+		k.lagSum += *lag
+		k.lagCount++
+	}
+}
+
+func (k *DeliveryKPI) finish() {
+	k.CompletionRate = ratio(k.Delivered, k.Total)
+	k.FailureRate = ratio(k.Failed, k.Total)
+	k.OnTimeRate = ratio(k.OnTime, k.Delivered)
+	if k.lagCount > 0 {
+		avg := k.lagSum / float64(k.lagCount)
+		k.AvgUploadLagHours = &avg
+	}
+}
+
+// sortedKPIs mengurutkan kelompok dari total SJ terbanyak; top > 0 membatasi hasil.
+func sortedKPIs(m map[string]*DeliveryKPI, top int) []DeliveryKPI {
+	out := make([]DeliveryKPI, 0, len(m))
+	for _, k := range m {
+		k.finish()
+		out = append(out, *k)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Total > out[j].Total || (out[i].Total == out[j].Total && out[i].Key < out[j].Key)
+	})
+	if top > 0 && len(out) > top {
+		out = out[:top]
+	}
+	return out
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
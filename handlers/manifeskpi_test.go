@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestBuildDeliveryKPIReport(t *testing.T) {
+	at := func(s string) sql.NullTime {
+		tm, _ := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		return sql.NullTime{Time: tm, Valid: true}
+	}
+	facts := []deliveryFact{
+		{Dept: "MT", ShipDate: "2025-03-10", Driver: "BUDI", NoPol: "B1", Status: "TERKIRIM", Created: at("2025-03-10 14:00")},
+		{Dept: "MT", ShipDate: "2025-03-10", Driver: "BUDI", NoPol: "B1", Status: "TERKIRIM", Created: at("2025-03-11 10:00")},
+		{Dept: "GT", ShipDate: "2025-03-17", Driver: "ANDI", NoPol: "B2", Status: "GAGAL", Reason: sql.NullString{String: "TOKO TUTUP", Valid: true}, Created: at("2025-03-17 18:00")},
+		{Dept: "GT", ShipDate: "2025-03-17", Driver: "ANDI", NoPol: "B2"},
+	}
+
+	r := buildDeliveryKPIReport(facts, 0, 10)
+	o := r.Overall
+	if o.Total != 4 || o.Delivered != 2 || o.Failed != 1 || o.NoStatus != 1 || o.OnTime != 1 {
+		t.Fatalf("unexpected overall %+v", o)
+	}
+	if o.CompletionRate != 0.5 || o.OnTimeRate != 0.5 {
+		t.Fatalf("unexpected rates %+v", o)
+	}
+	if o.AvgUploadLagHours == nil || *o.AvgUploadLagHours != (14.0+34.0+18.0)/3 {
+		t.Fatalf("unexpected lag %v", o.AvgUploadLagHours)
+	}
+	if len(r.Weekly) != 2 || r.Weekly[0].Week != "2025-W11" || r.Weekly[1].WeekStart != "2025-03-17" {
+		t.Fatalf("unexpected weekly %+v", r.Weekly)
+	}
+	if len(r.FailureReasons) != 1 || r.FailureReasons[0].Reason != "TOKO TUTUP" || r.FailureReasons[0].Share != 1 {
+		t.Fatalf("unexpected reasons %+v", r.FailureReasons)
+	}
+	if len(r.ByDriver) != 2 || r.ByDriver[0].Key != "ANDI" {
+		t.Fatalf("unexpected drivers %+v", r.ByDriver)
+	}
+
+	// Dengan toleransi 1 hari, SJ kedua juga tepat waktu.
+	if got := buildDeliveryKPIReport(facts, 1, 10).Overall.OnTime; got != 2 {
+		t.Fatalf("grace days not applied, on time = %d", got)
+	}
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"my-fiber-app/db"
+)
+
+// Lokasi dan batas unggahan bukti kirim (proof of delivery).
+const (
+	podUploadDirEnv      = "POD_UPLOAD_DIR"
+	podMaxUploadBytesEnv = "POD_MAX_UPLOAD_BYTES"
+
+	defaultPODUploadDir      = "./uploads"
+	defaultPODMaxUploadBytes = 5 << 20
+	maxPenerimaLength        = 100
+)
+
+// podImageTypes adalah tipe gambar yang diterima, hasil deteksi isi file
+// (bukan dari header Content-Type klien), beserta ekstensi file yang disimpan.
+var podImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// errPODInvalid menandai kesalahan input yang dikembalikan sebagai 400,
+// errPODForbidden SJ yang bukan milik driver yang login (403).
+var (
+	errPODInvalid   = errors.New("invalid proof of delivery")
+	errPODForbidden = errors.New("not the assigned driver")
+)
+
+// PODResponse adalah respons endpoint unggah bukti kirim.
+type PODResponse struct {
+	Success      bool   `json:"success"`
+	Message      string `json:"message"`
+	Manifes      int    `json:"manifes,omitempty"`
+	SJ           int    `json:"sj,omitempty"`
+	FotoBukti    string `json:"foto_bukti,omitempty"`
+	ImgSignature string `json:"img_signature,omitempty"`
+}
+
+// podUploadDir mengembalikan direktori root penyimpanan gambar bukti kirim.
+func podUploadDir() string {
+	return envString(podUploadDirEnv, defaultPODUploadDir)
+}
+
+// PODBodyLimit adalah batas body rute unggah bukti kirim: foto dan tanda
+// tangan masing-masing sampai POD_MAX_UPLOAD_BYTES, ditambah field form.
+// Rute lain tetap memakai batas default fiber lewat LimitBody.
+func PODBodyLimit() int {
+	return 2*envInt(podMaxUploadBytesEnv, defaultPODMaxUploadBytes) + 1<<20
+}
+
+// LimitBody menolak request dengan body lebih dari limit byte. skip (boleh
+// nil) mengecualikan rute yang memasang batasnya sendiri.
+func LimitBody(limit int, skip func(*fiber.Ctx) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if skip != nil && skip(c) {
+			return c.Next()
+		}
+		if c.Request().Header.ContentLength() > limit || len(c.Request().Body()) > limit {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(Response{
+				Success: false,
+				Message: fmt.Sprintf("Request body exceeds %d bytes.", limit),
+			})
+		}
+		return c.Next()
+	}
+}
+
+// IsPODUpload bernilai true untuk POST /pkexpress/manifes/:no/sj/:sj/pod.
+func IsPODUpload(c *fiber.Ctx) bool {
+	parts := strings.Split(strings.Trim(c.Path(), "/"), "/")
+	return c.Method() == fiber.MethodPost && len(parts) == 6 &&
+		parts[0] == "pkexpress" && parts[1] == "manifes" && parts[3] == "sj" && parts[5] == "pod"
+}
+
+// ManifesPODHandler menerima bukti kirim dari driver untuk pasangan manifes/SJ
+// (multipart: foto, signature, penerima, status, reason), menyimpan gambar ke
+// disk, mencatat event baru di me_manifest, lalu membuang cache manifes.
+// Pemanggil harus login (RequireUser) sebagai driver manifes tersebut, atau admin.
+func ManifesPODHandler(c *fiber.Ctx) error {
+	user := authenticatedUser(c)
+	if user == "" && !isAdminRequest(c) {
+		return c.Status(fiber.StatusUnauthorized).JSON(PODResponse{Success: false, Message: "Login required (X-User-Token)."})
+	}
+
+	no, errNo := strconv.Atoi(c.Params("no"))
+	sj, errSJ := strconv.Atoi(c.Params("sj"))
+	if errNo != nil || errSJ != nil || no <= 0 || sj <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: "Invalid manifest or SJ number."})
+	}
+
+	status, err := strconv.Atoi(strings.TrimSpace(c.FormValue("status")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: "Field 'status' is required and must be a number."})
+	}
+	var reason sql.NullInt64
+	if raw := strings.TrimSpace(c.FormValue("reason")); raw != "" {
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: "Field 'reason' must be a number."})
+		}
+		reason = sql.NullInt64{Int64: v, Valid: true}
+	}
+	penerima := strings.TrimSpace(c.FormValue("penerima"))
+	if len([]rune(penerima)) > maxPenerimaLength {
+		return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: fmt.Sprintf("Field 'penerima' is longer than %d characters.", maxPenerimaLength)})
+	}
+
+	src := auditSourceFromCtx(c)
+	database := db.GetDB()
+	if err := validatePODTarget(src, database, no, sj, status, reason, user); err != nil {
+		if errors.Is(err, errPODForbidden) {
+			return c.Status(fiber.StatusForbidden).JSON(PODResponse{Success: false, Message: err.Error()})
+		}
+		if errors.Is(err, errPODInvalid) {
+			return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: err.Error()})
+		}
+		log.Printf("Error validating POD for manifest %d SJ %d: %v", no, sj, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(PODResponse{Success: false, Message: "Failed to validate proof of delivery."})
+	}
+
+	// Simpan gambar lebih dulu; jika update database gagal, file dihapus lagi.
+	var saved []string
+	cleanup := func() {
+		for _, rel := range saved {
+			os.Remove(filepath.Join(podUploadDir(), rel))
+		}
+	}
+	var foto, signature sql.NullString
+	for _, f := range []struct {
+		field string
+		kind  string
+		dst   *sql.NullString
+	}{
+		{"foto", "foto", &foto},
+		{"signature", "ttd", &signature},
+	} {
+		fh, err := c.FormFile(f.field)
+		if errors.Is(err, fasthttp.ErrMissingFile) {
+			continue
+		}
+		if err != nil {
+			cleanup()
+			return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: fmt.Sprintf("Invalid upload for '%s': %v", f.field, err)})
+		}
+		rel, err := savePODImage(fh, no, sj, f.kind)
+		if err != nil {
+			cleanup()
+			if errors.Is(err, errPODInvalid) {
+				return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: fmt.Sprintf("'%s': %v", f.field, err)})
+			}
+			log.Printf("Error saving POD image %s for manifest %d SJ %d: %v", f.field, no, sj, err)
+			return c.Status(fiber.StatusInternalServerError).JSON(PODResponse{Success: false, Message: "Failed to store uploaded image."})
+		}
+		saved = append(saved, rel)
+		*f.dst = sql.NullString{String: rel, Valid: true}
+	}
+
+	if penerima == "" && !foto.Valid && !reason.Valid {
+		cleanup()
+		return c.Status(fiber.StatusBadRequest).JSON(PODResponse{Success: false, Message: "Provide 'penerima' and/or 'foto' for a delivery, or 'reason' for a failed delivery."})
+	}
+
+	if err := savePODRecord(src, database, no, sj, status, reason, penerima, foto, signature); err != nil {
+		cleanup()
+		log.Printf("Error inserting me_manifest for manifest %d SJ %d: %v", no, sj, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(PODResponse{Success: false, Message: "Failed to save proof of delivery."})
+	}
+
+	notifyManifestChange(src, no, sj)
+	log.Printf("POD saved for manifest %d SJ %d by %q (status %d)", no, sj, valueOr(user, "admin"), status)
+
+	return c.JSON(PODResponse{
+		Success:      true,
+		Message:      "Proof of delivery saved.",
+		Manifes:      no,
+		SJ:           sj,
+		FotoBukti:    foto.String,
+		ImgSignature: signature.String,
+	})
+}
+
+// validatePODTarget memastikan SJ termasuk dalam manifes, status/reason dikenal,
+// dan driver (user yang login, lewat master_user.fullname = nama supir manifes)
+// memang ditugaskan ke manifes tersebut. driver kosong berarti admin.
+func validatePODTarget(src sqlAuditSource, database *sql.DB, no, sj, status int, reason sql.NullInt64, driver string) error {
+	driverParam := sql.NullString{String: driver, Valid: driver != ""}
+	query := `
+		SELECT
+			(SELECT COUNT(*)
+			 FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_d_manifest] t0 WITH (NOLOCK)
+			 INNER JOIN [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK) ON t0.DocEntry = t2.DocEntry
+			 WHERE t2.DocNum = @p1 AND t0.U_IDU_NomorDO = @p2),
+			(SELECT COUNT(*) FROM [pksrv-sap].[pk_express].[dbo].master_status WHERE id = @p3),
+			CASE WHEN @p4 IS NULL THEN 1
+				ELSE (SELECT COUNT(*) FROM [pksrv-sap].[pk_express].[dbo].master_reason WHERE id = @p4) END,
+			CASE WHEN @p5 IS NULL THEN 1
+				ELSE (SELECT COUNT(*)
+					FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK)
+					INNER JOIN master_user mu ON mu.fullname = t2.U_IDU_NAMASUPIR
+					WHERE t2.DocNum = @p1 AND mu.user_code = @p5) END`
+	var sjCount, statusCount, reasonCount, driverCount int
+	err := auditedQuery(src, database, query, []interface{}{no, sj, status, reason, driverParam}, func(rows *sql.Rows) error {
+		return rows.Scan(&sjCount, &statusCount, &reasonCount, &driverCount)
+	})
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sjCount == 0:
+		return fmt.Errorf("%w: SJ %d is not part of manifest %d", errPODInvalid, sj, no)
+	case statusCount == 0:
+		return fmt.Errorf("%w: unknown status %d", errPODInvalid, status)
+	case reasonCount == 0:
+		return fmt.Errorf("%w: unknown reason %d", errPODInvalid, reason.Int64)
+	case driverCount == 0:
+		return fmt.Errorf("%w: manifest %d is not assigned to %s", errPODForbidden, no, driver)
+	}
+	return nil
+}
+
+// savePODImage memeriksa ukuran dan tipe isi file, lalu menyimpannya di bawah
+// podUploadDir. Path relatif (dipakai di me_manifest) dikembalikan.
+func savePODImage(fh *multipart.FileHeader, no, sj int, kind string) (string, error) {
+	maxBytes := int64(envInt(podMaxUploadBytesEnv, defaultPODMaxUploadBytes))
+	if fh.Size > maxBytes {
+		return "", fmt.Errorf("%w: file is %d bytes, maximum is %d", errPODInvalid, fh.Size, maxBytes)
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return "", fmt.Errorf("open upload: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", fmt.Errorf("%w: cannot read file", errPODInvalid)
+	}
+	ext, ok := podImageTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		return "", fmt.Errorf("%w: only JPEG, PNG or WebP images are accepted", errPODInvalid)
+	}
+
+	now := time.Now()
+	rel := filepath.ToSlash(filepath.Join("pod", now.Format("200601"),
+		fmt.Sprintf("%d_%d_%s_%s%s", no, sj, kind, now.Format("20060102150405.000000"), ext)))
+	dst := filepath.Join(podUploadDir(), filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", fmt.Errorf("create upload dir: %w", err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("create upload file: %w", err)
+	}
+	written, err := io.Copy(out, io.LimitReader(io.MultiReader(bytes.NewReader(head[:n]), f), maxBytes+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxBytes {
+		err = fmt.Errorf("%w: file exceeds %d bytes", errPODInvalid, maxBytes)
+	}
+	if err != nil {
+		os.Remove(dst)
+		return "", err
+	}
+	return rel, nil
+}
+
+// savePODRecord mencatat bukti kirim sebagai baris event baru di me_manifest
+// (created = waktu simpan), sehingga timeline detail manifes dan deteksi
+// perubahan feed melihat setiap POD. Penerima dan gambar yang tidak dikirim
+// diambil dari event terakhir SJ tersebut.
+func savePODRecord(src sqlAuditSource, database *sql.DB, no, sj, status int, reason sql.NullInt64, penerima string, foto, signature sql.NullString) error {
+	penerimaParam := sql.NullString{String: penerima, Valid: penerima != ""}
+	_, err := auditedExec(src, database, `
+		INSERT INTO [pksrv-sap].[pk_express].[dbo].me_manifest
+			(no_manifes, sj, [status], reason, penerima, foto_bukti, img_signature, created)
+		SELECT @p1, @p2, @p3, @p4,
+			COALESCE(@p5, prev.penerima),
+			COALESCE(@p6, prev.foto_bukti),
+			COALESCE(@p7, prev.img_signature),
+			GETDATE()
+		FROM (SELECT 1 AS one) x
+		OUTER APPLY (
+			SELECT TOP 1 mm.penerima, mm.foto_bukti, mm.img_signature
+			FROM [pksrv-sap].[pk_express].[dbo].me_manifest mm
+			WHERE mm.no_manifes = @p1 AND mm.sj = @p2
+			ORDER BY mm.created DESC
+		) prev`,
+		no, sj, status, reason, penerimaParam, foto, signature)
+	if err != nil {
+		return fmt.Errorf("insert me_manifest: %w", err)
+	}
+	return nil
+}
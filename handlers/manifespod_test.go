@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLimitBodySkipsPODUpload(t *testing.T) {
+	app := fiber.New(fiber.Config{BodyLimit: 1 << 20})
+	app.Use(LimitBody(16, IsPODUpload))
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Post("/pkexpress/manifes/:no/sj/:sj/pod", ok)
+	app.Post("/anp/proposal", ok)
+
+	body := strings.Repeat("x", 64)
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/anp/proposal", fiber.StatusRequestEntityTooLarge},
+		{"/pkexpress/manifes/12/sj/34/pod", fiber.StatusOK},
+		{"/pkexpress/manifes/12/sj/34/pod/extra", fiber.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		resp, err := app.Test(httptest.NewRequest("POST", tc.path, strings.NewReader(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %d", tc.path, tc.want, resp.StatusCode)
+		}
+	}
+}
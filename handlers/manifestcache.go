@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// manifestCache mengatur cache file daftar manifes tanpa kunci global:
+//   - request dengan kunci sama digabung (singleflight) sehingga query hanya
+//     jalan sekali, sementara kunci lain berjalan paralel;
+//   - indeks file -> lingkup (rentang tanggal dan dept) dipakai untuk
+//     invalidasi: perubahan status bisa menggeser hasil filter status,
+//     pencarian dan jumlah record di halaman mana pun dalam lingkup itu;
+//   - generasi dinaikkan tiap invalidasi agar hasil query yang sedang berjalan
+//     (dimulai sebelum invalidasi) tidak ditulis ke cache.
+type manifestCache struct {
+	mu       sync.Mutex
+	inflight map[string]*manifestCacheCall
+	index    map[string]manifestFeedScope // nama file -> lingkup filter
+	gen      uint64
+}
+
+type manifestCacheCall struct {
+	done chan struct{}
+	resp *DataTableResponse
+	err  error
+}
+
+var manifestCacheStore = &manifestCache{
+	inflight: map[string]*manifestCacheCall{},
+	index:    map[string]manifestFeedScope{},
+}
+
+// get mengembalikan isi cache untuk kunci, atau menjalankan load tepat sekali
+// untuk semua request bersamaan dengan kunci yang sama. shared bernilai true
+// jika hasil berasal dari cache atau dari load milik request lain.
+func (m *manifestCache) get(key string, scope manifestFeedScope, load func() (*DataTableResponse, error)) (resp *DataTableResponse, shared bool, err error) {
+	filePath := filepath.Join(cacheDir, key)
+
+	if resp, err := readManifestCache(filePath); err == nil {
+		return resp, true, nil
+	}
+
+	m.mu.Lock()
+	if call, ok := m.inflight[key]; ok {
+		m.mu.Unlock()
+		<-call.done
+		return call.resp, true, call.err
+	}
+	call := &manifestCacheCall{done: make(chan struct{})}
+	m.inflight[key] = call
+	gen := m.gen
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.inflight, key)
+		m.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.resp, call.err = loadManifestPage(key, load)
+	if call.err != nil {
+		return nil, false, call.err
+	}
+
+	// File ditulis selagi lock dipegang: invalidate yang datang sesudah cek
+	// generasi menunggu sampai file dan indeksnya ada, lalu ikut membuangnya.
+	m.mu.Lock()
+	if gen != m.gen {
+		log.Printf("Manifest cache invalidated during load of %s, not caching result", key)
+	} else if err := writeCacheFile(filePath, call.resp); err != nil {
+		log.Printf("Error writing cache file %s: %v", filePath, err)
+	} else {
+		m.index[key] = scope
+	}
+	m.mu.Unlock()
+	return call.resp, false, nil
+}
+
+// loadManifestPage menjalankan load dan mengubah panic atau respons kosong
+// menjadi error, agar request yang menunggu tidak menerima nil tanpa error.
+func loadManifestPage(key string, load func() (*DataTableResponse, error)) (resp *DataTableResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while loading manifest page %s: %v", key, r)
+			resp, err = nil, fmt.Errorf("manifest query panicked: %v", r)
+		}
+	}()
+	resp, err = load()
+	if err == nil && resp == nil {
+		err = fmt.Errorf("manifest query returned no response")
+	}
+	return resp, err
+}
+
+// affectedBy bernilai true bila perubahan ev dapat mengubah halaman dengan
+// lingkup s. Dept atau tanggal yang tidak diketahui dianggap cocok.
+func (s manifestFeedScope) affectedBy(ev ManifestStatusEvent) bool {
+	if s.Dept != "" && ev.Dept != "" && s.Dept != ev.Dept {
+		return false
+	}
+	if s.Dates == nil {
+		return true
+	}
+	date := ev.DocDate
+	if s.Dates.Field == "ship_date" {
+		date = ev.ShipDate
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, s.Dates.From.Location())
+	if err != nil {
+		return true
+	}
+	return !d.Before(s.Dates.From) && !d.After(s.Dates.To)
+}
+
+// invalidate menghapus semua file cache yang lingkupnya terdampak salah satu
+// perubahan, bukan hanya halaman yang memuat nomor manifesnya. Tanpa
+// perubahan, atau untuk file yang tidak ada di indeks (mis. ditulis sebelum
+// restart), file selalu dihapus karena isinya tidak diketahui.
+func (m *manifestCache) invalidate(changes ...ManifestStatusEvent) int {
+	m.mu.Lock()
+	m.gen++
+	files, err := os.ReadDir(cacheDir)
+	if err != nil {
+		m.mu.Unlock()
+		log.Printf("Error reading cache directory for invalidation: %v", err)
+		return 0
+	}
+	var victims []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		scope, indexed := m.index[name]
+		hit := len(changes) == 0 || !indexed
+		for _, ev := range changes {
+			if hit {
+				break
+			}
+			hit = scope.affectedBy(ev)
+		}
+		if hit {
+			victims = append(victims, name)
+			delete(m.index, name)
+		}
+	}
+	m.mu.Unlock()
+
+	removed := 0
+	for _, name := range victims {
+		if err := os.Remove(filepath.Join(cacheDir, name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing invalidated cache file %s: %v", name, err)
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// cleanup menghapus file kadaluwarsa. Pembaca tidak pernah diblokir: file
+// ditulis atomik lewat rename, jadi file yang terhapus hanya berarti cache miss.
+func (m *manifestCache) cleanup() {
+	files, err := os.ReadDir(cacheDir)
+	if err != nil {
+		log.Printf("Error reading cache directory for cleanup: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue // file sudah dihapus oleh invalidasi
+		}
+		if time.Since(info.ModTime()) <= cacheTTL {
+			continue
+		}
+
+		filePath := filepath.Join(cacheDir, file.Name())
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing expired cache file %s: %v", filePath, err)
+			continue
+		}
+		m.mu.Lock()
+		delete(m.index, file.Name())
+		m.mu.Unlock()
+		log.Printf("Removed expired cache file: %s", filePath)
+	}
+}
+
+// InvalidateManifestCache dipanggil setiap kali status pengiriman manifes
+// berubah agar daftar manifes tidak menampilkan status lama hingga 30 menit.
+// Setiap perubahan membawa dept dan tanggal SJ; tanpa perubahan seluruh cache
+// dihapus.
+func InvalidateManifestCache(changes ...ManifestStatusEvent) {
+	removed := manifestCacheStore.invalidate(changes...)
+	nos := make([]int, 0, len(changes))
+	for _, ev := range changes {
+		nos = append(nos, ev.Manifes)
+	}
+	log.Printf("Manifest cache invalidated for %v: %d file(s) removed", nos, removed)
+}
+
+// manifestChangeScopes memuat dept dan tanggal setiap SJ pada manifes yang
+// diberikan, sebagai perubahan untuk invalidasi cache.
+func manifestChangeScopes(src sqlAuditSource, database *sql.DB, nos []int) ([]ManifestStatusEvent, error) {
+	var args boundArgs
+	placeholders := make([]string, len(nos))
+	for i, no := range nos {
+		placeholders[i] = args.bind(no)
+	}
+	query := fmt.Sprintf(`
+		SELECT DISTINCT t2.DocNum, t0.U_IDU_NomorDO, BP.U_IDU_DEPARTMENT,
+			CONVERT(VARCHAR, t2.U_IDU_TANGGAL, 23), CONVERT(VARCHAR, dd.DocDate, 23)
+		FROM [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_h_manifest] t2 WITH (NOLOCK)
+		INNER JOIN [pksrv-sap].[PANDURASA_LIVE].[dbo].[@idu_d_manifest] t0 WITH (NOLOCK) ON t0.DocEntry = t2.DocEntry
+		LEFT JOIN [pksrv-sap].[pandurasa_live].dbo.ODLN dd WITH (NOLOCK) ON dd.DocNum = t0.U_IDU_NomorDO AND dd.CANCELED = 'N'
+		LEFT JOIN [pksrv-sap].[pandurasa_live].dbo.OCRD BP WITH (NOLOCK) ON BP.CardCode = dd.CardCode
+		WHERE t2.DocNum IN (%s)`, strings.Join(placeholders, ","))
+	var changes []ManifestStatusEvent
+	err := auditedQuery(src, database, query, args, func(rows *sql.Rows) error {
+		var ev ManifestStatusEvent
+		var sj sql.NullInt64
+		var dept, shipDate, docDate sql.NullString
+		if err := rows.Scan(&ev.Manifes, &sj, &dept, &shipDate, &docDate); err != nil {
+			return err
+		}
+		ev.SJ = int(sj.Int64)
+		ev.Dept, ev.ShipDate, ev.DocDate = dept.String, shipDate.String, docDate.String
+		changes = append(changes, ev)
+		return nil
+	})
+	return changes, err
+}
+
+// InvalidateManifestCacheHandler memungkinkan aplikasi lain (mis. aplikasi
+// driver yang mengubah me_manifest) membuang cache manifes.
+// Nomor manifes lewat ?manifest=1,2,3: semua halaman dengan dept dan rentang
+// tanggal SJ-nya dihapus. Tanpa nomor, seluruh cache dihapus.
+func InvalidateManifestCacheHandler(c *fiber.Ctx) error {
+	var nos []int
+	for _, raw := range strings.Split(c.Query("manifest"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		no, err := strconv.Atoi(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{
+				Success: false,
+				Message: "Invalid manifest number: " + raw,
+			})
+		}
+		nos = append(nos, no)
+	}
+
+	var changes []ManifestStatusEvent
+	if len(nos) > 0 {
+		var err error
+		changes, err = manifestChangeScopes(auditSourceFromCtx(c), db.GetDB(), nos)
+		if err != nil {
+			// Lingkup tidak diketahui: perubahan tanpa dept/tanggal cocok dengan semua halaman.
+			log.Printf("Error loading scope of manifests %v, invalidating all pages: %v", nos, err)
+			changes = nil
+		}
+		for _, no := range nos {
+			if !containsManifest(changes, no) {
+				changes = append(changes, ManifestStatusEvent{Manifes: no})
+			}
+		}
+	}
+
+	removed := manifestCacheStore.invalidate(changes...)
+	return c.JSON(Response{
+		Success: true,
+		Message: strconv.Itoa(removed) + " cache file(s) removed",
+	})
+}
+
+func containsManifest(changes []ManifestStatusEvent, no int) bool {
+	for _, ev := range changes {
+		if ev.Manifes == no {
+			return true
+		}
+	}
+	return false
+}
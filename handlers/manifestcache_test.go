@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTempManifestCacheDir mengarahkan cacheDir ke direktori sementara test.
+func useTempManifestCacheDir(t *testing.T) {
+	old := cacheDir
+	cacheDir = t.TempDir()
+	t.Cleanup(func() { cacheDir = old })
+}
+
+func TestManifestCacheSingleflightAndInvalidate(t *testing.T) {
+	useTempManifestCacheDir(t)
+	m := &manifestCache{inflight: map[string]*manifestCacheCall{}, index: map[string]manifestFeedScope{}}
+	key := "manifest_test.json"
+	day := time.Date(2025, 3, 10, 0, 0, 0, 0, time.Local)
+	scope := manifestFeedScope{Dates: &manifestDateRange{Field: "docdate", From: day, To: day}, Dept: "MT"}
+
+	var loads int32
+	release := make(chan struct{})
+	load := func() (*DataTableResponse, error) {
+		atomic.AddInt32(&loads, 1)
+		<-release
+		return &DataTableResponse{Data: []ManifesRecord{{Manifes: 42}}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := m.get(key, scope, load); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("expected a single load, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, key)); err != nil {
+		t.Fatalf("expected cache file: %v", err)
+	}
+
+	if removed := m.invalidate(ManifestStatusEvent{Manifes: 7, Dept: "GT", DocDate: "2025-03-10"}); removed != 0 {
+		t.Fatalf("change in another dept removed %d files", removed)
+	}
+	if removed := m.invalidate(ManifestStatusEvent{Manifes: 7, Dept: "MT", DocDate: "2025-03-11"}); removed != 0 {
+		t.Fatalf("change outside the date range removed %d files", removed)
+	}
+	// Manifes lain di lingkup yang sama tetap membuang halaman ini: status
+	// baru bisa masuk ke filter status atau mengubah jumlah record.
+	if removed := m.invalidate(ManifestStatusEvent{Manifes: 7, Dept: "MT", DocDate: "2025-03-10"}); removed != 1 {
+		t.Fatalf("expected 1 file removed, got %d", removed)
+	}
+}
+
+func TestManifestCacheInvalidateUnknownScope(t *testing.T) {
+	useTempManifestCacheDir(t)
+	m := &manifestCache{inflight: map[string]*manifestCacheCall{}, index: map[string]manifestFeedScope{}}
+	load := func() (*DataTableResponse, error) { return &DataTableResponse{}, nil }
+	if _, _, err := m.get("a.json", manifestFeedScope{Dept: "MT"}, load); err != nil {
+		t.Fatal(err)
+	}
+	// File tanpa indeks (mis. dari sebelum restart) selalu dibuang.
+	if err := os.WriteFile(filepath.Join(cacheDir, "b.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if removed := m.invalidate(ManifestStatusEvent{Manifes: 1, Dept: "GT"}); removed != 1 {
+		t.Fatalf("expected only the unindexed file removed, got %d", removed)
+	}
+	// Perubahan tanpa dept/tanggal cocok dengan semua lingkup.
+	if removed := m.invalidate(ManifestStatusEvent{Manifes: 1}); removed != 1 {
+		t.Fatalf("expected scoped file removed, got %d", removed)
+	}
+}
+
+func TestManifestCacheLoadPanic(t *testing.T) {
+	useTempManifestCacheDir(t)
+	m := &manifestCache{inflight: map[string]*manifestCacheCall{}, index: map[string]manifestFeedScope{}}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	load := func() (*DataTableResponse, error) {
+		close(started)
+		<-release
+		panic("boom")
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := m.get("panic.json", manifestFeedScope{}, load)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		resp, _, err := m.get("panic.json", manifestFeedScope{}, load)
+		if resp != nil {
+			t.Error("waiter received a response from a panicking load")
+		}
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("expected an error from a panicking load")
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "panic.json")); !os.IsNotExist(err) {
+		t.Fatalf("panicking load must not be cached: %v", err)
+	}
+}
+
+func TestManifestCacheInvalidatedDuringLoad(t *testing.T) {
+	useTempManifestCacheDir(t)
+	m := &manifestCache{inflight: map[string]*manifestCacheCall{}, index: map[string]manifestFeedScope{}}
+	load := func() (*DataTableResponse, error) {
+		m.invalidate(ManifestStatusEvent{Manifes: 1})
+		return &DataTableResponse{}, nil
+	}
+	if _, _, err := m.get("stale.json", manifestFeedScope{}, load); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "stale.json")); !os.IsNotExist(err) {
+		t.Fatalf("page loaded across an invalidation must not be cached: %v", err)
+	}
+	if _, ok := m.index["stale.json"]; ok {
+		t.Fatal("page loaded across an invalidation must not be indexed")
+	}
+}
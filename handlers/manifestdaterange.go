@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// manifestMaxRangeEnv membatasi lebar dateRange (dalam hari) pada daftar manifes.
+const manifestMaxRangeEnv = "MANIFEST_MAX_RANGE_DAYS"
+
+const defaultManifestMaxRangeDays = 366
+
+// Kolom tanggal yang boleh dipakai untuk filter dateRange (?dateField=).
+var manifestDateFields = map[string]string{
+	"docdate":   "DD.[DOCDATE]",
+	"ship_date": "T6.SHIP_DATE",
+}
+
+// manifestDateRange adalah rentang tanggal yang sudah diparsing. To bersifat
+// inklusif; query memakai batas atas eksklusif To+1 hari.
+type manifestDateRange struct {
+	Field string
+	From  time.Time
+	To    time.Time
+}
+
+// manifestDateLayouts adalah format tanggal yang diterima untuk rentang eksplisit.
+var manifestDateLayouts = []string{"2006-01-02", "02/01/2006", "20060102"}
+
+// parseManifestDateRange mengubah nilai dateRange menjadi rentang tanggal.
+// Diterima preset (today, yesterday, this_week, last_week, last_7_days,
+// last_30_days, this_month, last_month), satu tanggal, atau dua tanggal yang
+// dipisah " - ", " to ", "," atau "|". Rentang melebihi maxDays ditolak.
+func parseManifestDateRange(raw, field string, now time.Time, maxDays int) (*manifestDateRange, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	field = strings.ToLower(strings.TrimSpace(field))
+	if field == "" {
+		field = "docdate"
+	}
+	if _, ok := manifestDateFields[field]; !ok {
+		return nil, fmt.Errorf("unsupported dateField %q (use docdate or ship_date)", field)
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	r := &manifestDateRange{Field: field}
+
+	preset := strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(raw))
+	switch preset {
+	case "today":
+		r.From, r.To = today, today
+	case "yesterday":
+		r.From = today.AddDate(0, 0, -1)
+		r.To = r.From
+	case "this_week":
+		// Minggu dimulai hari Senin.
+		offset := (int(today.Weekday()) + 6) % 7
+		r.From, r.To = today.AddDate(0, 0, -offset), today
+	case "last_week":
+		offset := (int(today.Weekday()) + 6) % 7
+		r.From = today.AddDate(0, 0, -offset-7)
+		r.To = r.From.AddDate(0, 0, 6)
+	case "last_7_days":
+		r.From, r.To = today.AddDate(0, 0, -6), today
+	case "last_30_days":
+		r.From, r.To = today.AddDate(0, 0, -29), today
+	case "this_month":
+		r.From, r.To = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location()), today
+	case "last_month":
+		r.From = time.Date(today.Year(), today.Month()-1, 1, 0, 0, 0, 0, today.Location())
+		r.To = r.From.AddDate(0, 1, -1)
+	default:
+		from, to, err := splitManifestDateRange(raw)
+		if err != nil {
+			return nil, err
+		}
+		r.From, r.To = from, to
+	}
+
+	if r.To.Before(r.From) {
+		return nil, fmt.Errorf("dateRange end %s is before start %s", r.To.Format("2006-01-02"), r.From.Format("2006-01-02"))
+	}
+	if days := r.Days(); maxDays > 0 && days > maxDays {
+		return nil, fmt.Errorf("dateRange covers %d days, maximum is %d", days, maxDays)
+	}
+	return r, nil
+}
+
+// splitManifestDateRange memparsing rentang eksplisit "start - end" atau satu tanggal.
+func splitManifestDateRange(raw string) (time.Time, time.Time, error) {
+	parts := []string{raw}
+	for _, sep := range []string{" - ", " to ", ",", "|"} {
+		if strings.Contains(raw, sep) {
+			parts = strings.SplitN(raw, sep, 2)
+			break
+		}
+	}
+
+	from, err := parseManifestDate(parts[0])
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = parseManifestDate(parts[1]); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+	return from, to, nil
+}
+
+func parseManifestDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range manifestDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q in dateRange", s)
+}
+
+// Days mengembalikan jumlah hari (inklusif) dalam rentang.
+func (r *manifestDateRange) Days() int {
+	return int(r.To.Sub(r.From).Hours()/24+0.5) + 1
+}
+
+// Key adalah representasi stabil rentang untuk kunci cache.
+func (r *manifestDateRange) Key() string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s..%s", r.Field, r.From.Format("20060102"), r.To.Format("20060102"))
+}
+
+// whereClause mengembalikan kondisi SQL dengan parameter terikat lewat args.
+// Tanggal dikirim sebagai string yyyymmdd agar konversi terjadi di sisi
+// parameter, bukan di kolom (tetap SARGable).
+func (r *manifestDateRange) whereClause(args *boundArgs) string {
+	col := manifestDateFields[r.Field]
+	from := args.bind(r.From.Format("20060102"))
+	to := args.bind(r.To.AddDate(0, 0, 1).Format("20060102"))
+	return fmt.Sprintf(" %s >= %s AND %s < %s ", col, from, col, to)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseManifestDateRange(t *testing.T) {
+	now := time.Date(2025, 3, 13, 15, 4, 0, 0, time.Local) // Kamis
+
+	cases := []struct {
+		raw, from, to string
+	}{
+		{"today", "20250313", "20250313"},
+		{"this week", "20250310", "20250313"},
+		{"last-30-days", "20250212", "20250313"},
+		{"last_month", "20250201", "20250228"},
+		{"2025-01-01 - 2025-01-31", "20250101", "20250131"},
+		{"01/02/2025 to 05/02/2025", "20250201", "20250205"},
+		{"2025-03-01", "20250301", "20250301"},
+	}
+	for _, tc := range cases {
+		r, err := parseManifestDateRange(tc.raw, "", now, 366)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.raw, err)
+		}
+		if got := r.From.Format("20060102"); got != tc.from {
+			t.Errorf("%q: from = %s, want %s", tc.raw, got, tc.from)
+		}
+		if got := r.To.Format("20060102"); got != tc.to {
+			t.Errorf("%q: to = %s, want %s", tc.raw, got, tc.to)
+		}
+	}
+
+	var params boundArgs
+	mustRange(t, "2025-01-01,2025-01-31").whereClause(&params)
+	if params[1] != "20250201" {
+		t.Errorf("upper bound should be exclusive next day, got %v", params[1])
+	}
+
+	for _, bad := range []string{"2025-02-01 - 2025-01-01", "2023-01-01 - 2025-01-01", "kemarin"} {
+		if _, err := parseManifestDateRange(bad, "", now, 366); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	if _, err := parseManifestDateRange("today", "created", now, 366); err == nil {
+		t.Error("unknown dateField should be rejected")
+	}
+}
+
+func mustRange(t *testing.T, raw string) *manifestDateRange {
+	t.Helper()
+	r, err := parseManifestDateRange(raw, "ship_date", time.Now(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/xuri/excelize/v2"
+	"my-fiber-app/db"
+)
+
+// Batas ekspor manifes ke XLSX.
+const (
+	manifestExportMaxRowsEnv  = "MANIFEST_EXPORT_MAX_ROWS"
+	manifestExportLinkDaysEnv = "MANIFEST_EXPORT_LINK_DAYS"
+
+	defaultManifestExportMaxRows  = 20000
+	defaultManifestExportLinkDays = 30
+
+	manifestSummarySheet = "Ringkasan"
+)
+
+// Kelompok status pengiriman, dipakai untuk warna dan rekap.
+const (
+	manifestStatusDelivered = "delivered"
+	manifestStatusFailed    = "failed"
+	manifestStatusPending   = "pending"
+	manifestStatusNone      = "none"
+)
+
+// manifestStatusBucket mengelompokkan teks master_status. Status yang tidak
+// dikenali dianggap masih dalam proses.
+func manifestStatusBucket(status string) string {
+	s := strings.ToUpper(strings.TrimSpace(status))
+	switch {
+	case s == "":
+		return manifestStatusNone
+	case strings.Contains(s, "GAGAL"), strings.Contains(s, "TOLAK"), strings.Contains(s, "RETUR"),
+		strings.Contains(s, "BATAL"), strings.Contains(s, "FAIL"), strings.Contains(s, "REJECT"):
+		return manifestStatusFailed
+	case strings.Contains(s, "TERKIRIM"), strings.Contains(s, "DITERIMA"), strings.Contains(s, "SELESAI"),
+		strings.Contains(s, "DELIVERED"), strings.Contains(s, "DONE"):
+		return manifestStatusDelivered
+	default:
+		return manifestStatusPending
+	}
+}
+
+// manifestStatusFill adalah warna latar sel status per kelompok.
+var manifestStatusFill = map[string]string{
+	manifestStatusDelivered: "C6EFCE",
+	manifestStatusFailed:    "FFC7CE",
+	manifestStatusPending:   "FFEB9C",
+	manifestStatusNone:      "EDEDED",
+}
+
+var manifestExportHeaders = []string{
+	"Manifes", "SJ", "Tgl Kirim", "No Pol", "PO Customer", "Customer", "Ship To",
+	"Driver", "Status", "Reason", "Penerima", "Foto Bukti", "Tanda Tangan", "Created",
+}
+
+// manifestDeptSummary adalah rekap satu departemen di sheet ringkasan.
+type manifestDeptSummary struct {
+	Dept   string
+	Total  int
+	Counts map[string]int
+}
+
+// ExportManifesXLSHandler mengekspor daftar manifes ke XLSX dengan filter yang
+// sama seperti AjaxManifesHandler (dateRange, search, searchType, dept, kolom,
+// urutan). Satu sheet per departemen ditambah sheet ringkasan.
+func ExportManifesXLSHandler(c *fiber.Ctx) error {
+	req, err := parseManifestListRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	req.Start = 0
+	req.Length = envInt(manifestExportMaxRowsEnv, defaultManifestExportMaxRows)
+
+	resp, _, err := getManifesDatatableDirect(auditSourceFromCtx(c), db.GetDB(), req)
+	if err != nil {
+		log.Printf("Error getting manifest data for export: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to retrieve data")
+	}
+
+	linkTTL := time.Duration(envInt(manifestExportLinkDaysEnv, defaultManifestExportLinkDays)) * 24 * time.Hour
+	f, err := buildManifestWorkbook(req, resp, c.BaseURL(), linkTTL)
+	if err != nil {
+		log.Printf("Error building manifest workbook: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to build export")
+	}
+	defer f.Close()
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		log.Printf("Error writing manifest workbook: %v", err)
+		return c.Status(fiber.StatusInternalServerError).SendString("Failed to build export")
+	}
+
+	c.Attachment(fmt.Sprintf("manifes_%s.xlsx", time.Now().Format("20060102_150405")))
+	return c.Send(buf.Bytes())
+}
+
+// buildManifestWorkbook menyusun workbook: sheet ringkasan lalu satu sheet per dept.
+func buildManifestWorkbook(req manifestListRequest, resp *DataTableResponse, baseURL string, linkTTL time.Duration) (*excelize.File, error) {
+	f := excelize.NewFile()
+	if err := f.SetSheetName("Sheet1", manifestSummarySheet); err != nil {
+		return nil, err
+	}
+
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "FFFFFF"},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"305496"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	linkStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Color: "0563C1", Underline: "single"}})
+	if err != nil {
+		return nil, err
+	}
+	statusStyles := map[string]int{}
+	for bucket, color := range manifestStatusFill {
+		id, err := f.NewStyle(&excelize.Style{Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{color}}})
+		if err != nil {
+			return nil, err
+		}
+		statusStyles[bucket] = id
+	}
+
+	byDept := map[string][]ManifesRecord{}
+	for _, r := range resp.Data {
+		byDept[r.Dept] = append(byDept[r.Dept], r)
+	}
+	depts := make([]string, 0, len(byDept))
+	for d := range byDept {
+		depts = append(depts, d)
+	}
+	sort.Strings(depts)
+
+	usedNames := map[string]bool{strings.ToLower(manifestSummarySheet): true}
+	var summaries []manifestDeptSummary
+	for _, dept := range depts {
+		sheet := uniqueSheetName(dept, usedNames)
+		if _, err := f.NewSheet(sheet); err != nil {
+			return nil, err
+		}
+		summary := manifestDeptSummary{Dept: dept, Counts: map[string]int{}}
+
+		if err := f.SetSheetRow(sheet, "A1", &manifestExportHeaders); err != nil {
+			return nil, err
+		}
+		lastCol, _ := excelize.ColumnNumberToName(len(manifestExportHeaders))
+		f.SetCellStyle(sheet, "A1", lastCol+"1", headerStyle)
+
+		for i, r := range byDept[dept] {
+			row := i + 2
+			cell := func(col int) string {
+				name, _ := excelize.CoordinatesToCellName(col, row)
+				return name
+			}
+			values := []interface{}{
+				r.Manifes, r.SJ, r.ShipDate, r.NoPol, r.POCustomer, r.Cardname, r.ShipTo,
+				r.Driver, r.GRStatusME, r.Reason.String, r.Penerima.String, "", "", "",
+			}
+			if r.Created.Valid {
+				values[13] = r.Created.Time.Format("2006-01-02 15:04")
+			}
+			if err := f.SetSheetRow(sheet, cell(1), &values); err != nil {
+				return nil, err
+			}
+
+			bucket := manifestStatusBucket(r.GRStatusME)
+			summary.Total++
+			summary.Counts[bucket]++
+			f.SetCellStyle(sheet, cell(9), cell(9), statusStyles[bucket])
+
+			for _, link := range []struct {
+				col int
+				ref string
+			}{{12, r.FotoBukti.String}, {13, r.ImgSignature.String}} {
+				u := signedPODImageURL(link.ref, 0, linkTTL)
+				if u == "" {
+					continue
+				}
+				f.SetCellValue(sheet, cell(link.col), "Lihat")
+				f.SetCellHyperLink(sheet, cell(link.col), baseURL+u, "External")
+				f.SetCellStyle(sheet, cell(link.col), cell(link.col), linkStyle)
+			}
+		}
+
+		f.SetPanes(sheet, &excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"})
+		f.AutoFilter(sheet, fmt.Sprintf("A1:%s%d", lastCol, len(byDept[dept])+1), nil)
+		f.SetColWidth(sheet, "A", "E", 14)
+		f.SetColWidth(sheet, "F", "G", 36)
+		f.SetColWidth(sheet, "H", "N", 18)
+		summaries = append(summaries, summary)
+	}
+
+	if err := writeManifestSummary(f, req, resp, summaries, headerStyle, statusStyles); err != nil {
+		return nil, err
+	}
+	f.SetActiveSheet(0)
+	return f, nil
+}
+
+// writeManifestSummary mengisi sheet ringkasan: filter yang dipakai dan rekap
+// status per departemen.
+func writeManifestSummary(f *excelize.File, req manifestListRequest, resp *DataTableResponse, summaries []manifestDeptSummary, headerStyle int, statusStyles map[string]int) error {
+	sheet := manifestSummarySheet
+	dateRange := "default (30 hari terakhir, 100 hari bila ada pencarian)"
+	if req.Dates != nil {
+		dateRange = fmt.Sprintf("%s s/d %s (%s)", req.Dates.From.Format("2006-01-02"), req.Dates.To.Format("2006-01-02"), req.Dates.Field)
+	}
+	info := [][]interface{}{
+		{"Dibuat", time.Now().Format("2006-01-02 15:04")},
+		{"Rentang tanggal", dateRange},
+		{"Departemen", valueOr(req.Dept, "semua")},
+		{"Pencarian", valueOr(strings.TrimSpace(req.SearchType+" "+req.Search), "-")},
+		{"Jumlah baris", len(resp.Data)},
+	}
+	if resp.RecordsFiltered > len(resp.Data) {
+		info = append(info, []interface{}{"Catatan", fmt.Sprintf("Dibatasi %d dari %d baris; persempit filter untuk data lengkap.", len(resp.Data), resp.RecordsFiltered)})
+	}
+	for i, row := range info {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			return err
+		}
+	}
+
+	start := len(info) + 2
+	headers := []interface{}{"Dept", "Total SJ", "Terkirim", "Gagal", "Proses", "Belum Ada Status", "% Terkirim"}
+	headerCell, _ := excelize.CoordinatesToCellName(1, start)
+	if err := f.SetSheetRow(sheet, headerCell, &headers); err != nil {
+		return err
+	}
+	lastHeader, _ := excelize.CoordinatesToCellName(len(headers), start)
+	f.SetCellStyle(sheet, headerCell, lastHeader, headerStyle)
+
+	buckets := []string{manifestStatusDelivered, manifestStatusFailed, manifestStatusPending, manifestStatusNone}
+	total := manifestDeptSummary{Dept: "TOTAL", Counts: map[string]int{}}
+	for _, s := range summaries {
+		total.Total += s.Total
+		for _, b := range buckets {
+			total.Counts[b] += s.Counts[b]
+		}
+	}
+	for i, s := range append(summaries, total) {
+		row := start + 1 + i
+		pct := 0.0
+		if s.Total > 0 {
+			pct = float64(s.Counts[manifestStatusDelivered]) / float64(s.Total)
+		}
+		values := []interface{}{valueOr(s.Dept, "(tanpa dept)"), s.Total}
+		for _, b := range buckets {
+			values = append(values, s.Counts[b])
+		}
+		values = append(values, pct)
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+			return err
+		}
+		for j, b := range buckets {
+			c, _ := excelize.CoordinatesToCellName(3+j, row)
+			f.SetCellStyle(sheet, c, c, statusStyles[b])
+		}
+	}
+
+	pctStyle, err := f.NewStyle(&excelize.Style{NumFmt: 10}) // 0.00%
+	if err != nil {
+		return err
+	}
+	first, _ := excelize.CoordinatesToCellName(7, start+1)
+	last, _ := excelize.CoordinatesToCellName(7, start+1+len(summaries))
+	f.SetCellStyle(sheet, first, last, pctStyle)
+	f.SetColWidth(sheet, "A", "A", 20)
+	f.SetColWidth(sheet, "B", "G", 16)
+	return nil
+}
+
+// uniqueSheetName membuat nama sheet valid (maks 31 karakter, tanpa karakter
+// terlarang) yang belum dipakai.
+func uniqueSheetName(name string, used map[string]bool) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "Tanpa Dept"
+	}
+	if len([]rune(name)) > 31 {
+		name = string([]rune(name)[:31])
+	}
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		base := []rune(name)
+		if len(base)+len(suffix) > 31 {
+			base = base[:31-len(suffix)]
+		}
+		candidate = string(base) + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+func valueOr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
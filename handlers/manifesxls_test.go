@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestBuildManifestWorkbook(t *testing.T) {
+	t.Setenv(podImageSecretEnv, "rahasia")
+	resp := &DataTableResponse{
+		RecordsFiltered: 3,
+		Data: []ManifesRecord{
+			{Dept: "MT", Manifes: 1, SJ: 10, GRStatusME: "TERKIRIM", FotoBukti: sql.NullString{String: "pod/x.jpg", Valid: true}},
+			{Dept: "MT", Manifes: 1, SJ: 11, GRStatusME: "GAGAL KIRIM"},
+			{Dept: "GT/Retail", Manifes: 2, SJ: 12},
+		},
+	}
+	f, err := buildManifestWorkbook(manifestListRequest{}, resp, "http://localhost", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if got := f.GetSheetList(); len(got) != 3 || got[0] != manifestSummarySheet || got[1] != "GT-Retail" || got[2] != "MT" {
+		t.Fatalf("unexpected sheets %v", got)
+	}
+	if ok, link, _ := f.GetCellHyperLink("MT", "L2"); !ok || link == "" {
+		t.Fatal("expected hyperlink to proof image")
+	}
+	if v, _ := f.GetCellValue(manifestSummarySheet, "B10"); v != "3" {
+		t.Fatalf("expected total 3 SJ in summary, got %q", v)
+	}
+}
+
+func TestUniqueSheetName(t *testing.T) {
+	used := map[string]bool{}
+	a := uniqueSheetName("A/B", used)
+	b := uniqueSheetName("a-b", used)
+	if a != "A-B" || b != "a-b (2)" {
+		t.Fatalf("got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // registrasi decoder PNG untuk image.Decode
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Pengaturan endpoint gambar bukti kirim.
+const (
+	// podImageSecretEnv adalah kunci HMAC untuk URL gambar bertanda tangan.
+	// Jika kosong hanya request admin (X-Admin-Token) yang bisa melihat gambar.
+	podImageSecretEnv = "POD_IMAGE_SECRET"
+	// podLegacyDirEnv adalah direktori opsional untuk file lama yang disimpan
+	// hanya dengan nama file (sebelum endpoint unggah di service ini).
+	podLegacyDirEnv = "POD_LEGACY_DIR"
+
+	podImageURLTTL    = 12 * time.Hour
+	podImageMaxAge    = 7 * 24 * time.Hour
+	podThumbDir       = ".thumbs"
+	podThumbQuality   = 80
+	podThumbMinWidth  = 32
+	podThumbMaxWidth  = 1024
+	podThumbMaxPixels = 50_000_000
+)
+
+// PODImageHandler menyajikan foto_bukti / img_signature.
+//
+//	GET /pkexpress/pod/image?ref=<nilai tersimpan>[&w=<lebar thumbnail>]&exp=<unix>&sig=<hmac>
+//
+// Akses diizinkan untuk admin atau URL bertanda tangan yang belum kedaluwarsa
+// (dibuat oleh podImageURL). Dengan w, thumbnail JPEG dibuat sekali lalu disimpan;
+// w ikut ditandatangani sehingga pemegang link tidak bisa meminta lebar lain.
+func PODImageHandler(c *fiber.Ctx) error {
+	ref := c.Query("ref")
+	if ref == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Parameter 'ref' is required."})
+	}
+	if !isAdminRequest(c) && !validPODImageSignature(ref, c.Query("exp"), c.Query("w"), c.Query("sig"), time.Now()) {
+		return c.Status(fiber.StatusForbidden).JSON(Response{Success: false, Message: "Invalid or expired image link."})
+	}
+
+	path, err := resolvePODImage(ref)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: "Image not found."})
+	}
+
+	if raw := c.Query("w"); raw != "" {
+		width, err := strconv.Atoi(raw)
+		if err != nil || width < podThumbMinWidth {
+			return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid thumbnail width."})
+		}
+		if width > podThumbMaxWidth {
+			width = podThumbMaxWidth
+		}
+		thumb, err := podThumbnail(path, width)
+		if err != nil {
+			// Format yang tidak bisa di-decode (mis. WebP) disajikan apa adanya.
+			log.Printf("Cannot create thumbnail for %s: %v, serving original", path, err)
+		} else {
+			path = thumb
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: "Image not found."})
+	}
+
+	// Nama file unggahan unik dan tidak pernah ditimpa, jadi cache boleh lama.
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	setCacheHeaders := func() {
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(podImageMaxAge.Seconds())))
+		c.Set(fiber.HeaderLastModified, info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" && match == etag {
+		setCacheHeaders()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	if err := c.SendFile(path); err != nil {
+		return err
+	}
+	setCacheHeaders()
+	return nil
+}
+
+// podImageURL membuat URL bertanda tangan untuk nilai foto_bukti/img_signature.
+// Mengembalikan "" jika ref kosong atau POD_IMAGE_SECRET tidak diset.
+func podImageURL(ref string, width int) string {
+	return signedPODImageURL(ref, width, podImageURLTTL)
+}
+
+// signedPODImageURL adalah podImageURL dengan masa berlaku ttl.
+func signedPODImageURL(ref string, width int, ttl time.Duration) string {
+	secret := os.Getenv(podImageSecretEnv)
+	ref = strings.TrimSpace(ref)
+	if ref == "" || secret == "" {
+		return ""
+	}
+	exp := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	q := url.Values{}
+	q.Set("ref", ref)
+	q.Set("exp", exp)
+	w := ""
+	if width > 0 {
+		w = strconv.Itoa(width)
+		q.Set("w", w)
+	}
+	q.Set("sig", signPODImage(secret, ref, exp, w))
+	return "/pkexpress/pod/image?" + q.Encode()
+}
+
+// signPODImage menandatangani ref|exp, ditambah |w untuk link thumbnail.
+func signPODImage(secret, ref, exp, w string) string {
+	msg := ref + "|" + exp
+	if w != "" {
+		msg += "|" + w
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validPODImageSignature memeriksa sig (termasuk lebar thumbnail w) dan masa berlaku exp.
+func validPODImageSignature(ref, exp, w, sig string, now time.Time) bool {
+	secret := os.Getenv(podImageSecretEnv)
+	if secret == "" || sig == "" {
+		return false
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || now.Unix() > expUnix {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(signPODImage(secret, ref, exp, w)))
+}
+
+// resolvePODImage memetakan nilai tersimpan ke file di disk tanpa keluar dari
+// direktori unggahan. Nilai lama berupa nama file saja dicari di POD_LEGACY_DIR.
+func resolvePODImage(ref string) (string, error) {
+	clean := filepath.Clean("/" + filepath.FromSlash(strings.TrimSpace(ref)))
+	if strings.Contains(clean, string(filepath.Separator)+podThumbDir) {
+		return "", os.ErrNotExist
+	}
+
+	roots := []string{podUploadDir()}
+	if legacy := os.Getenv(podLegacyDirEnv); legacy != "" {
+		roots = append(roots, legacy)
+	}
+	for _, root := range roots {
+		path := filepath.Join(root, clean)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// podThumbnail mengembalikan path thumbnail JPEG selebar width untuk src,
+// membuatnya bila belum ada atau lebih tua dari file aslinya.
+func podThumbnail(src string, width int) (string, error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(filepath.Dir(src), podThumbDir, strconv.Itoa(width), filepath.Base(src)+".jpg")
+	if info, err := os.Stat(dst); err == nil && !info.ModTime().Before(srcInfo.ModTime()) {
+		return dst, nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	// Cek dimensi dulu agar file kecil berdimensi raksasa tidak menghabiskan memori.
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if cfg.Width*cfg.Height > podThumbMaxPixels {
+		return "", fmt.Errorf("image too large to thumbnail: %dx%d", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return "", err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	thumb := resizeImage(img, width)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	err = jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: podThumbQuality})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return dst, nil
+}
+
+// resizeImage mengecilkan gambar ke lebar width (rasio dipertahankan) dengan
+// rata-rata area (box filter). Transparansi (tanda tangan PNG) diratakan ke
+// latar putih karena hasilnya JPEG. Gambar yang lebih kecil tidak diperbesar.
+func resizeImage(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		width = b.Dx()
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, b, src, b.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				off := flat.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(flat.Pix[off])
+					g += uint32(flat.Pix[off+1])
+					bl += uint32(flat.Pix[off+2])
+					off += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255})
+		}
+	}
+	return dst
+}
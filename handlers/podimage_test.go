@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"image"
+	"image/color"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPODImageURLSignature(t *testing.T) {
+	t.Setenv(podImageSecretEnv, "rahasia")
+
+	link := podImageURL("pod/202510/1_2_foto.jpg", 160)
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(link, "/pkexpress/pod/image?") {
+		t.Fatalf("unexpected url %q: %v", link, err)
+	}
+	q := u.Query()
+	if !validPODImageSignature(q.Get("ref"), q.Get("exp"), q.Get("w"), q.Get("sig"), time.Now()) {
+		t.Fatal("signature should be valid")
+	}
+	if validPODImageSignature("pod/202510/other.jpg", q.Get("exp"), q.Get("w"), q.Get("sig"), time.Now()) {
+		t.Fatal("signature must be bound to ref")
+	}
+	for _, w := range []string{"", "161", "1024"} {
+		if validPODImageSignature(q.Get("ref"), q.Get("exp"), w, q.Get("sig"), time.Now()) {
+			t.Fatalf("signature must be bound to the thumbnail width, accepted w=%q", w)
+		}
+	}
+	if validPODImageSignature(q.Get("ref"), q.Get("exp"), q.Get("w"), q.Get("sig"), time.Now().Add(podImageURLTTL+time.Minute)) {
+		t.Fatal("expired link accepted")
+	}
+}
+
+func TestResolvePODImageStaysInUploadDir(t *testing.T) {
+	t.Setenv(podUploadDirEnv, t.TempDir())
+	if _, err := resolvePODImage("../../etc/passwd"); err == nil {
+		t.Fatal("path traversal resolved")
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 200)) // transparan
+	src.Set(0, 0, color.NRGBA{A: 255})
+	thumb := resizeImage(src, 100)
+	if b := thumb.Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Fatalf("unexpected size %v", b)
+	}
+	if r, g, b, _ := thumb.At(50, 25).RGBA(); r>>8 != 255 || g>>8 != 255 || b>>8 != 255 {
+		t.Fatal("transparent area should become white")
+	}
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// errProposalNotFound dikembalikan loadProposalDetail bila nomor tidak ada di tb_proposal.
+var errProposalNotFound = errors.New("proposal not found")
+
+// ProposalGroup adalah group customer (m_group) yang ikut dalam proposal.
+type ProposalGroup struct {
+	GroupCode string `json:"group_code"`
+	GroupName string `json:"group_name"`
+	Customers int    `json:"customers"`
+}
+
+// ProposalBudgetPosition adalah posisi budget proposal dari budget ledger,
+// sama dengan kolom Total_Budget/Balance di daftar proposal: terpakai dihitung
+// kumulatif sampai nomor proposal ini.
+type ProposalBudgetPosition struct {
+	BudgetType     string   `json:"budget_type"`
+	BudgetCode     string   `json:"budget_code"`
+	FsYear         string   `json:"fs_year"`
+	TotalBudget    *float64 `json:"total_budget"`
+	UsedToDate     *float64 `json:"used_to_date"`
+	Balance        *float64 `json:"balance"`
+	BudgetActivity float64  `json:"budget_activity"`
+	Costing        float64  `json:"costing"`
+	Realisasi      float64  `json:"realisasi"`
+	DNIn           float64  `json:"dn_in"`
+	DNPaid         float64  `json:"dn_paid"`
+	DNOutstanding  float64  `json:"dn_outstanding"`
+
+	// Posisi budget saat ini (bukan kumulatif sampai proposal ini).
+	Pool     *BudgetBalance `json:"pool,omitempty"`
+	Activity *BudgetBalance `json:"activity,omitempty"`
+}
+
+// ProposalDetail adalah respons /anp/proposal/:number. Bagian yang skemanya
+// mengikuti tabel (proposal, operating, SKP, lampiran, approval, DN, CN)
+// dikirim apa adanya sebagai kolom -> nilai.
+type ProposalDetail struct {
+	Success   bool                     `json:"success"`
+	Number    string                   `json:"number"`
+	Proposal  map[string]interface{}   `json:"proposal"`
+	Operating map[string]interface{}   `json:"operating"`
+	Customers []map[string]interface{} `json:"customers"`
+	Groups    []ProposalGroup          `json:"groups"`
+	SKP       []map[string]interface{} `json:"skp"`
+	Lampiran  []map[string]interface{} `json:"lampiran"`
+	Approvals []map[string]interface{} `json:"approvals"`
+	DNIn      []map[string]interface{} `json:"dn_in"`
+	DNPaid    []map[string]interface{} `json:"dn_paid"`
+	CN        []map[string]interface{} `json:"cn"`
+	Budget    *ProposalBudgetPosition  `json:"budget"`
+}
+
+// ProposalDetailHandler mengembalikan satu proposal ANP lengkap: data
+// operating, customer dan group, SKP, lampiran, riwayat approval, DN masuk dan
+// dibayar, potongan CN serta posisi budget.
+func ProposalDetailHandler(c *fiber.Ctx) error {
+	number, err := url.PathUnescape(c.Params("number"))
+	number = strings.TrimSpace(number)
+	if err != nil || number == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid proposal number."})
+	}
+
+	detail, err := loadProposalDetail(auditSourceFromCtx(c), db.GetDB(), number)
+	if errors.Is(err, errProposalNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: fmt.Sprintf("Proposal %s not found.", number)})
+	}
+	if err != nil {
+		log.Printf("Error loading proposal %s: %v", number, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to retrieve proposal."})
+	}
+	return c.JSON(detail)
+}
+
+func loadProposalDetail(src sqlAuditSource, database *sql.DB, number string) (*ProposalDetail, error) {
+	proposal, err := fetchDataFromDB(src, `SELECT TOP 1 * FROM tb_proposal WHERE [Number] = @p1`, number)
+	if err != nil {
+		return nil, fmt.Errorf("proposal: %w", err)
+	}
+	if len(proposal) == 0 {
+		return nil, errProposalNotFound
+	}
+
+	detail := &ProposalDetail{Success: true, Number: number, Proposal: proposal[0]}
+
+	operating, err := fetchDataFromDB(src, `
+		SELECT TOP 1 t2.*, mp.promo_name AS ActivityName, tb.BrandName
+		FROM tb_operating_proposal t2
+		LEFT JOIN m_promo mp ON mp.id = t2.ActivityCode
+		LEFT JOIN m_brand tb ON tb.BrandCode = t2.BrandCode
+		WHERE t2.ProposalNumber = @p1`, number)
+	if err != nil {
+		return nil, fmt.Errorf("operating: %w", err)
+	}
+	if len(operating) > 0 {
+		detail.Operating = operating[0]
+	}
+
+	// Bagian lain memakai @p1 = nomor proposal dan dikirim apa adanya.
+	// DN dibayar dibatasi tahun fiskal proposal ini, bukan jendela historis.
+	cal, now := loadFiscalCalendar(), time.Now()
+	fsYear := proposalFsYear(cal, detail.Proposal)
+	sections := []struct {
+		name  string
+		dst   *[]map[string]interface{}
+		query string
+	}{
+		{"customers", &detail.Customers, `
+			SELECT tc.*, mg.GroupName, mc.CustomerName
+			FROM tb_proposal_customer tc
+			LEFT JOIN m_group mg ON mg.GroupCode = tc.GroupCustomer
+			LEFT JOIN m_customer mc ON mc.CardCode = tc.CustomerCode
+			WHERE tc.ProposalNumber = @p1
+			ORDER BY tc.GroupCustomer, tc.CustomerCode`},
+		{"skp", &detail.SKP, `SELECT * FROM tb_proposal_skp WHERE ProposalNumber = @p1 ORDER BY id DESC`},
+		{"lampiran", &detail.Lampiran, `SELECT * FROM tb_proposal_lampiran WHERE ProposalNumber = @p1 ORDER BY id`},
+		{"approvals", &detail.Approvals, `SELECT * FROM tb_proposal_approved WHERE ProposalNumber = @p1 ORDER BY id`},
+		{"dn_in", &detail.DNIn, `
+			SELECT tx0.*
+			FROM ` + dnMasukFrom + `
+			WHERE ` + dnMasukWhere("@p1")},
+		{"dn_paid", &detail.DNPaid, `
+			SELECT tx0.*
+			FROM ` + dnDibayarFrom + `
+			INNER JOIN tb_proposal t1 ON t1.[Number] = tx0.U_pk_noproposal
+			WHERE ` + dnDibayarWhere("@p1") + `
+			ORDER BY tx0.tglbyr`},
+		{"cn", &detail.CN, `SELECT * FROM tb_anp_cn_potongan WHERE u_idu_noproposal = @p1`},
+	}
+	for _, s := range sections {
+		rows, err := fetchDataFromDB(src, cal.expandFiscalSQLFor(s.query, now, fsYear), number)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.name, err)
+		}
+		*s.dst = rows
+	}
+
+	if detail.Groups, err = loadProposalGroups(src, database, number); err != nil {
+		return nil, fmt.Errorf("groups: %w", err)
+	}
+	if detail.Budget, err = loadProposalBudgetPosition(src, database, number, fsYear); err != nil {
+		return nil, fmt.Errorf("budget: %w", err)
+	}
+	return detail, nil
+}
+
+func loadProposalGroups(src sqlAuditSource, database *sql.DB, number string) ([]ProposalGroup, error) {
+	groups := []ProposalGroup{}
+	err := auditedQuery(src, database, `
+		SELECT tc.GroupCustomer, MAX(mg.GroupName), COUNT(DISTINCT tc.CustomerCode)
+		FROM tb_proposal_customer tc
+		LEFT JOIN m_group mg ON mg.GroupCode = tc.GroupCustomer
+		WHERE tc.ProposalNumber = @p1
+		GROUP BY tc.GroupCustomer
+		ORDER BY tc.GroupCustomer`, []interface{}{number}, func(rows *sql.Rows) error {
+		var g ProposalGroup
+		var code, name sql.NullString
+		if err := rows.Scan(&code, &name, &g.Customers); err != nil {
+			return err
+		}
+		g.GroupCode, g.GroupName = code.String, name.String
+		groups = append(groups, g)
+		return nil
+	})
+	return groups, err
+}
+
+// proposalFsYear mengembalikan tahun fiskal StartDatePeriode proposal, 0 bila tidak diketahui.
+func proposalFsYear(cal FiscalCalendar, proposal map[string]interface{}) int {
+	start, _ := proposal["StartDatePeriode"].(string)
+	t, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return 0
+	}
+	return cal.YearOf(t).Year
+}
+
+// loadProposalBudgetPosition mengembalikan nil bila proposal belum punya baris operating.
+func loadProposalBudgetPosition(src sqlAuditSource, database *sql.DB, number string, fsYear int) (*ProposalBudgetPosition, error) {
+	var pos *ProposalBudgetPosition
+	err := auditedQuery(src, database, loadFiscalCalendar().expandFiscalSQLFor(`
+		SELECT TOP 1
+			t2.budget_type, t2.BrandCode, t2.BudgetCode, CAST(t2.fs_year AS VARCHAR(10)),
+			ISNULL(ISNULL(t2.costing_lama, t2.TotalCosting), 0),
+			ISNULL(t2.realisasi, 0),
+			ISNULL(`+dnDibuatSQL+`, 0),
+			ISNULL(`+dnDibayarSQL+`, 0)
+		FROM tb_proposal t1
+		INNER JOIN tb_operating_proposal t2 ON t2.ProposalNumber = t1.[Number]
+		WHERE t1.[Number] = @p1`, time.Now(), fsYear), []interface{}{number}, func(rows *sql.Rows) error {
+		var p ProposalBudgetPosition
+		var budgetType, brand, budgetCode, fsYear sql.NullString
+		if err := rows.Scan(&budgetType, &brand, &budgetCode, &fsYear, &p.Costing, &p.Realisasi, &p.DNIn, &p.DNPaid); err != nil {
+			return err
+		}
+		p.BudgetType, p.BudgetCode, p.FsYear = budgetType.String, budgetCode.String, fsYear.String
+		p.DNOutstanding = p.DNIn - p.DNPaid
+		pos = &p
+		return nil
+	})
+	if err != nil || pos == nil {
+		return pos, err
+	}
+
+	ledger, err := loadBudgetLedger(src, database, []string{pos.BudgetCode})
+	if err != nil {
+		return nil, err
+	}
+	if total, balance, ok := ledger.ProposalPosition(number); ok {
+		used := total - balance
+		pos.TotalBudget, pos.UsedToDate, pos.Balance = &total, &used, &balance
+	}
+	if a, ok := ledger.ProposalActivity(number); ok {
+		pos.BudgetActivity = a.Initial
+		pos.Activity = &a
+	}
+	if p, ok := ledger.proposals[number]; ok {
+		if pool, ok := ledger.Pool(p.BudgetType, p.BrandCode, p.BudgetCode); ok {
+			pos.Pool = &pool
+		}
+	}
+	return pos, nil
+}
@@ -0,0 +1,35 @@
+package handlers
+
+// Sumber DN per proposal, dipakai bersama oleh daftar proposal (PIC dan
+// apixl), detail proposal dan rekonsiliasi agar angka di semua tempat sama.
+
+// dnMasukFrom adalah sumber DN dibuat: DN masuk (tb_anp_dn_masuk) yang
+// terdaftar di tb_proposal_dn.
+const dnMasukFrom = `[APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_anp_dn_masuk tx0
+		INNER JOIN [APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_proposal_dn tdn1 ON tx0.numatcard = tdn1.nodn`
+
+// dnMasukWhere mencocokkan DN dibuat dengan nomor proposal (kolom atau parameter).
+func dnMasukWhere(number string) string {
+	return "(tx0.U_IDU_NoProposal = " + number + " OR tdn1.proposalnumber = " + number + ")"
+}
+
+// dnDibuatSQL adalah subquery total DN dibuat untuk proposal t1.
+var dnDibuatSQL = "(SELECT SUM(tx0.LineTotal) FROM " + dnMasukFrom + "\n\t\tWHERE " + dnMasukWhere("t1.Number") + ")"
+
+// dnDibayarFrom adalah sumber DN dibayar beserta pemetaan kode brand ANP.
+const dnDibayarFrom = `[APPSRV].[PK_ANP_DEV_QUERY].dbo.tb_anp_dn_dibayar tx0
+		INNER JOIN [APPSRV].[PK_ANP_DEV_QUERY].dbo.m_brand_anp tx1 ON tx0.kdbrand = tx1.code`
+
+// dnDibayarWhere mencocokkan DN dibayar dengan nomor proposal (kolom atau
+// parameter): hanya yang sudah dibayar, brand sama dengan proposal t1 dan
+// tahun fiskal bayar sama dengan tahun fiskal periode proposal. Query yang
+// memakainya harus melewati expandFiscalSQL.
+func dnDibayarWhere(number string) string {
+	return "tx0.U_pk_noproposal = " + number + ` AND tx0.tglbyr IS NOT NULL
+		AND tx0.kdbrand IS NOT NULL AND tx0.tglbyr >= FISCAL_HISTORY_FROM
+		AND tx1.BrandCode = t1.BrandCode
+		AND FISCAL_YEAR(tx0.tglbyr) = FISCAL_YEAR(t1.StartDatePeriode)`
+}
+
+// dnDibayarSQL adalah subquery total DN dibayar untuk proposal t1.
+var dnDibayarSQL = "(SELECT SUM(tx0.jmlbyr) FROM " + dnDibayarFrom + "\n\t\tWHERE " + dnDibayarWhere("t1.Number") + ")"
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Status SKP untuk filter skp=<n> pada daftar proposal.
+const (
+	proposalSkpNone     = 0 // belum ada SKP sama sekali
+	proposalSkpPending  = 1 // ada SKP yang belum diproses
+	proposalSkpApproved = 2
+	proposalSkpCanceled = 3
+)
+
+// ProposalFilter adalah model filter bersama daftar proposal PIC, direktur dan KAM.
+// Nilai multi (brand, group, activity, status) boleh dikirim berulang
+// (?brand=A&brand=B) atau dipisah koma (?brand=A,B).
+type ProposalFilter struct {
+	Number    string
+	Brand     []string
+	Group     []string
+	Activity  []string
+	Status    []string
+	StartDate string // YYYY-MM-DD, batas bawah StartDatePeriode
+	EndDate   string // YYYY-MM-DD, batas atas EndDatePeriode
+	FsYear    int    // tahun fiskal StartDatePeriode, 0 = tidak difilter
+	SKP       *int
+	UserCode  string // scope role, arti tergantung view
+}
+
+// proposalView mendefinisikan satu tampilan daftar proposal. Query selalu
+// memakai alias t1 untuk tb_proposal; kolom yang berbeda antar view
+// (activity, status) disebut lewat field di sini agar filter tetap satu.
+type proposalView struct {
+	name      string
+	selectSQL string // SELECT ... FROM ... tanpa WHERE
+	orderBy   string
+
+	userParam   string // nama query param untuk scope role
+	numberLike  bool   // number dicari dengan LIKE, bukan kesamaan
+	activityCol string
+	statusCol   string
+
+	baseConditions  []string // selalu dipakai; boleh memakai token expandFiscalSQL
+	defaultStatus   string   // dipakai bila filter status kosong
+	defaultPeriod   bool     // bila start_date kosong, mulai dari FiscalCalendar.ActiveFrom
+	periodAnyDate   bool     // batas default cukup dipenuhi salah satu dari start/end/created
+	defaultThisYear bool     // fs_year default ke tahun fiskal berjalan
+
+	scope func(f ProposalFilter, args *boundArgs) []string
+}
+
+// parseProposalFilter membaca filter dari query string untuk view tertentu.
+func parseProposalFilter(c *fiber.Ctx, v proposalView, cal FiscalCalendar, now time.Time) (ProposalFilter, error) {
+	f := ProposalFilter{
+		Number:    strings.TrimSpace(c.Query("number")),
+		Brand:     splitQueryMulti(c, "brand"),
+		Group:     splitQueryMulti(c, "group"),
+		Activity:  splitQueryMulti(c, "activity"),
+		Status:    splitQueryMulti(c, "status"),
+		StartDate: strings.TrimSpace(c.Query("start_date")),
+		EndDate:   strings.TrimSpace(c.Query("end_date")),
+	}
+	if v.userParam != "" {
+		f.UserCode = strings.TrimSpace(c.Query(v.userParam))
+	}
+	for _, d := range []struct{ name, value string }{{"start_date", f.StartDate}, {"end_date", f.EndDate}} {
+		if d.value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d.value); err != nil {
+			return f, fmt.Errorf("invalid %s, expected YYYY-MM-DD", d.name)
+		}
+	}
+	var err error
+	if f.FsYear, err = cal.resolveFsYear(c.Query("fs_year"), now); err != nil {
+		return f, err
+	}
+	if f.FsYear == 0 && v.defaultThisYear {
+		f.FsYear = cal.Current(now).Year
+	}
+	if s := c.Query("skp"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < proposalSkpNone || n > proposalSkpCanceled {
+			return f, fmt.Errorf("invalid skp, expected 0-3")
+		}
+		f.SKP = &n
+	}
+	return f, nil
+}
+
+// splitQueryMulti menggabungkan nilai berulang dan nilai dipisah koma.
+func splitQueryMulti(c *fiber.Ctx, key string) []string {
+	var out []string
+	for _, raw := range getQueryMulti(c, key) {
+		for _, v := range strings.Split(raw, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// bindIn mengembalikan "col IN (@pN,...)" untuk daftar nilai.
+func bindIn(args *boundArgs, column string, values []string) string {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		placeholders[i] = args.bind(v)
+	}
+	return fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ","))
+}
+
+// buildProposalQuery menyusun query view dengan filter f. Default periode dan
+// fs_year mengikuti kalender fiskal.
+func buildProposalQuery(v proposalView, f ProposalFilter, cal FiscalCalendar, now time.Time) (string, []interface{}) {
+	var args boundArgs
+	conditions := append([]string{}, v.baseConditions...)
+	if v.scope != nil {
+		conditions = append(conditions, v.scope(f, &args)...)
+	}
+
+	if f.Number != "" {
+		if v.numberLike {
+			conditions = append(conditions, "t1.Number LIKE "+args.bind("%"+f.Number+"%"))
+		} else {
+			conditions = append(conditions, "t1.Number = "+args.bind(f.Number))
+		}
+	}
+	if len(f.Brand) > 0 {
+		conditions = append(conditions, bindIn(&args, "t1.BrandCode", f.Brand))
+	}
+	if len(f.Group) > 0 {
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (
+			SELECT 1 FROM tb_proposal_customer gx
+			INNER JOIN m_group gm ON gm.GroupCode = gx.GroupCustomer
+			WHERE gx.ProposalNumber = t1.Number AND %s
+		)`, bindIn(&args, "gm.GroupCode", f.Group)))
+	}
+	if len(f.Activity) > 0 {
+		conditions = append(conditions, bindIn(&args, v.activityCol, f.Activity))
+	}
+	if len(f.Status) > 0 {
+		upper := make([]string, len(f.Status))
+		for i, s := range f.Status {
+			upper[i] = strings.ToUpper(s)
+		}
+		conditions = append(conditions, bindIn(&args, "UPPER("+v.statusCol+")", upper))
+	} else if v.defaultStatus != "" {
+		conditions = append(conditions, v.defaultStatus)
+	}
+
+	switch {
+	case f.StartDate != "":
+		conditions = append(conditions, "CAST(t1.StartDatePeriode AS DATE) >= CONVERT(DATE, "+args.bind(f.StartDate)+")")
+	case v.defaultPeriod && v.periodAnyDate:
+		p := args.bind(cal.ActiveFrom(now).Format("2006-01-02"))
+		conditions = append(conditions, fmt.Sprintf("(CAST(t1.StartDatePeriode AS DATE) >= CONVERT(DATE, %[1]s) OR CAST(t1.EndDatePeriode AS DATE) >= CONVERT(DATE, %[1]s) OR CAST(t1.CreatedDate AS DATE) >= CONVERT(DATE, %[1]s))", p))
+	case v.defaultPeriod:
+		conditions = append(conditions, "CAST(t1.StartDatePeriode AS DATE) >= CONVERT(DATE, "+args.bind(cal.ActiveFrom(now).Format("2006-01-02"))+")")
+	}
+	if f.EndDate != "" {
+		conditions = append(conditions, "CAST(t1.EndDatePeriode AS DATE) <= CONVERT(DATE, "+args.bind(f.EndDate)+")")
+	}
+	if f.FsYear > 0 {
+		fy := cal.Year(f.FsYear)
+		conditions = append(conditions, fmt.Sprintf("t1.StartDatePeriode >= CONVERT(DATE, %s) AND t1.StartDatePeriode < CONVERT(DATE, %s)",
+			args.bind(fy.Start.Format("2006-01-02")), args.bind(fy.Until().Format("2006-01-02"))))
+	}
+	if f.SKP != nil {
+		conditions = append(conditions, proposalSkpCondition(*f.SKP))
+	}
+
+	query := v.selectSQL
+	if len(conditions) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(conditions, "\n\t\t\tAND ")
+	}
+	if v.orderBy != "" {
+		query += "\n\t\tORDER BY " + v.orderBy
+	}
+	return cal.expandFiscalSQLFor(query, now, f.FsYear), args
+}
+
+func proposalSkpCondition(state int) string {
+	switch state {
+	case proposalSkpNone:
+		return "NOT EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t1.Number)"
+	case proposalSkpPending:
+		return "EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t1.Number AND (t_skp.status_skp = '' OR t_skp.status_skp IS NULL))"
+	case proposalSkpApproved:
+		return "EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t1.Number AND t_skp.status_skp = 'approve')"
+	default:
+		return "EXISTS (SELECT 1 FROM tb_proposal_skp t_skp WHERE t_skp.ProposalNumber = t1.Number AND t_skp.status_skp = 'canceled')"
+	}
+}
+
+// proposalViewHandler adalah handler daftar proposal untuk satu view; opts
+// diteruskan ke GenericQueryHandler (mis. QueryResultHook).
+func proposalViewHandler(c *fiber.Ctx, v proposalView, opts ...interface{}) error {
+	cal, now := loadFiscalCalendar(), time.Now()
+	f, err := parseProposalFilter(c, v, cal, now)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: err.Error()})
+	}
+	query, args := buildProposalQuery(v, f, cal, now)
+	return GenericQueryHandler(c, query, append([]interface{}{boundArgs(args)}, opts...)...)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var testFiscalCalendar = FiscalCalendar{StartMonth: time.January, ClosingDays: 31, HistoryYears: 2, loc: time.UTC}
+
+var testFiscalNow = time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func TestBuildProposalQueryFilters(t *testing.T) {
+	skp := proposalSkpApproved
+	f := ProposalFilter{
+		Number:    "P01",
+		Brand:     []string{"B1", "B2"},
+		Group:     []string{"G1"},
+		Activity:  []string{"7"},
+		Status:    []string{"approved"},
+		StartDate: "2025-02-01",
+		EndDate:   "2025-03-31",
+		FsYear:    2025,
+		SKP:       &skp,
+		UserCode:  "U1",
+	}
+	query, args := buildProposalQuery(picProposalView, f, testFiscalCalendar, testFiscalNow)
+
+	want := []interface{}{"U1", "%P01%", "B1", "B2", "G1", "7", "APPROVED", "2025-02-01", "2025-03-31", "2025-01-01", "2026-01-01"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+	for _, frag := range []string{
+		"UserCode = @p1", "t1.Number LIKE @p2", "t1.BrandCode IN (@p3,@p4)", "gm.GroupCode IN (@p5)",
+		"t2.ActivityCode IN (@p6)", "UPPER(t2.status_proposal) IN (@p7)",
+		"CAST(t1.StartDatePeriode AS DATE) >= CONVERT(DATE, @p8)", "CAST(t1.EndDatePeriode AS DATE) <= CONVERT(DATE, @p9)",
+		"t1.StartDatePeriode >= CONVERT(DATE, @p10) AND t1.StartDatePeriode < CONVERT(DATE, @p11)",
+		"t_skp.status_skp = 'approve'", "ORDER BY t1.id DESC",
+		"tx0.tglbyr >= '2023-01-01'", "YEAR(tx0.tglbyr) = YEAR(t1.StartDatePeriode)",
+	} {
+		if !strings.Contains(query, frag) {
+			t.Errorf("query missing %q", frag)
+		}
+	}
+	if strings.Contains(query, "!= 'canceled'") {
+		t.Error("default status condition must not apply when status is filtered")
+	}
+}
+
+func TestBuildProposalQueryViewDefaults(t *testing.T) {
+	query, args := buildProposalQuery(directorProposalView, ProposalFilter{Number: "P01"}, testFiscalCalendar, testFiscalNow)
+	if !strings.Contains(query, "t1.Number = @p1") || !strings.Contains(query, "t1.[Status] != ''") {
+		t.Errorf("director query missing exact number or base condition:\n%s", query)
+	}
+	if len(args) != 2 || args[1] != "2025-01-01" {
+		t.Errorf("director args = %v", args)
+	}
+
+	query, args = buildProposalQuery(kamProposalView, ProposalFilter{UserCode: "KA019"}, testFiscalCalendar, testFiscalNow)
+	if strings.Contains(query, "t7.user_code") {
+		t.Error("KAM see-all user must not be scoped to own customers")
+	}
+	if !strings.Contains(query, "OR CAST(t1.CreatedDate AS DATE) >= CONVERT(DATE, @p1)") || len(args) != 1 || args[0] != "2025-01-01" {
+		t.Errorf("KAM default period not applied: %v", args)
+	}
+	if !strings.Contains(query, "t1.StartDatePeriode >= '2023-01-01'") || strings.Contains(query, "FISCAL_") {
+		t.Error("KAM history bound not expanded from fiscal calendar")
+	}
+
+	query, args = buildProposalQuery(kamProposalView, ProposalFilter{UserCode: "KA032"}, testFiscalCalendar, testFiscalNow)
+	if !strings.Contains(query, "(t7.user_code = @p1 OR t6.rsm = @p2)") || args[1] != "Suyanto" {
+		t.Errorf("KAM RSM scope wrong: %v", args)
+	}
+}
+
+func TestProposalViewStatusAllStaysBound(t *testing.T) {
+	t.Setenv(adminTokenEnv, "rahasia")
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	app := fiber.New()
+	app.Get("/q", func(c *fiber.Ctx) error { return proposalViewHandler(c, directorProposalView) })
+
+	req := httptest.NewRequest("GET", "/q?status=all&explain=1", nil)
+	req.Header.Set("X-Admin-Token", "rahasia")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out GenericQueryExplain
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	_, args := buildProposalQuery(directorProposalView, ProposalFilter{Status: []string{"all"}}, loadFiscalCalendar(), time.Now())
+	if len(out.Params) < len(args) {
+		t.Fatalf("params = %v, want bound args %v first", out.Params, args)
+	}
+	for i, a := range args {
+		if want := fmt.Sprintf("%T: %v", a, a); out.Params[i] != want {
+			t.Errorf("@p%d = %q, want %q", i+1, out.Params[i], want)
+		}
+	}
+	if out.CacheBehavior != CacheBehaviorAll {
+		t.Errorf("cache behavior = %q", out.CacheBehavior)
+	}
+}
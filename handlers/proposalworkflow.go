@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+// Status workflow proposal. Approval bertingkat memakai status
+// "approved_l<n>" sampai level terakhir, lalu "approved".
+const (
+	proposalStatusDraft     = "draft"
+	proposalStatusSubmitted = "submitted"
+	proposalStatusApproved  = "approved"
+	proposalStatusRejected  = "rejected"
+	proposalStatusCanceled  = "canceled"
+	proposalStatusClosed    = "closed"
+
+	proposalApprovedLevelPrefix = "approved_l"
+)
+
+// Aksi workflow yang bisa dikirim ke POST /anp/proposal/:number/workflow.
+const (
+	workflowSubmit  = "submit"
+	workflowApprove = "approve"
+	workflowReject  = "reject"
+	workflowRevise  = "revise"
+	workflowCancel  = "cancel"
+	workflowClose   = "close"
+)
+
+const (
+	proposalApprovalLevelsEnv     = "PROPOSAL_APPROVAL_LEVELS"
+	defaultProposalApprovalLevels = 2
+
+	proposalApproverTable        = "dbo.tb_proposal_approver"
+	proposalWorkflowHistoryTable = "dbo.tb_proposal_workflow_history"
+)
+
+var (
+	errWorkflowTransition = errors.New("transition not allowed")
+	errWorkflowForbidden  = errors.New("not allowed for this user")
+
+	proposalWorkflowTables tableSetup
+)
+
+// workflowActor adalah peran user terhadap satu proposal.
+type workflowActor struct {
+	User           string
+	IsCreator      bool
+	IsPIC          bool // PIC brand di tb_pic_brand
+	IsAdmin        bool
+	ApproverLevels map[int]bool
+}
+
+// workflowTransition adalah hasil satu aksi workflow.
+type workflowTransition struct {
+	From  string
+	To    string
+	Level int // level approval yang diberikan atau ditolak; 0 bila bukan approval
+}
+
+// ProposalWorkflowEvent adalah satu baris riwayat workflow.
+type ProposalWorkflowEvent struct {
+	ID         int64     `json:"id"`
+	Action     string    `json:"action"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Level      int       `json:"level,omitempty"`
+	Username   string    `json:"username"`
+	Comment    string    `json:"comment,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// proposalApprovalLevels adalah jumlah level approval sebelum status "approved".
+func proposalApprovalLevels() int {
+	n := envInt(proposalApprovalLevelsEnv, defaultProposalApprovalLevels)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// normalizeProposalStatus menyeragamkan status lama (huruf besar, kosong = draft).
+func normalizeProposalStatus(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return proposalStatusDraft
+	}
+	return s
+}
+
+// approvedLevel mengembalikan level approval yang sudah dicapai status.
+func approvedLevel(status string, levels int) (int, bool) {
+	if status == proposalStatusApproved {
+		return levels, true
+	}
+	if strings.HasPrefix(status, proposalApprovedLevelPrefix) {
+		n, err := strconv.Atoi(strings.TrimPrefix(status, proposalApprovedLevelPrefix))
+		if err == nil && n >= 1 && n < levels {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// proposalEditable menentukan apakah isi proposal masih boleh diubah.
+func proposalEditable(status string) bool {
+	switch normalizeProposalStatus(status) {
+	case proposalStatusSubmitted, proposalStatusApproved, proposalStatusCanceled, proposalStatusClosed:
+		return false
+	}
+	return !strings.HasPrefix(normalizeProposalStatus(status), proposalApprovedLevelPrefix)
+}
+
+// nextProposalState menjalankan state machine workflow proposal:
+//
+//	draft     --submit-->  submitted
+//	submitted --approve--> approved_l1 ... approved_l<n-1> --approve--> approved
+//	submitted/approved_l* --reject--> rejected --revise--> draft
+//	draft/submitted/approved_l*/rejected --cancel--> canceled (approved: admin)
+//	approved  --close-->   closed
+//
+// Pemeriksaan peran juga dilakukan di sini agar aturan transisi ada di satu tempat.
+func nextProposalState(current, action string, actor workflowActor, levels int) (workflowTransition, error) {
+	from := normalizeProposalStatus(current)
+	t := workflowTransition{From: from}
+	owner := actor.IsCreator || actor.IsPIC || actor.IsAdmin
+	done, inApproval := approvedLevel(from, levels)
+	inApproval = inApproval && from != proposalStatusApproved
+	pending := from == proposalStatusSubmitted || inApproval
+
+	switch action {
+	case workflowSubmit:
+		if from != proposalStatusDraft {
+			return t, fmt.Errorf("%w: submit from %s", errWorkflowTransition, from)
+		}
+		if !owner {
+			return t, fmt.Errorf("%w: only the creator or brand PIC can submit", errWorkflowForbidden)
+		}
+		t.To = proposalStatusSubmitted
+
+	case workflowApprove, workflowReject:
+		if !pending {
+			return t, fmt.Errorf("%w: %s from %s", errWorkflowTransition, action, from)
+		}
+		t.Level = done + 1
+		if !actor.ApproverLevels[t.Level] && !actor.IsAdmin {
+			return t, fmt.Errorf("%w: not an approver for level %d", errWorkflowForbidden, t.Level)
+		}
+		switch {
+		case action == workflowReject:
+			t.To = proposalStatusRejected
+		case t.Level >= levels:
+			t.To = proposalStatusApproved
+		default:
+			t.To = fmt.Sprintf("%s%d", proposalApprovedLevelPrefix, t.Level)
+		}
+
+	case workflowRevise:
+		if from != proposalStatusRejected {
+			return t, fmt.Errorf("%w: revise from %s", errWorkflowTransition, from)
+		}
+		if !owner {
+			return t, fmt.Errorf("%w: only the creator or brand PIC can revise", errWorkflowForbidden)
+		}
+		t.To = proposalStatusDraft
+
+	case workflowCancel:
+		switch {
+		case from == proposalStatusApproved:
+			if !actor.IsAdmin {
+				return t, fmt.Errorf("%w: approved proposals can only be canceled by an admin", errWorkflowForbidden)
+			}
+		case from == proposalStatusDraft || from == proposalStatusRejected || pending:
+			if !owner {
+				return t, fmt.Errorf("%w: only the creator or brand PIC can cancel", errWorkflowForbidden)
+			}
+		default:
+			return t, fmt.Errorf("%w: cancel from %s", errWorkflowTransition, from)
+		}
+		t.To = proposalStatusCanceled
+
+	case workflowClose:
+		if from != proposalStatusApproved {
+			return t, fmt.Errorf("%w: close from %s", errWorkflowTransition, from)
+		}
+		if !owner {
+			return t, fmt.Errorf("%w: only the creator or brand PIC can close", errWorkflowForbidden)
+		}
+		t.To = proposalStatusClosed
+
+	default:
+		return t, fmt.Errorf("%w: unknown action %q", errWorkflowTransition, action)
+	}
+	return t, nil
+}
+
+// ensureProposalWorkflowTables membuat tabel approver dan riwayat workflow bila belum ada.
+func ensureProposalWorkflowTables(database *sql.DB) error {
+	return proposalWorkflowTables.ensure(func() error {
+		_, err := database.Exec(fmt.Sprintf(`
+			IF OBJECT_ID('%[1]s', 'U') IS NULL
+			CREATE TABLE %[1]s (
+				id INT IDENTITY(1,1) PRIMARY KEY,
+				approval_level INT NOT NULL,
+				username NVARCHAR(50) NOT NULL,
+				wa_number NVARCHAR(30) NULL,
+				brand_code NVARCHAR(50) NULL, -- NULL = semua brand
+				active BIT NOT NULL DEFAULT 1
+			);
+			IF OBJECT_ID('%[2]s', 'U') IS NULL
+			CREATE TABLE %[2]s (
+				id BIGINT IDENTITY(1,1) PRIMARY KEY,
+				proposal_number NVARCHAR(50) NOT NULL,
+				action NVARCHAR(20) NOT NULL,
+				from_status NVARCHAR(30) NULL,
+				to_status NVARCHAR(30) NOT NULL,
+				approval_level INT NULL,
+				username NVARCHAR(50) NOT NULL,
+				comment NVARCHAR(1000) NULL,
+				created_at DATETIME NOT NULL DEFAULT GETDATE(),
+				INDEX IX_tb_proposal_workflow_history_number (proposal_number, id)
+			);`, proposalApproverTable, proposalWorkflowHistoryTable))
+		return err
+	})
+}
+
+// ProposalWorkflowHandler menjalankan satu aksi workflow
+// (submit, approve, reject, revise, cancel, close) pada proposal.
+// Body: {"action": "...", "comment": "..."}; komentar wajib untuk reject dan cancel.
+func ProposalWorkflowHandler(c *fiber.Ctx) error {
+	number, err := url.PathUnescape(c.Params("number"))
+	number = strings.TrimSpace(number)
+	if err != nil || number == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid proposal number."})
+	}
+	// Peran (creator, PIC, approver) ditentukan dari user token, bukan
+	// X-User-Code; admin boleh bertindak atas nama user lewat header.
+	isAdmin := isAdminRequest(c)
+	user := authenticatedUser(c)
+	if user == "" && isAdmin {
+		user = requestUser(c)
+	}
+	if user == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(Response{Success: false, Message: "Login required (X-User-Token)."})
+	}
+
+	var body struct {
+		Action  string `json:"action"`
+		Comment string `json:"comment"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid request body."})
+	}
+	action := strings.ToLower(strings.TrimSpace(body.Action))
+	comment := strings.TrimSpace(body.Comment)
+	if (action == workflowReject || action == workflowCancel) && comment == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "A comment is required to " + action + "."})
+	}
+
+	database := db.GetDB()
+	if err := ensureProposalWorkflowTables(database); err != nil {
+		log.Printf("Error preparing workflow tables: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Workflow is not available."})
+	}
+
+	src := auditSourceFromCtx(c)
+	t, brand, err := runProposalWorkflow(src, database, number, action, comment, user, isAdmin)
+	switch {
+	case errors.Is(err, errProposalNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: fmt.Sprintf("Proposal %s not found.", number)})
+	case errors.Is(err, errWorkflowForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(Response{Success: false, Message: err.Error()})
+	case errors.Is(err, errWorkflowTransition):
+		return c.Status(fiber.StatusConflict).JSON(Response{Success: false, Message: err.Error()})
+	case err != nil:
+		log.Printf("Error running workflow %s on proposal %s by %s: %v", action, number, user, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to update proposal status."})
+	}
+
+	if t.To == proposalStatusSubmitted || strings.HasPrefix(t.To, proposalApprovedLevelPrefix) {
+		go notifyNextApprovers(src, database, number, brand, t, user)
+	}
+	return c.JSON(fiber.Map{
+		"success":     true,
+		"message":     fmt.Sprintf("Proposal %s: %s -> %s", number, t.From, t.To),
+		"number":      number,
+		"from_status": t.From,
+		"status":      t.To,
+		"level":       t.Level,
+	})
+}
+
+// runProposalWorkflow mengunci proposal, memeriksa transisi dan peran, lalu
+// menyimpan status baru, riwayat, dan (untuk approval final) baris tb_proposal_approved
+// dalam satu transaksi.
+func runProposalWorkflow(src sqlAuditSource, database *sql.DB, number, action, comment, user string, isAdmin bool) (workflowTransition, string, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return workflowTransition{}, "", fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var status, createdBy, brand sql.NullString
+	found := false
+	err = auditedQuery(src, tx, `
+		SELECT t2.status_proposal, t1.CreatedBy, t1.BrandCode
+		FROM tb_proposal t1 WITH (UPDLOCK, HOLDLOCK)
+		INNER JOIN tb_operating_proposal t2 WITH (UPDLOCK, HOLDLOCK) ON t2.ProposalNumber = t1.[Number]
+		WHERE t1.[Number] = @p1`, []interface{}{number}, func(rows *sql.Rows) error {
+		found = true
+		return rows.Scan(&status, &createdBy, &brand)
+	})
+	if err != nil {
+		return workflowTransition{}, "", fmt.Errorf("load proposal: %w", err)
+	}
+	if !found {
+		return workflowTransition{}, "", errProposalNotFound
+	}
+
+	actor := workflowActor{
+		User:           user,
+		IsCreator:      strings.EqualFold(strings.TrimSpace(createdBy.String), user),
+		IsAdmin:        isAdmin,
+		ApproverLevels: map[int]bool{},
+	}
+	err = auditedQuery(src, tx, fmt.Sprintf(`
+		SELECT CAST(1 AS INT), 0 FROM tb_pic_brand WHERE BrandCode = @p1 AND UserCode = @p2
+		UNION ALL
+		SELECT CAST(0 AS INT), approval_level FROM %s
+		WHERE active = 1 AND username = @p2 AND (brand_code IS NULL OR brand_code = @p1)`, proposalApproverTable),
+		[]interface{}{brand.String, user}, func(rows *sql.Rows) error {
+			var isPIC, level int
+			if err := rows.Scan(&isPIC, &level); err != nil {
+				return err
+			}
+			if isPIC == 1 {
+				actor.IsPIC = true
+			} else {
+				actor.ApproverLevels[level] = true
+			}
+			return nil
+		})
+	if err != nil {
+		return workflowTransition{}, "", fmt.Errorf("load roles: %w", err)
+	}
+
+	t, err := nextProposalState(status.String, action, actor, proposalApprovalLevels())
+	if err != nil {
+		return t, brand.String, err
+	}
+
+	if _, err := auditedTxExec(src, tx, `UPDATE tb_operating_proposal SET status_proposal = @p2 WHERE ProposalNumber = @p1`, number, t.To); err != nil {
+		return t, brand.String, fmt.Errorf("update tb_operating_proposal: %w", err)
+	}
+	if _, err := auditedTxExec(src, tx, `UPDATE tb_proposal SET [Status] = @p2 WHERE [Number] = @p1`, number, t.To); err != nil {
+		return t, brand.String, fmt.Errorf("update tb_proposal: %w", err)
+	}
+	// tb_proposal_approved hanya mencatat approval final; approval antar level
+	// cukup di riwayat workflow.
+	if t.To == proposalStatusApproved {
+		_, err := auditedTxExec(src, tx, `
+			INSERT INTO tb_proposal_approved (ProposalNumber, username, created_at, approvedDate)
+			VALUES (@p1, @p2, GETDATE(), GETDATE())`, number, user)
+		if err != nil {
+			return t, brand.String, fmt.Errorf("insert tb_proposal_approved: %w", err)
+		}
+	}
+
+	var level, commentParam interface{}
+	if t.Level > 0 {
+		level = t.Level
+	}
+	if comment != "" {
+		commentParam = comment
+	}
+	_, err = auditedTxExec(src, tx, fmt.Sprintf(`
+		INSERT INTO %s (proposal_number, action, from_status, to_status, approval_level, username, comment)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7)`, proposalWorkflowHistoryTable),
+		number, action, t.From, t.To, level, user, commentParam)
+	if err != nil {
+		return t, brand.String, fmt.Errorf("insert workflow history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return t, brand.String, fmt.Errorf("commit: %w", err)
+	}
+	return t, brand.String, nil
+}
+
+// notifyNextApprovers mengirim WhatsApp ke approver level berikutnya.
+func notifyNextApprovers(src sqlAuditSource, database *sql.DB, number, brand string, t workflowTransition, actor string) {
+	next := t.Level + 1
+	if t.To == proposalStatusSubmitted {
+		next = 1
+	}
+
+	type approver struct{ user, wa string }
+	var approvers []approver
+	err := auditedQuery(src, database, fmt.Sprintf(`
+		SELECT username, wa_number FROM %s
+		WHERE active = 1 AND approval_level = @p1 AND (brand_code IS NULL OR brand_code = @p2)
+			AND wa_number IS NOT NULL AND wa_number != ''`, proposalApproverTable),
+		[]interface{}{next, brand}, func(rows *sql.Rows) error {
+			var a approver
+			if err := rows.Scan(&a.user, &a.wa); err != nil {
+				return err
+			}
+			approvers = append(approvers, a)
+			return nil
+		})
+	if err != nil {
+		log.Printf("Workflow: failed to load level %d approvers for proposal %s: %v", next, number, err)
+		return
+	}
+	if len(approvers) == 0 {
+		log.Printf("Workflow: no level %d approver with a WhatsApp number for proposal %s (brand %s)", next, number, brand)
+		return
+	}
+
+	msg := fmt.Sprintf("*Approval Proposal ANP*\nProposal %s menunggu approval level %d.\nStatus: %s (oleh %s)\n\nMohon ditinjau.", number, next, t.To, actor)
+	for _, a := range approvers {
+		if _, err := sendToWhatsAppAPI(a.wa, msg, ""); err != nil {
+			log.Printf("Workflow: failed to notify %s (%s) for proposal %s: %v", a.user, a.wa, number, err)
+		}
+	}
+}
+
+// ProposalHistoryHandler mengembalikan riwayat workflow proposal.
+func ProposalHistoryHandler(c *fiber.Ctx) error {
+	number, err := url.PathUnescape(c.Params("number"))
+	number = strings.TrimSpace(number)
+	if err != nil || number == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid proposal number."})
+	}
+	database := db.GetDB()
+	if err := ensureProposalWorkflowTables(database); err != nil {
+		log.Printf("Error preparing workflow tables: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Workflow is not available."})
+	}
+
+	history, err := loadProposalHistory(auditSourceFromCtx(c), database, number)
+	if err != nil {
+		log.Printf("Error loading workflow history of %s: %v", number, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to retrieve history."})
+	}
+	return c.JSON(fiber.Map{"success": true, "number": number, "history": history})
+}
+
+func loadProposalHistory(src sqlAuditSource, database *sql.DB, number string) ([]ProposalWorkflowEvent, error) {
+	history := []ProposalWorkflowEvent{}
+	err := auditedQuery(src, database, fmt.Sprintf(`
+		SELECT id, action, ISNULL(from_status, ''), to_status, ISNULL(approval_level, 0), username, ISNULL(comment, ''), created_at
+		FROM %s WHERE proposal_number = @p1 ORDER BY id`, proposalWorkflowHistoryTable),
+		[]interface{}{number}, func(rows *sql.Rows) error {
+			var e ProposalWorkflowEvent
+			if err := rows.Scan(&e.ID, &e.Action, &e.FromStatus, &e.ToStatus, &e.Level, &e.Username, &e.Comment, &e.CreatedAt); err != nil {
+				return err
+			}
+			history = append(history, e)
+			return nil
+		})
+	return history, err
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNextProposalState(t *testing.T) {
+	creator := workflowActor{User: "pic1", IsCreator: true, ApproverLevels: map[int]bool{}}
+	l1 := workflowActor{User: "spv", ApproverLevels: map[int]bool{1: true}}
+	l2 := workflowActor{User: "dir", ApproverLevels: map[int]bool{2: true}}
+	admin := workflowActor{User: "admin", IsAdmin: true, ApproverLevels: map[int]bool{}}
+
+	cases := []struct {
+		from, action string
+		actor        workflowActor
+		to           string
+		level        int
+		err          error
+	}{
+		{"", workflowSubmit, creator, proposalStatusSubmitted, 0, nil},
+		{"DRAFT", workflowSubmit, l1, "", 0, errWorkflowForbidden},
+		{"submitted", workflowApprove, l1, "approved_l1", 1, nil},
+		{"submitted", workflowApprove, l2, "", 0, errWorkflowForbidden},
+		{"approved_l1", workflowApprove, l2, proposalStatusApproved, 2, nil},
+		{"approved_l1", workflowReject, l2, proposalStatusRejected, 2, nil},
+		{"approved", workflowApprove, l2, "", 0, errWorkflowTransition},
+		{"rejected", workflowRevise, creator, proposalStatusDraft, 0, nil},
+		{"rejected", workflowSubmit, creator, "", 0, errWorkflowTransition},
+		{"submitted", workflowCancel, creator, proposalStatusCanceled, 0, nil},
+		{"approved", workflowCancel, creator, "", 0, errWorkflowForbidden},
+		{"approved", workflowCancel, admin, proposalStatusCanceled, 0, nil},
+		{"approved", workflowClose, creator, proposalStatusClosed, 0, nil},
+		{"closed", workflowCancel, admin, "", 0, errWorkflowTransition},
+		{"draft", "publish", creator, "", 0, errWorkflowTransition},
+	}
+	for _, tc := range cases {
+		got, err := nextProposalState(tc.from, tc.action, tc.actor, 2)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("%s --%s--> by %s: err = %v, want %v", tc.from, tc.action, tc.actor.User, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got.To != tc.to || got.Level != tc.level {
+			t.Errorf("%s --%s--> by %s = %+v, %v; want %s level %d", tc.from, tc.action, tc.actor.User, got, err, tc.to, tc.level)
+		}
+	}
+}
+
+func TestProposalEditable(t *testing.T) {
+	for status, want := range map[string]bool{
+		"": true, "DRAFT": true, "rejected": true,
+		"submitted": false, "approved_l1": false, "Approved": false, "canceled": false, "closed": false,
+	} {
+		if got := proposalEditable(status); got != want {
+			t.Errorf("proposalEditable(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+const (
+	budgetTypeOnTop   = "on_top"
+	budgetTypeRegular = "regular"
+
+	// proposalLockTimeoutMs adalah batas tunggu sp_getapplock.
+	proposalLockTimeoutMs = 10000
+)
+
+var (
+	errProposalInvalid    = errors.New("invalid proposal")
+	errProposalLocked     = errors.New("proposal can no longer be changed")
+	errProposalForbidden  = errors.New("only the creator or a PIC of the brand can change this proposal")
+	errProposalNoCode     = errors.New("user has no proposal number code yet")
+	errBudgetInsufficient = errors.New("insufficient budget")
+)
+
+// ProposalCustomerInput adalah satu customer proposal (tb_proposal_customer).
+type ProposalCustomerInput struct {
+	CustomerCode  string `json:"customer_code"`
+	GroupCustomer string `json:"group_customer"`
+}
+
+// ProposalInput adalah body POST /anp/proposal dan PUT /anp/proposal/:number.
+type ProposalInput struct {
+	NoRef        string                  `json:"noref"`
+	BrandCode    string                  `json:"brand_code"`
+	BudgetCode   string                  `json:"budget_code"`
+	BudgetType   string                  `json:"budget_type"`
+	ActivityCode int                     `json:"activity_code"`
+	StartDate    string                  `json:"start_date"`
+	EndDate      string                  `json:"end_date"`
+	ClaimTo      string                  `json:"claim_to"`
+	Klaimable    string                  `json:"klaimable"`
+	Costing      float64                 `json:"costing"`
+	Customers    []ProposalCustomerInput `json:"customers"`
+
+	start, end time.Time
+	fiscal     FiscalYear
+}
+
+// BudgetCheck adalah hasil pengecekan sisa budget saat menyimpan proposal.
+type BudgetCheck struct {
+	BudgetType string  `json:"budget_type"`
+	BudgetCode string  `json:"budget_code"`
+	Awal       float64 `json:"awal"`
+	Terpakai   float64 `json:"terpakai"`
+	Sisa       float64 `json:"sisa"`
+	Costing    float64 `json:"costing"`
+	SisaAfter  float64 `json:"sisa_after"`
+}
+
+// ProposalWriteResponse adalah respons create/update proposal.
+type ProposalWriteResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Number  string       `json:"number,omitempty"`
+	Budget  *BudgetCheck `json:"budget,omitempty"`
+}
+
+// CreateProposalHandler membuat proposal baru (tb_proposal, tb_operating_proposal
+// dan tb_proposal_customer) setelah validasi periode, costing dan sisa budget.
+func CreateProposalHandler(c *fiber.Ctx) error {
+	return saveProposalHandler(c, "")
+}
+
+// UpdateProposalHandler mengubah proposal yang masih draft/ditolak.
+func UpdateProposalHandler(c *fiber.Ctx) error {
+	number, err := url.PathUnescape(c.Params("number"))
+	number = strings.TrimSpace(number)
+	if err != nil || number == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid proposal number."})
+	}
+	return saveProposalHandler(c, number)
+}
+
+func saveProposalHandler(c *fiber.Ctx, number string) error {
+	// Identitas harus dari token login; X-User-Code hanya dipercaya dari admin
+	// yang menyimpan atas nama user.
+	isAdmin := isAdminRequest(c)
+	user := authenticatedUser(c)
+	if user == "" && isAdmin {
+		user = requestUser(c)
+	}
+	if user == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(Response{Success: false, Message: "Login required (X-User-Token)."})
+	}
+
+	var in ProposalInput
+	if err := c.BodyParser(&in); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid request body."})
+	}
+	if err := in.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: err.Error()})
+	}
+
+	src := auditSourceFromCtx(c)
+	var (
+		check *BudgetCheck
+		err   error
+	)
+	if number == "" {
+		number, check, err = createProposal(src, db.GetDB(), user, in)
+	} else {
+		check, err = updateProposal(src, db.GetDB(), number, user, isAdmin, in)
+	}
+
+	switch {
+	case errors.Is(err, errBudgetInsufficient):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(ProposalWriteResponse{
+			Success: false,
+			Message: fmt.Sprintf("Costing %.0f melebihi sisa budget %.0f.", check.Costing, check.Sisa),
+			Number:  number,
+			Budget:  check,
+		})
+	case errors.Is(err, errProposalNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: fmt.Sprintf("Proposal %s not found.", number)})
+	case errors.Is(err, errProposalForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(Response{Success: false, Message: err.Error()})
+	case errors.Is(err, errProposalLocked):
+		return c.Status(fiber.StatusConflict).JSON(Response{Success: false, Message: err.Error()})
+	case errors.Is(err, errProposalNoCode):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(Response{Success: false,
+			Message: fmt.Sprintf("Kode penomoran proposal untuk user %s belum ada.", user)})
+	case err != nil:
+		log.Printf("Error saving proposal %q by %s: %v", number, user, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: "Failed to save proposal."})
+	}
+
+	status := fiber.StatusOK
+	msg := "Proposal updated."
+	if c.Method() == fiber.MethodPost {
+		status, msg = fiber.StatusCreated, "Proposal created."
+	}
+	return c.Status(status).JSON(ProposalWriteResponse{Success: true, Message: msg, Number: number, Budget: check})
+}
+
+// validate merapikan input dan memeriksa field wajib, periode dan costing.
+// Periode harus berada dalam satu tahun fiskal (fs_year = tahun fiskal StartDatePeriode).
+func (in *ProposalInput) validate() error {
+	in.BrandCode = strings.TrimSpace(in.BrandCode)
+	in.BudgetCode = strings.TrimSpace(in.BudgetCode)
+	in.BudgetType = strings.ToLower(strings.TrimSpace(in.BudgetType))
+	if in.BudgetType == "" {
+		in.BudgetType = budgetTypeRegular
+	}
+
+	var problems []string
+	if in.BrandCode == "" {
+		problems = append(problems, "brand_code wajib diisi")
+	}
+	if in.BudgetCode == "" {
+		problems = append(problems, "budget_code wajib diisi")
+	}
+	if in.BudgetType != budgetTypeRegular && in.BudgetType != budgetTypeOnTop {
+		problems = append(problems, fmt.Sprintf("budget_type harus %q atau %q", budgetTypeRegular, budgetTypeOnTop))
+	}
+	if in.ActivityCode <= 0 {
+		problems = append(problems, "activity_code wajib diisi")
+	}
+
+	var err error
+	if in.start, err = time.ParseInLocation("2006-01-02", in.StartDate, time.Local); err != nil {
+		problems = append(problems, "start_date harus YYYY-MM-DD")
+	}
+	if in.end, err = time.ParseInLocation("2006-01-02", in.EndDate, time.Local); err != nil {
+		problems = append(problems, "end_date harus YYYY-MM-DD")
+	}
+	if !in.start.IsZero() && !in.end.IsZero() {
+		cal := loadFiscalCalendar()
+		in.fiscal = cal.YearOf(in.start)
+		if in.end.Before(in.start) {
+			problems = append(problems, "end_date tidak boleh sebelum start_date")
+		} else if cal.YearOf(in.end).Year != in.fiscal.Year {
+			problems = append(problems, "periode harus dalam satu tahun fiskal")
+		}
+	}
+
+	if in.Costing <= 0 {
+		problems = append(problems, "costing harus lebih dari 0")
+	}
+
+	seen := map[string]bool{}
+	customers := in.Customers[:0]
+	for _, cu := range in.Customers {
+		cu.CustomerCode = strings.TrimSpace(cu.CustomerCode)
+		cu.GroupCustomer = strings.TrimSpace(cu.GroupCustomer)
+		if cu.CustomerCode == "" || seen[cu.CustomerCode] {
+			continue
+		}
+		seen[cu.CustomerCode] = true
+		customers = append(customers, cu)
+	}
+	in.Customers = customers
+	if len(in.Customers) == 0 {
+		problems = append(problems, "minimal satu customer")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errProposalInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (in *ProposalInput) fsYear() int { return in.fiscal.Year }
+
+func createProposal(src sqlAuditSource, database *sql.DB, user string, in ProposalInput) (string, *BudgetCheck, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return "", nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockBudget(src, tx, in); err != nil {
+		return "", nil, err
+	}
+	check, err := checkBudget(src, tx, in, "")
+	if err != nil {
+		return "", check, err
+	}
+
+	number, err := allocateProposalNumber(src, tx, user, in.fsYear())
+	if err != nil {
+		return "", check, err
+	}
+
+	_, err = auditedTxExec(src, tx, `
+		INSERT INTO tb_proposal
+			([Number], noref, BrandCode, BudgetCode, Activity, StartDatePeriode, EndDatePeriode, ClaimTo, [Status], CreatedBy, CreatedDate)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10, GETDATE())`,
+		number, in.NoRef, in.BrandCode, in.BudgetCode, in.ActivityCode, in.start, in.end, in.ClaimTo, proposalStatusDraft, user)
+	if err != nil {
+		return "", check, fmt.Errorf("insert tb_proposal: %w", err)
+	}
+
+	// budget_total_tahunan mengikuti proposal sebelumnya dengan budget yang sama,
+	// karena kolom inilah yang dipakai daftar proposal sebagai Total_Budget.
+	_, err = auditedTxExec(src, tx, `
+		INSERT INTO tb_operating_proposal
+			(ProposalNumber, BrandCode, BudgetCode, ActivityCode, Budget_type, fs_year, TotalCosting, klaimable, status_proposal, budget_total_tahunan, CreatedBy)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9,
+			(SELECT TOP 1 budget_total_tahunan FROM tb_operating_proposal
+				WHERE BrandCode = @p2 AND BudgetCode = @p3 AND fs_year = @p6 AND budget_total_tahunan IS NOT NULL
+				ORDER BY ProposalNumber DESC),
+			@p10)`,
+		number, in.BrandCode, in.BudgetCode, in.ActivityCode, in.BudgetType, in.fsYear(), in.Costing, in.Klaimable, proposalStatusDraft, user)
+	if err != nil {
+		return "", check, fmt.Errorf("insert tb_operating_proposal: %w", err)
+	}
+
+	if err := replaceProposalCustomers(src, tx, number, in.Customers); err != nil {
+		return "", check, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", check, fmt.Errorf("commit: %w", err)
+	}
+	return number, check, nil
+}
+
+// updateProposal hanya boleh dilakukan pembuat proposal, PIC brand proposal
+// (tb_pic_brand) atau admin.
+func updateProposal(src sqlAuditSource, database *sql.DB, number, user string, isAdmin bool, in ProposalInput) (*BudgetCheck, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockBudget(src, tx, in); err != nil {
+		return nil, err
+	}
+
+	var (
+		status, createdBy sql.NullString
+		isPIC             int
+	)
+	found := false
+	err = auditedQuery(src, tx, `
+		SELECT t2.status_proposal, t1.CreatedBy,
+			CASE WHEN EXISTS (SELECT 1 FROM tb_pic_brand tbp WHERE tbp.BrandCode = t1.BrandCode AND tbp.UserCode = @p2)
+				THEN 1 ELSE 0 END
+		FROM tb_proposal t1 WITH (UPDLOCK, HOLDLOCK)
+		INNER JOIN tb_operating_proposal t2 WITH (UPDLOCK, HOLDLOCK) ON t2.ProposalNumber = t1.[Number]
+		WHERE t1.[Number] = @p1`, []interface{}{number, user}, func(rows *sql.Rows) error {
+		found = true
+		return rows.Scan(&status, &createdBy, &isPIC)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("load proposal: %w", err)
+	}
+	if !found {
+		return nil, errProposalNotFound
+	}
+	if !isAdmin && isPIC == 0 && !strings.EqualFold(strings.TrimSpace(createdBy.String), user) {
+		return nil, errProposalForbidden
+	}
+	if !proposalEditable(status.String) {
+		return nil, fmt.Errorf("%w: status %s", errProposalLocked, normalizeProposalStatus(status.String))
+	}
+
+	check, err := checkBudget(src, tx, in, number)
+	if err != nil {
+		return check, err
+	}
+
+	_, err = auditedTxExec(src, tx, `
+		UPDATE tb_proposal
+		SET noref = @p2, BrandCode = @p3, BudgetCode = @p4, Activity = @p5,
+			StartDatePeriode = @p6, EndDatePeriode = @p7, ClaimTo = @p8
+		WHERE [Number] = @p1`,
+		number, in.NoRef, in.BrandCode, in.BudgetCode, in.ActivityCode, in.start, in.end, in.ClaimTo)
+	if err != nil {
+		return check, fmt.Errorf("update tb_proposal: %w", err)
+	}
+	_, err = auditedTxExec(src, tx, `
+		UPDATE tb_operating_proposal
+		SET BrandCode = @p2, BudgetCode = @p3, ActivityCode = @p4, Budget_type = @p5,
+			fs_year = @p6, TotalCosting = @p7, klaimable = @p8
+		WHERE ProposalNumber = @p1`,
+		number, in.BrandCode, in.BudgetCode, in.ActivityCode, in.BudgetType, in.fsYear(), in.Costing, in.Klaimable)
+	if err != nil {
+		return check, fmt.Errorf("update tb_operating_proposal: %w", err)
+	}
+
+	if err := replaceProposalCustomers(src, tx, number, in.Customers); err != nil {
+		return check, err
+	}
+	if err := tx.Commit(); err != nil {
+		return check, fmt.Errorf("commit: %w", err)
+	}
+	return check, nil
+}
+
+func replaceProposalCustomers(src sqlAuditSource, tx *sql.Tx, number string, customers []ProposalCustomerInput) error {
+	if _, err := auditedTxExec(src, tx, `DELETE FROM tb_proposal_customer WHERE ProposalNumber = @p1`, number); err != nil {
+		return fmt.Errorf("delete tb_proposal_customer: %w", err)
+	}
+	for _, cu := range customers {
+		_, err := auditedTxExec(src, tx, `
+			INSERT INTO tb_proposal_customer (ProposalNumber, CustomerCode, GroupCustomer)
+			VALUES (@p1, @p2, @p3)`, number, cu.CustomerCode, cu.GroupCustomer)
+		if err != nil {
+			return fmt.Errorf("insert tb_proposal_customer %s: %w", cu.CustomerCode, err)
+		}
+	}
+	return nil
+}
+
+// acquireAppLock mengambil sp_getapplock eksklusif selama transaksi berjalan.
+func acquireAppLock(src sqlAuditSource, tx *sql.Tx, resource string) error {
+	var rc int
+	err := auditedQuery(src, tx, `
+		DECLARE @rc INT;
+		EXEC @rc = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Transaction', @LockTimeout = @p2;
+		SELECT @rc;`, []interface{}{resource, proposalLockTimeoutMs}, func(rows *sql.Rows) error {
+		return rows.Scan(&rc)
+	})
+	if err != nil {
+		return fmt.Errorf("applock %s: %w", resource, err)
+	}
+	if rc < 0 {
+		return fmt.Errorf("applock %s: timeout (rc=%d)", resource, rc)
+	}
+	return nil
+}
+
+// lockBudget mengunci budget code selama transaksi. Create dan update selalu
+// mengambil lock ini lebih dulu, sebelum lock baris atau nomor proposal,
+// supaya urutan lock sama dan keduanya tidak saling deadlock.
+func lockBudget(src sqlAuditSource, tx *sql.Tx, in ProposalInput) error {
+	return acquireAppLock(src, tx, "anp_budget:"+budgetKeyCode(in.BudgetCode))
+}
+
+// checkBudget menghitung sisa budget lewat budget ledger (budget.go) di bawah
+// lockBudget: proposal reguler dicek terhadap budget activity-nya, proposal
+// on_top terhadap budget on_top budget code itu. exclude adalah nomor proposal
+// yang sedang diubah (costing lamanya tidak dihitung).
+func checkBudget(src sqlAuditSource, tx *sql.Tx, in ProposalInput, exclude string) (*BudgetCheck, error) {
+	ledger, err := loadBudgetLedger(src, tx, []string{in.BudgetCode})
+	if err != nil {
+		return nil, fmt.Errorf("check budget: %w", err)
+	}
+
+	var b BudgetBalance
+	if in.BudgetType == budgetTypeOnTop {
+		b, _ = ledger.Pool(budgetTypeOnTop, in.BrandCode, in.BudgetCode)
+	} else {
+		b, _ = ledger.Activity(in.BrandCode, in.BudgetCode, in.ActivityCode)
+	}
+	check := &BudgetCheck{BudgetType: in.BudgetType, BudgetCode: in.BudgetCode, Costing: in.Costing, Awal: b.Initial, Terpakai: b.Committed}
+	if p, ok := ledger.proposals[exclude]; ok && !p.Canceled {
+		samePool := p.BudgetType == in.BudgetType && strings.EqualFold(p.BudgetCode, in.BudgetCode) &&
+			(in.BudgetType == budgetTypeOnTop || (strings.EqualFold(p.BrandCode, in.BrandCode) && p.ActivityCode == in.ActivityCode))
+		if samePool {
+			check.Terpakai -= p.Costing
+		}
+	}
+	check.Sisa = check.Awal - check.Terpakai
+	check.SisaAfter = check.Sisa - check.Costing
+	if check.SisaAfter < 0 {
+		return check, errBudgetInsufficient
+	}
+	return check, nil
+}
+
+// Nomor proposal: "PK" + kode 3 digit user + tahun fiskal 4 digit + urutan
+// (minimal 4 digit), mis. PK00620250034. Urutan dihitung per tahun fiskal
+// untuk semua kode, jadi tahun fiskal baru mulai lagi dari 0001.
+var proposalNumberPattern = regexp.MustCompile(`^(?i)(PK\d{3})(\d{4})(\d{4,})$`)
+
+// proposalNumberCode mengembalikan kode "PKnnn" dari nomor proposal.
+func proposalNumberCode(number string) (string, bool) {
+	m := proposalNumberPattern.FindStringSubmatch(strings.TrimSpace(number))
+	if m == nil {
+		return "", false
+	}
+	return strings.ToUpper(m[1]), true
+}
+
+// formatProposalNumber menyusun nomor proposal dari kode, tahun fiskal dan urutan.
+func formatProposalNumber(code string, fsYear int, seq int64) string {
+	return fmt.Sprintf("%s%04d%04d", code, fsYear, seq)
+}
+
+// allocateProposalNumber mengambil nomor berikutnya di bawah applock, sehingga
+// dua request bersamaan tidak mendapat nomor yang sama; karena itu MAX tidak
+// perlu range lock di tb_proposal. Kode diambil dari proposal terakhir user;
+// lock dilepas saat transaksi commit/rollback.
+func allocateProposalNumber(src sqlAuditSource, tx *sql.Tx, user string, fsYear int) (string, error) {
+	if err := acquireAppLock(src, tx, "anp_proposal_number"); err != nil {
+		return "", err
+	}
+
+	var lastOwn sql.NullString
+	err := auditedQuery(src, tx, `
+		SELECT TOP 1 [Number] FROM tb_proposal
+		WHERE CreatedBy = @p1 AND [Number] LIKE 'PK[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]%'
+		ORDER BY id DESC`, []interface{}{user}, func(rows *sql.Rows) error { return rows.Scan(&lastOwn) })
+	if err != nil {
+		return "", fmt.Errorf("proposal number code: %w", err)
+	}
+	code, ok := proposalNumberCode(lastOwn.String)
+	if !ok {
+		return "", errProposalNoCode
+	}
+
+	var last sql.NullInt64
+	err = auditedQuery(src, tx, `
+		SELECT MAX(CAST(SUBSTRING([Number], 10, 20) AS BIGINT)) FROM tb_proposal
+		WHERE [Number] LIKE 'PK[0-9][0-9][0-9]' + @p1 + '[0-9][0-9][0-9][0-9]%'
+			AND SUBSTRING([Number], 10, 20) NOT LIKE '%[^0-9]%'`,
+		[]interface{}{strconv.Itoa(fsYear)}, func(rows *sql.Rows) error { return rows.Scan(&last) })
+	if err != nil {
+		return "", fmt.Errorf("last proposal number %d: %w", fsYear, err)
+	}
+	return formatProposalNumber(code, fsYear, last.Int64+1), nil
+}
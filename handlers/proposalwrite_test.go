@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestProposalNumberScheme(t *testing.T) {
+	for in, want := range map[string]string{
+		"PK00620240032":  "PK006",
+		"pk008202510055": "PK008",
+		"PK027202510151": "PK027",
+	} {
+		if got, ok := proposalNumberCode(in); !ok || got != want {
+			t.Errorf("proposalNumberCode(%q) = %q, %v; want %q", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "P000001", "PRO/2025/0099", "PK0062024", "PK00620240032/R"} {
+		if got, ok := proposalNumberCode(in); ok {
+			t.Errorf("proposalNumberCode(%q) = %q, want no match", in, got)
+		}
+	}
+
+	// Urutan mulai lagi tiap tahun fiskal dan melebar setelah 9999.
+	if got := formatProposalNumber("PK006", 2026, 1); got != "PK00620260001" {
+		t.Errorf("first number of the year = %q", got)
+	}
+	if got := formatProposalNumber("PK027", 2025, 10552); got != "PK027202510552" {
+		t.Errorf("wide sequence = %q", got)
+	}
+}
+
+func TestProposalInputValidate(t *testing.T) {
+	valid := func() ProposalInput {
+		return ProposalInput{
+			BrandCode:    " B01 ",
+			BudgetCode:   "BGT-B01",
+			ActivityCode: 3,
+			StartDate:    "2025-02-01",
+			EndDate:      "2025-03-31",
+			Costing:      1000000,
+			Customers: []ProposalCustomerInput{
+				{CustomerCode: "C1", GroupCustomer: "G1"},
+				{CustomerCode: "C1", GroupCustomer: "G1"},
+				{CustomerCode: " "},
+			},
+		}
+	}
+
+	in := valid()
+	if err := in.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if in.BrandCode != "B01" || in.BudgetType != budgetTypeRegular || len(in.Customers) != 1 || in.fsYear() != 2025 {
+		t.Errorf("input not normalised: %+v", in)
+	}
+
+	in = valid()
+	in.EndDate = "2026-01-15"
+	if err := in.validate(); err == nil || !strings.Contains(err.Error(), "tahun fiskal") {
+		t.Errorf("expected fiscal year error, got %v", err)
+	}
+
+	in = valid()
+	in.StartDate, in.EndDate = "2025-03-01", "2025-02-01"
+	in.Costing = 0
+	in.BudgetType = "special"
+	err := in.validate()
+	if !errors.Is(err, errProposalInvalid) {
+		t.Fatalf("expected errProposalInvalid, got %v", err)
+	}
+	for _, want := range []string{"end_date", "costing", "budget_type"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/robfig/cron/v3"
+	"my-fiber-app/db"
+)
+
+// proposalWithoutActiveSkp adalah kondisi proposal (alias t1) yang belum punya
+// SKP aktif; dipakai filter noskp AnpApiXLHandler dan reminder SKP.
+const proposalWithoutActiveSkp = `not exists(
+			select * from [appsrv].pk_anp_dev_query.dbo.tb_proposal_skp t1x
+			where (t1x.status_skp != 'canceled' or t1x.status_skp is null) and t1x.proposalnumber = t1.Number
+		)`
+
+// Pengaturan reminder SKP terlambat.
+const (
+	skpReminderCronEnv         = "SKP_REMINDER_CRON"
+	skpReminderDaysEnv         = "SKP_REMINDER_DAYS"
+	skpReminderEscalateDaysEnv = "SKP_REMINDER_ESCALATE_DAYS"
+	skpReminderManagementEnv   = "SKP_REMINDER_MANAGEMENT_WA" // nomor dipisah koma
+
+	defaultSkpReminderCron         = "0 8 * * 1-5" // Senin-Jumat 08:00
+	defaultSkpReminderDays         = 5
+	defaultSkpReminderEscalateDays = 14
+	skpReminderDigestMaxLines      = 30
+
+	// skpReminderApprovedStatuses adalah status_proposal approval final:
+	// "approved" dari workflow dan "approve" dari data lama.
+	skpReminderApprovedStatuses = `'approved', 'approve'`
+)
+
+var skpReminderRunMu sync.Mutex
+
+// OverdueSkp adalah satu proposal yang sudah di-approve tapi belum punya SKP aktif.
+type OverdueSkp struct {
+	Number     string    `json:"number"`
+	BrandCode  string    `json:"brand_code"`
+	Brand      string    `json:"brand"`
+	PicCode    string    `json:"pic_code"`
+	Pic        string    `json:"pic"`
+	WANumber   string    `json:"wa_number,omitempty"`
+	ApprovedAt time.Time `json:"approved_at"`
+	AgeDays    int       `json:"age_days"`
+	Amount     float64   `json:"amount"`
+	Escalated  bool      `json:"escalated"`
+}
+
+// SkpReminderDigest adalah satu pesan yang dikirim (atau akan dikirim pada dry run).
+type SkpReminderDigest struct {
+	To        string       `json:"to"`
+	WANumber  string       `json:"wa_number,omitempty"`
+	Escalated bool         `json:"escalation"`
+	Items     []OverdueSkp `json:"items"`
+	Sent      bool         `json:"sent"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// StartSkpReminderJob menjadwalkan reminder SKP terlambat (default Senin-Jumat
+// 08:00, atur lewat SKP_REMINDER_CRON; "off" untuk mematikan).
+func StartSkpReminderJob() {
+	spec := envString(skpReminderCronEnv, defaultSkpReminderCron)
+	if strings.EqualFold(spec, "off") {
+		log.Println("SKP reminder job disabled.")
+		return
+	}
+	c := cron.New()
+	_, err := c.AddFunc(spec, func() {
+		digests, err := RunSkpReminder(false)
+		if err != nil {
+			log.Printf("SKP reminder failed: %v", err)
+			return
+		}
+		log.Printf("SKP reminder finished, %d digest(s)", len(digests))
+	})
+	if err != nil {
+		log.Printf("Error scheduling SKP reminder job (%q): %v", spec, err)
+		return
+	}
+	c.Start()
+	log.Printf("SKP reminder job scheduled (%s)", spec)
+}
+
+// RunSkpReminder mencari proposal yang di-approve lebih dari SKP_REMINDER_DAYS
+// hari tanpa SKP aktif dan mengirim satu digest per PIC brand. Proposal yang
+// sudah lebih dari SKP_REMINDER_ESCALATE_DAYS hari juga dikirim ke management.
+func RunSkpReminder(dryRun bool) ([]SkpReminderDigest, error) {
+	skpReminderRunMu.Lock()
+	defer skpReminderRunMu.Unlock()
+
+	database := db.GetDB()
+	if database == nil {
+		return nil, fmt.Errorf("database connection not initialized")
+	}
+	if err := ensureProposalWorkflowTables(database); err != nil {
+		return nil, fmt.Errorf("ensure approver table: %w", err)
+	}
+
+	now := time.Now()
+	items, err := loadOverdueSkp(auditSourceJob("skpreminder"), database, now)
+	if err != nil {
+		return nil, err
+	}
+	digests := buildSkpReminderDigests(items, envInt(skpReminderEscalateDaysEnv, defaultSkpReminderEscalateDays), managementNumbers())
+	if dryRun {
+		return digests, nil
+	}
+
+	for i := range digests {
+		d := &digests[i]
+		if d.WANumber == "" {
+			d.Error = "no WhatsApp number"
+			log.Printf("SKP reminder: no WhatsApp number for %s, %d proposal(s) not sent", d.To, len(d.Items))
+			continue
+		}
+		if _, err := sendToWhatsAppAPI(d.WANumber, formatSkpReminder(d, now), ""); err != nil {
+			d.Error = err.Error()
+			log.Printf("SKP reminder: failed to send to %s (%s): %v", d.To, d.WANumber, err)
+			continue
+		}
+		d.Sent = true
+	}
+	return digests, nil
+}
+
+func managementNumbers() []string {
+	var out []string
+	for _, n := range strings.Split(envString(skpReminderManagementEnv, ""), ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// loadOverdueSkp memuat proposal yang sudah approval final beserta PIC brand
+// (levelx <= 1, sama seperti daftar proposal direktur). Umur dihitung dari
+// baris tb_proposal_approved terakhir, yaitu approval final. Nomor WhatsApp
+// PIC diambil dari tb_proposal_approver (sumber kontak yang sama dengan
+// notifikasi workflow); PIC yang bukan approver didaftarkan dengan active = 0.
+func loadOverdueSkp(src sqlAuditSource, database *sql.DB, now time.Time) ([]OverdueSkp, error) {
+	minDays := envInt(skpReminderDaysEnv, defaultSkpReminderDays)
+
+	query := fmt.Sprintf(`
+		SELECT t1.Number, t1.BrandCode, ISNULL(mb.BrandName, ''), ISNULL(tbp.UserCode, ''), ISNULL(tbp.Pic, ''),
+			ISNULL(wa.wa_number, ''), t2.approvedDate,
+			ISNULL(ISNULL(tp1.costing_lama, tp1.TotalCosting), 0)
+		FROM tb_proposal t1
+		INNER JOIN tb_operating_proposal tp1 ON tp1.ProposalNumber = t1.Number
+		CROSS APPLY (
+			SELECT TOP 1 tpa.approvedDate FROM tb_proposal_approved tpa
+			WHERE tpa.ProposalNumber = t1.Number
+			ORDER BY tpa.id DESC
+		) t2
+		LEFT JOIN m_brand mb ON mb.BrandCode = t1.BrandCode
+		LEFT JOIN tb_pic_brand tbp ON tbp.BrandCode = t1.BrandCode AND (tbp.levelx IS NULL OR tbp.levelx <= 1)
+		OUTER APPLY (
+			SELECT TOP 1 ap.wa_number FROM %s ap
+			WHERE ap.username = tbp.UserCode AND ap.wa_number IS NOT NULL AND ap.wa_number != ''
+			ORDER BY ap.active DESC, ap.id
+		) wa
+		WHERE t2.approvedDate IS NOT NULL
+			AND t2.approvedDate < DATEADD(DAY, -@p1, GETDATE())
+			AND t1.StartDatePeriode >= FISCAL_HISTORY_FROM
+			AND LOWER(LTRIM(RTRIM(tp1.status_proposal))) IN (%s)
+			AND %s
+		ORDER BY t2.approvedDate`, proposalApproverTable, skpReminderApprovedStatuses, proposalWithoutActiveSkp)
+	query = loadFiscalCalendar().expandFiscalSQL(query, now)
+
+	var items []OverdueSkp
+	err := auditedQuery(src, database, query, []interface{}{minDays}, func(rows *sql.Rows) error {
+		var o OverdueSkp
+		var brandCode sql.NullString
+		if err := rows.Scan(&o.Number, &brandCode, &o.Brand, &o.PicCode, &o.Pic, &o.WANumber, &o.ApprovedAt, &o.Amount); err != nil {
+			return err
+		}
+		o.BrandCode = brandCode.String
+		o.AgeDays = int(now.Sub(o.ApprovedAt).Hours() / 24)
+		items = append(items, o)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query overdue SKP: %w", err)
+	}
+	return items, nil
+}
+
+// buildSkpReminderDigests mengelompokkan proposal per PIC. Satu proposal bisa
+// punya beberapa PIC (satu baris per PIC). Proposal yang umurnya mencapai
+// escalateDays juga masuk satu digest untuk setiap nomor management.
+func buildSkpReminderDigests(items []OverdueSkp, escalateDays int, management []string) []SkpReminderDigest {
+	byPic := map[string]*SkpReminderDigest{}
+	var order []string
+	escalated := map[string]OverdueSkp{}
+
+	for _, o := range items {
+		o.Escalated = escalateDays > 0 && o.AgeDays >= escalateDays
+		key := o.PicCode
+		if key == "" {
+			key = "(tanpa PIC)"
+		}
+		d, ok := byPic[key]
+		if !ok {
+			d = &SkpReminderDigest{To: valueOr(o.Pic, key), WANumber: o.WANumber}
+			byPic[key] = d
+			order = append(order, key)
+		}
+		d.Items = append(d.Items, o)
+		if o.Escalated {
+			escalated[o.Number] = o
+		}
+	}
+	sort.Strings(order)
+
+	digests := make([]SkpReminderDigest, 0, len(order)+len(management))
+	for _, key := range order {
+		digests = append(digests, *byPic[key])
+	}
+
+	if len(escalated) > 0 {
+		list := make([]OverdueSkp, 0, len(escalated))
+		for _, o := range escalated {
+			list = append(list, o)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].AgeDays > list[j].AgeDays || (list[i].AgeDays == list[j].AgeDays && list[i].Number < list[j].Number)
+		})
+		if len(management) == 0 {
+			digests = append(digests, SkpReminderDigest{To: "management", Escalated: true, Items: list})
+		}
+		for _, n := range management {
+			digests = append(digests, SkpReminderDigest{To: "management", WANumber: n, Escalated: true, Items: list})
+		}
+	}
+	return digests
+}
+
+// formatSkpReminder menyusun pesan WhatsApp satu digest.
+func formatSkpReminder(d *SkpReminderDigest, now time.Time) string {
+	var sb strings.Builder
+	if d.Escalated {
+		sb.WriteString("*ESKALASI: Proposal ANP belum ada SKP*\n")
+		fmt.Fprintf(&sb, "%d proposal sudah lama di-approve tanpa SKP aktif (per %s):\n\n", len(d.Items), now.Format("02/01/2006"))
+	} else {
+		fmt.Fprintf(&sb, "*Reminder SKP - %s*\n", d.To)
+		fmt.Fprintf(&sb, "%d proposal sudah di-approve tapi belum ada SKP:\n\n", len(d.Items))
+	}
+	total := 0.0
+	for i, o := range d.Items {
+		total += o.Amount
+		if i >= skpReminderDigestMaxLines {
+			continue
+		}
+		fmt.Fprintf(&sb, "%d. %s - %s\n   Approve %s (%d hari), Rp %s", i+1, o.Number, valueOr(o.Brand, o.BrandCode),
+			o.ApprovedAt.Format("02/01/2006"), o.AgeDays, formatRupiah(o.Amount))
+		if d.Escalated && o.Pic != "" {
+			fmt.Fprintf(&sb, ", PIC %s", o.Pic)
+		}
+		sb.WriteString("\n")
+	}
+	if n := len(d.Items) - skpReminderDigestMaxLines; n > 0 {
+		fmt.Fprintf(&sb, "...dan %d proposal lainnya\n", n)
+	}
+	fmt.Fprintf(&sb, "\nTotal: Rp %s\nMohon segera upload SKP.", formatRupiah(total))
+	return sb.String()
+}
+
+// formatRupiah memformat angka dengan pemisah ribuan titik (1234567 -> "1.234.567").
+func formatRupiah(v float64) string {
+	neg := v < 0
+	if neg {
+		v = -v
+	}
+	s := fmt.Sprintf("%.0f", v)
+	var out []byte
+	for i := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			out = append(out, '.')
+		}
+		out = append(out, s[i])
+	}
+	if neg {
+		return "-" + string(out)
+	}
+	return string(out)
+}
+
+// SkpReminderHandler: GET menampilkan digest yang akan dikirim (dry run),
+// POST mengirim reminder sekarang.
+func SkpReminderHandler(c *fiber.Ctx) error {
+	dryRun := c.Method() != fiber.MethodPost
+	digests, err := RunSkpReminder(dryRun)
+	if err != nil {
+		log.Printf("Error running SKP reminder: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{Success: false, Message: err.Error()})
+	}
+	return c.JSON(fiber.Map{"success": true, "dry_run": dryRun, "count": len(digests), "digests": digests})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildSkpReminderDigests(t *testing.T) {
+	items := []OverdueSkp{
+		{Number: "P1", PicCode: "U2", Pic: "Budi", WANumber: "6281", AgeDays: 6, Amount: 1000},
+		{Number: "P2", PicCode: "U1", Pic: "Ani", WANumber: "6282", AgeDays: 20, Amount: 2000},
+		{Number: "P2", PicCode: "U2", Pic: "Budi", WANumber: "6281", AgeDays: 20, Amount: 2000},
+		{Number: "P3", AgeDays: 15},
+	}
+	digests := buildSkpReminderDigests(items, 14, []string{"6289"})
+	if len(digests) != 4 {
+		t.Fatalf("got %d digests, want 4: %+v", len(digests), digests)
+	}
+	if digests[0].To != "(tanpa PIC)" || digests[1].To != "Ani" || digests[2].To != "Budi" || len(digests[2].Items) != 2 {
+		t.Errorf("unexpected PIC digests: %+v", digests[:3])
+	}
+	m := digests[3]
+	if !m.Escalated || m.WANumber != "6289" || len(m.Items) != 2 || m.Items[0].Number != "P2" {
+		t.Errorf("unexpected management digest: %+v", m)
+	}
+}
+
+func TestFormatSkpReminder(t *testing.T) {
+	d := &SkpReminderDigest{To: "Ani", Items: []OverdueSkp{
+		{Number: "P1", Brand: "Brand A", ApprovedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local), AgeDays: 7, Amount: 1234567},
+	}}
+	msg := formatSkpReminder(d, time.Now())
+	for _, want := range []string{"Reminder SKP - Ani", "P1 - Brand A", "02/01/2025 (7 hari)", "Rp 1.234.567"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+	if got := formatRupiah(-1000); got != "-1.000" {
+		t.Errorf("formatRupiah(-1000) = %q", got)
+	}
+}
@@ -0,0 +1,428 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+const (
+	sqlAuditTable       = "dbo.tb_sql_audit"
+	sqlAuditQueueSize   = 1024
+	sqlAuditMaxParamLen = 200
+	sqlAuditMaxLimit    = 1000
+)
+
+// sqlAuditRedactedNames lists parameter names (case-insensitive substrings)
+// whose values are never written to the audit table.
+var sqlAuditRedactedNames = []string{
+	"password", "passwd", "pwd", "token", "secret", "signature", "img", "foto",
+}
+
+// sqlAuditRedactedValues catches credential-looking values that arrive as
+// positional parameters, where there is no name to match on.
+var sqlAuditRedactedValues = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^bearer\s+`),
+	regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.`),    // JWT
+	regexp.MustCompile(`^pk\.[A-Za-z0-9_-]{20,}`), // Mapbox token
+}
+
+// sqlAuditSource identifies who ran a statement: the route pattern (or the
+// background job name) and the user the request was made for.
+type sqlAuditSource struct {
+	Route string
+	User  string
+}
+
+// auditSourceFromCtx builds the audit source of an HTTP request.
+func auditSourceFromCtx(c *fiber.Ctx) sqlAuditSource {
+	route := c.Path()
+	if r := c.Route(); r != nil && r.Path != "" {
+		route = r.Path
+	}
+	return sqlAuditSource{Route: c.Method() + " " + route, User: requestUser(c)}
+}
+
+// auditSourceJob builds the audit source of a background job.
+func auditSourceJob(name string) sqlAuditSource {
+	return sqlAuditSource{Route: "job:" + name, User: "system"}
+}
+
+// SQLAuditEntry is one row of the SQL audit trail.
+type SQLAuditEntry struct {
+	ID          int64     `json:"id"`
+	Route       string    `json:"route"`
+	User        string    `json:"user"`
+	Kind        string    `json:"kind"`
+	Fingerprint string    `json:"fingerprint"`
+	SQLText     string    `json:"sql_text"`
+	Params      string    `json:"params"`
+	DurationMs  int64     `json:"duration_ms"`
+	Rows        int64     `json:"rows"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+var (
+	sqlAuditQueue      = make(chan SQLAuditEntry, sqlAuditQueueSize)
+	sqlAuditTableSetup tableSetup
+)
+
+// recordSQLAudit queues an audit entry for a statement that has finished.
+// kind is "query" or "exec"; rows is the number of rows returned or affected.
+// It never blocks the caller: when the queue is full the entry is dropped.
+func recordSQLAudit(src sqlAuditSource, kind, query string, params []interface{}, started time.Time, rows int64, err error) {
+	fingerprint, normalized := sqlFingerprint(query)
+	entry := SQLAuditEntry{
+		Route:       src.Route,
+		User:        src.User,
+		Kind:        kind,
+		Fingerprint: fingerprint,
+		SQLText:     normalized,
+		Params:      redactSQLParams(params),
+		DurationMs:  time.Since(started).Milliseconds(),
+		Rows:        rows,
+		CreatedAt:   time.Now(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	recordSlowQuery(entry, query, params)
+
+	select {
+	case sqlAuditQueue <- entry:
+	default:
+		log.Printf("SQL audit queue full, dropping entry for %s (%s)", entry.Route, entry.Fingerprint)
+	}
+}
+
+// auditedExec runs a statement that does not return rows and records it in
+// the audit trail together with the number of affected rows.
+func auditedExec(src sqlAuditSource, database *sql.DB, query string, args ...interface{}) (sql.Result, error) {
+	started := time.Now()
+	result, err := database.Exec(query, args...)
+	var affected int64 = -1
+	if err == nil {
+		if n, rowsErr := result.RowsAffected(); rowsErr == nil {
+			affected = n
+		}
+	}
+	recordSQLAudit(src, "exec", query, args, started, affected, err)
+	return result, err
+}
+
+// auditedTxExec is auditedExec for statements running inside a transaction.
+func auditedTxExec(src sqlAuditSource, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	started := time.Now()
+	result, err := tx.Exec(query, args...)
+	var affected int64 = -1
+	if err == nil {
+		if n, rowsErr := result.RowsAffected(); rowsErr == nil {
+			affected = n
+		}
+	}
+	recordSQLAudit(src, "exec", query, args, started, affected, err)
+	return result, err
+}
+
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// auditedQuery runs a query, calls scan once per row and records the
+// statement with the number of rows scanned.
+func auditedQuery(src sqlAuditSource, database sqlQueryer, query string, args []interface{}, scan func(*sql.Rows) error) (err error) {
+	started := time.Now()
+	var n int64
+	defer func() { recordSQLAudit(src, "query", query, args, started, n, err) }()
+
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+		n++
+	}
+	err = rows.Err()
+	return err
+}
+
+// StartSQLAuditWriter drains the audit queue into the audit table.
+// It should run as a background goroutine after db.Connect().
+func StartSQLAuditWriter() {
+	if db.DB == nil {
+		log.Println("Database connection not initialized. SQL audit writer not started.")
+		return
+	}
+	if err := ensureSQLAuditTable(db.DB); err != nil {
+		log.Printf("SQL audit writer not started: %v", err)
+		return
+	}
+
+	insertSQL := fmt.Sprintf(`INSERT INTO %s
+		(route, username, kind, fingerprint, sql_text, params, duration_ms, rows_count, error, created_at)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9, @p10)`, sqlAuditTable)
+
+	for entry := range sqlAuditQueue {
+		var errText interface{}
+		if entry.Error != "" {
+			errText = entry.Error
+		}
+		_, err := db.DB.Exec(insertSQL,
+			entry.Route, entry.User, entry.Kind, entry.Fingerprint, entry.SQLText, entry.Params,
+			entry.DurationMs, entry.Rows, errText, entry.CreatedAt)
+		if err != nil {
+			log.Printf("Error writing SQL audit entry for %s: %v", entry.Route, err)
+		}
+	}
+}
+
+// ensureSQLAuditTable creates the audit table the first time it is needed.
+func ensureSQLAuditTable(database *sql.DB) error {
+	return sqlAuditTableSetup.ensure(func() error {
+		_, err := database.Exec(fmt.Sprintf(`
+			IF OBJECT_ID('%[1]s', 'U') IS NULL
+			CREATE TABLE %[1]s (
+				id BIGINT IDENTITY(1,1) PRIMARY KEY,
+				route NVARCHAR(200) NOT NULL,
+				username NVARCHAR(100) NULL,
+				kind VARCHAR(10) NOT NULL,
+				fingerprint CHAR(16) NOT NULL,
+				sql_text NVARCHAR(MAX) NOT NULL,
+				params NVARCHAR(MAX) NULL,
+				duration_ms BIGINT NOT NULL,
+				rows_count BIGINT NOT NULL,
+				error NVARCHAR(MAX) NULL,
+				created_at DATETIME2 NOT NULL,
+				INDEX ix_tb_sql_audit_created (created_at),
+				INDEX ix_tb_sql_audit_fingerprint (fingerprint)
+			)`, sqlAuditTable))
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", sqlAuditTable, err)
+		}
+		return nil
+	})
+}
+
+var (
+	sqlPlaceholderRe     = regexp.MustCompile(`(?i)@p\d+`)
+	sqlPlaceholderListRe = regexp.MustCompile(`\?(\s*,\s*\?)+`)
+)
+
+// sqlFingerprint normalizes a statement so that executions differing only in
+// literal values, placeholder numbering or whitespace group together. It
+// returns a short hash of the normalized text and the text itself.
+func sqlFingerprint(query string) (string, string) {
+	var sb strings.Builder
+	runes := []rune(query)
+	prevWord := false // previous rune belongs to an identifier or keyword
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			sb.WriteRune(' ')
+			prevWord = false
+		case r == '[':
+			for i < len(runes) && runes[i] != ']' {
+				sb.WriteRune(runes[i])
+				i++
+			}
+			sb.WriteRune(']')
+			prevWord = true
+		case r == '\'':
+			i++
+			for i < len(runes) {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			sb.WriteRune('?')
+			prevWord = false
+		case r >= '0' && r <= '9' && !prevWord:
+			for i+1 < len(runes) && (runes[i+1] >= '0' && runes[i+1] <= '9' || runes[i+1] == '.') {
+				i++
+			}
+			sb.WriteRune('?')
+			prevWord = false
+		default:
+			sb.WriteRune(r)
+			prevWord = r == '_' || r == '@' || r == '#' || r == '$' ||
+				(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+		}
+	}
+
+	normalized := sqlPlaceholderRe.ReplaceAllString(sb.String(), "?")
+	normalized = strings.Join(strings.Fields(normalized), " ")
+	normalized = sqlPlaceholderListRe.ReplaceAllString(normalized, "?, ...")
+
+	sum := sha256.Sum256([]byte(strings.ToLower(normalized)))
+	return hex.EncodeToString(sum[:])[:16], normalized
+}
+
+// redactSQLParams renders bound parameters as JSON for the audit table,
+// applying the redaction rules and truncating long values.
+func redactSQLParams(params []interface{}) string {
+	rendered := make([]string, 0, len(params))
+	for _, p := range params {
+		rendered = append(rendered, redactSQLParam(p))
+	}
+	data, err := json.Marshal(rendered)
+	if err != nil {
+		return "[]"
+	}
+	return string(data)
+}
+
+func redactSQLParam(p interface{}) string {
+	switch v := p.(type) {
+	case sql.NamedArg:
+		if isRedactedParamName(v.Name) {
+			return "@" + v.Name + "=[REDACTED]"
+		}
+		return "@" + v.Name + "=" + redactSQLParam(v.Value)
+	case nil:
+		return "NULL"
+	case []byte:
+		return fmt.Sprintf("[%d bytes]", len(v))
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case string:
+		// Parameters taken from the HTTP request arrive as "key=value".
+		if key, _, ok := strings.Cut(v, "="); ok && isRedactedParamName(key) {
+			return key + "=[REDACTED]"
+		}
+		for _, re := range sqlAuditRedactedValues {
+			if re.MatchString(v) {
+				return "[REDACTED]"
+			}
+		}
+		if len(v) > sqlAuditMaxParamLen {
+			return v[:sqlAuditMaxParamLen] + "…(" + strconv.Itoa(len(v)) + " chars)"
+		}
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func isRedactedParamName(name string) bool {
+	name = strings.ToLower(name)
+	for _, rule := range sqlAuditRedactedNames {
+		if strings.Contains(name, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// SQLAuditHandler returns audit entries, newest first. Supported filters:
+// route, user, fingerprint, kind, from, to (YYYY-MM-DD), errors=1, limit.
+func SQLAuditHandler(c *fiber.Ctx) error {
+	if db.DB == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: "Database connection not available.",
+		})
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "100"))
+	if limit <= 0 || limit > sqlAuditMaxLimit {
+		limit = sqlAuditMaxLimit
+	}
+
+	var conditions []string
+	var params []interface{}
+	paramIndex := 1
+
+	addCondition := func(format string, value interface{}) {
+		conditions = append(conditions, fmt.Sprintf(format, paramIndex))
+		params = append(params, value)
+		paramIndex++
+	}
+
+	if route := c.Query("route"); route != "" {
+		addCondition("route LIKE @p%d", "%"+route+"%")
+	}
+	if user := c.Query("user"); user != "" {
+		addCondition("username = @p%d", user)
+	}
+	if fingerprint := c.Query("fingerprint"); fingerprint != "" {
+		addCondition("fingerprint = @p%d", fingerprint)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		addCondition("kind = @p%d", kind)
+	}
+	if from := c.Query("from"); from != "" {
+		addCondition("created_at >= CONVERT(DATE, @p%d)", from)
+	}
+	if to := c.Query("to"); to != "" {
+		addCondition("created_at < DATEADD(DAY, 1, CONVERT(DATE, @p%d))", to)
+	}
+	if c.Query("errors") == "1" {
+		conditions = append(conditions, "error IS NOT NULL")
+	}
+
+	query := fmt.Sprintf(`SELECT TOP (%d) id, route, ISNULL(username, ''), kind, fingerprint, sql_text,
+		ISNULL(params, ''), duration_ms, rows_count, ISNULL(error, ''), created_at
+		FROM %s`, limit, sqlAuditTable)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	rows, err := db.DB.Query(query, params...)
+	if err != nil {
+		log.Printf("Error querying SQL audit trail: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read audit trail: %v", err),
+		})
+	}
+	defer rows.Close()
+
+	entries := []SQLAuditEntry{}
+	for rows.Next() {
+		var e SQLAuditEntry
+		if err := rows.Scan(&e.ID, &e.Route, &e.User, &e.Kind, &e.Fingerprint, &e.SQLText,
+			&e.Params, &e.DurationMs, &e.Rows, &e.Error, &e.CreatedAt); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(Response{
+				Success: false,
+				Message: fmt.Sprintf("Failed to read audit row: %v", err),
+			})
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read audit trail: %v", err),
+		})
+	}
+
+	return c.JSON(entries)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestSQLFingerprintGroupsLiterals(t *testing.T) {
+	a, normA := sqlFingerprint("SELECT * FROM tb_proposal WHERE Number = 'P-001' AND id > 10")
+	b, _ := sqlFingerprint("select *  from tb_proposal\nwhere Number = 'P-002' and id > 25 -- komentar")
+	if a != b {
+		t.Fatalf("expected equal fingerprints, got %s and %s (%s)", a, b, normA)
+	}
+	if strings.Contains(normA, "P-001") || strings.Contains(normA, "10") {
+		t.Fatalf("literals not stripped: %s", normA)
+	}
+
+	c, normC := sqlFingerprint("SELECT x FROM [pksrv-sap].[pandurasa_live].dbo.ODLN t6 WHERE t6.MANIFEST# IN (@P1,@P2,@P3)")
+	d, _ := sqlFingerprint("SELECT x FROM [pksrv-sap].[pandurasa_live].dbo.ODLN t6 WHERE t6.MANIFEST# IN (@P4, @P5)")
+	if c != d {
+		t.Fatalf("placeholder lists should collapse: %s", normC)
+	}
+	if !strings.Contains(normC, "[pksrv-sap]") || !strings.Contains(normC, "t6") {
+		t.Fatalf("identifiers must be kept: %s", normC)
+	}
+}
+
+func TestRedactSQLParams(t *testing.T) {
+	got := redactSQLParams([]interface{}{
+		sql.Named("doc_id", "PO-1"),
+		sql.Named("password", "rahasia"),
+		"token=abc",
+		"brand=105",
+		"Bearer xyz",
+		[]byte("gambar"),
+		nil,
+	})
+	for _, leaked := range []string{"rahasia", "abc", "xyz", "gambar"} {
+		if strings.Contains(got, leaked) {
+			t.Fatalf("value %q leaked into %s", leaked, got)
+		}
+	}
+	for _, kept := range []string{"@doc_id=PO-1", "brand=105", "NULL"} {
+		if !strings.Contains(got, kept) {
+			t.Fatalf("expected %q in %s", kept, got)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"my-fiber-app/db"
+)
+
+const (
+	slowQueryTable        = "dbo.tb_sql_slow_query"
+	slowQueryQueueSize    = 256
+	slowQueryPlanInterval = 1 * time.Hour
+	slowQueryPlanTimeout  = 30 * time.Second
+)
+
+var (
+	// slowQueryThreshold is the duration from which a statement is recorded
+	// as slow. Override with SLOW_QUERY_THRESHOLD_MS.
+	slowQueryThreshold = time.Duration(envInt("SLOW_QUERY_THRESHOLD_MS", 3000)) * time.Millisecond
+	// slowQueryCapturePlan enables fetching the estimated plan of slow
+	// SELECTs. Override with SLOW_QUERY_CAPTURE_PLAN.
+	slowQueryCapturePlan = envBool("SLOW_QUERY_CAPTURE_PLAN", true)
+)
+
+// slowQuery is a slow statement waiting to be persisted. args holds the
+// original (unredacted) parameters so the plan can be estimated with the
+// same values; they never leave the process.
+type slowQuery struct {
+	entry SQLAuditEntry
+	query string
+	args  []interface{}
+}
+
+// SlowQueryEntry is one recorded slow execution.
+type SlowQueryEntry struct {
+	ID          int64     `json:"id"`
+	Route       string    `json:"route"`
+	User        string    `json:"user"`
+	Fingerprint string    `json:"fingerprint"`
+	SQLText     string    `json:"sql_text"`
+	Params      string    `json:"params"`
+	DurationMs  int64     `json:"duration_ms"`
+	Rows        int64     `json:"rows"`
+	HasPlan     bool      `json:"has_plan"`
+	PlanXML     string    `json:"plan_xml,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SlowQueryReportRow aggregates slow executions of one fingerprint.
+type SlowQueryReportRow struct {
+	Fingerprint   string    `json:"fingerprint"`
+	SQLText       string    `json:"sql_text"`
+	Route         string    `json:"route"`
+	Executions    int64     `json:"executions"`
+	AvgDurationMs int64     `json:"avg_duration_ms"`
+	MaxDurationMs int64     `json:"max_duration_ms"`
+	LastSeen      time.Time `json:"last_seen"`
+	LatestID      int64     `json:"latest_id"`
+	PlanID        int64     `json:"plan_id,omitempty"`
+}
+
+var (
+	slowQueryQueue      = make(chan slowQuery, slowQueryQueueSize)
+	slowQueryTableSetup tableSetup
+
+	slowQueryPlanMu   sync.Mutex
+	slowQueryPlanSeen = map[string]time.Time{}
+)
+
+// recordSlowQuery queues entry when it crossed the slow query threshold.
+func recordSlowQuery(entry SQLAuditEntry, query string, args []interface{}) {
+	if time.Duration(entry.DurationMs)*time.Millisecond < slowQueryThreshold {
+		return
+	}
+	log.Printf("Slow query (%d ms) on %s: %s", entry.DurationMs, entry.Route, entry.Fingerprint)
+
+	select {
+	case slowQueryQueue <- slowQuery{entry: entry, query: query, args: args}:
+	default:
+		log.Printf("Slow query queue full, dropping %s", entry.Fingerprint)
+	}
+}
+
+// shouldCapturePlan limits plan capture to once per fingerprint per interval,
+// since estimating a plan on the linked servers is itself expensive.
+func shouldCapturePlan(fingerprint string) bool {
+	slowQueryPlanMu.Lock()
+	defer slowQueryPlanMu.Unlock()
+	if last, ok := slowQueryPlanSeen[fingerprint]; ok && time.Since(last) < slowQueryPlanInterval {
+		return false
+	}
+	slowQueryPlanSeen[fingerprint] = time.Now()
+	return true
+}
+
+// StartSlowQueryRecorder persists slow statements, fetching their estimated
+// plan when enabled. It should run as a background goroutine after db.Connect().
+func StartSlowQueryRecorder() {
+	if db.DB == nil {
+		log.Println("Database connection not initialized. Slow query recorder not started.")
+		return
+	}
+	if err := ensureSlowQueryTable(db.DB); err != nil {
+		log.Printf("Slow query recorder not started: %v", err)
+		return
+	}
+
+	insertSQL := fmt.Sprintf(`INSERT INTO %s
+		(route, username, fingerprint, sql_text, params, duration_ms, rows_count, plan_xml, created_at)
+		VALUES (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9)`, slowQueryTable)
+
+	for sq := range slowQueryQueue {
+		var plan interface{}
+		if slowQueryCapturePlan && sq.entry.Kind == "query" && sq.entry.Error == "" && shouldCapturePlan(sq.entry.Fingerprint) {
+			xml, err := fetchEstimatedPlan(sq.query, sq.args...)
+			if err != nil {
+				log.Printf("Error capturing plan for %s: %v", sq.entry.Fingerprint, err)
+			} else {
+				plan = xml
+			}
+		}
+
+		e := sq.entry
+		if _, err := db.DB.Exec(insertSQL, e.Route, e.User, e.Fingerprint, e.SQLText, e.Params,
+			e.DurationMs, e.Rows, plan, e.CreatedAt); err != nil {
+			log.Printf("Error writing slow query %s: %v", e.Fingerprint, err)
+		}
+	}
+}
+
+// ensureSlowQueryTable creates the slow query table the first time it is needed.
+func ensureSlowQueryTable(database *sql.DB) error {
+	return slowQueryTableSetup.ensure(func() error {
+		_, err := database.Exec(fmt.Sprintf(`
+			IF OBJECT_ID('%[1]s', 'U') IS NULL
+			CREATE TABLE %[1]s (
+				id BIGINT IDENTITY(1,1) PRIMARY KEY,
+				route NVARCHAR(200) NOT NULL,
+				username NVARCHAR(100) NULL,
+				fingerprint CHAR(16) NOT NULL,
+				sql_text NVARCHAR(MAX) NOT NULL,
+				params NVARCHAR(MAX) NULL,
+				duration_ms BIGINT NOT NULL,
+				rows_count BIGINT NOT NULL,
+				plan_xml NVARCHAR(MAX) NULL,
+				created_at DATETIME2 NOT NULL,
+				INDEX ix_tb_sql_slow_query_created (created_at),
+				INDEX ix_tb_sql_slow_query_fingerprint (fingerprint)
+			)`, slowQueryTable))
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", slowQueryTable, err)
+		}
+		return nil
+	})
+}
+
+// fetchEstimatedPlan returns the estimated execution plan of query as
+// showplan XML. The statement is compiled but not executed.
+func fetchEstimatedPlan(query string, args ...interface{}) (string, error) {
+	if db.DB == nil {
+		return "", fmt.Errorf("database connection not initialized")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), slowQueryPlanTimeout)
+	defer cancel()
+
+	// SHOWPLAN is a session setting, so everything must run on one connection.
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SET SHOWPLAN_XML ON"); err != nil {
+		return "", fmt.Errorf("failed to enable SHOWPLAN_XML: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SET SHOWPLAN_XML OFF"); err != nil {
+			// Never hand a connection stuck in showplan mode back to the pool.
+			log.Printf("Failed to disable SHOWPLAN_XML, discarding connection: %v", err)
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to estimate plan: %w", err)
+	}
+	defer rows.Close()
+
+	var sb strings.Builder
+	for {
+		for rows.Next() {
+			var part sql.NullString
+			if err := rows.Scan(&part); err != nil {
+				return "", fmt.Errorf("failed to read plan: %w", err)
+			}
+			sb.WriteString(part.String)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to read plan: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// SlowQueryReportHandler returns the top-N slowest fingerprints.
+// Query params: top (default 20), days (default 7), route, order=max|avg|count.
+func SlowQueryReportHandler(c *fiber.Ctx) error {
+	if db.DB == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: "Database connection not available.",
+		})
+	}
+
+	top, _ := strconv.Atoi(c.Query("top", "20"))
+	if top <= 0 || top > 500 {
+		top = 20
+	}
+	days, _ := strconv.Atoi(c.Query("days", "7"))
+	if days <= 0 {
+		days = 7
+	}
+
+	orderBy := "a.max_ms DESC"
+	switch c.Query("order") {
+	case "avg":
+		orderBy = "a.avg_ms DESC"
+	case "count":
+		orderBy = "a.executions DESC"
+	}
+
+	params := []interface{}{days}
+	routeFilter := ""
+	if route := c.Query("route"); route != "" {
+		routeFilter = " AND route LIKE @p2"
+		params = append(params, "%"+route+"%")
+	}
+
+	query := fmt.Sprintf(`
+		WITH agg AS (
+			SELECT
+				fingerprint,
+				COUNT(*) AS executions,
+				AVG(duration_ms) AS avg_ms,
+				MAX(duration_ms) AS max_ms,
+				MAX(created_at) AS last_seen,
+				MAX(id) AS latest_id,
+				ISNULL(MAX(CASE WHEN plan_xml IS NOT NULL THEN id END), 0) AS plan_id
+			FROM %[2]s
+			WHERE created_at >= DATEADD(DAY, -@p1, SYSDATETIME())%[3]s
+			GROUP BY fingerprint
+		)
+		SELECT TOP (%[1]d)
+			a.fingerprint, s.sql_text, s.route, a.executions, a.avg_ms, a.max_ms, a.last_seen, a.latest_id, a.plan_id
+		FROM agg a
+		INNER JOIN %[2]s s ON s.id = a.latest_id
+		ORDER BY %[4]s`, top, slowQueryTable, routeFilter, orderBy)
+
+	rows, err := db.DB.Query(query, params...)
+	if err != nil {
+		log.Printf("Error building slow query report: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to build slow query report: %v", err),
+		})
+	}
+	defer rows.Close()
+
+	report := []SlowQueryReportRow{}
+	for rows.Next() {
+		var r SlowQueryReportRow
+		if err := rows.Scan(&r.Fingerprint, &r.SQLText, &r.Route, &r.Executions, &r.AvgDurationMs, &r.MaxDurationMs,
+			&r.LastSeen, &r.LatestID, &r.PlanID); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(Response{
+				Success: false,
+				Message: fmt.Sprintf("Failed to read slow query report: %v", err),
+			})
+		}
+		report = append(report, r)
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read slow query report: %v", err),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"threshold_ms": slowQueryThreshold.Milliseconds(),
+		"days":         days,
+		"data":         report,
+	})
+}
+
+// SlowQueryDetailHandler returns one slow execution including its plan.
+// With ?format=xml the plan is sent as a .sqlplan document for SSMS.
+func SlowQueryDetailHandler(c *fiber.Ctx) error {
+	if db.DB == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: "Database connection not available.",
+		})
+	}
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(Response{Success: false, Message: "Invalid id."})
+	}
+
+	var e SlowQueryEntry
+	var plan sql.NullString
+	err = db.DB.QueryRow(fmt.Sprintf(`SELECT id, route, ISNULL(username, ''), fingerprint, sql_text,
+		ISNULL(params, ''), duration_ms, rows_count, plan_xml, created_at
+		FROM %s WHERE id = @p1`, slowQueryTable), id).Scan(
+		&e.ID, &e.Route, &e.User, &e.Fingerprint, &e.SQLText, &e.Params, &e.DurationMs, &e.Rows, &plan, &e.CreatedAt)
+	if err == sql.ErrNoRows {
+		return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: "Slow query not found."})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(Response{
+			Success: false,
+			Message: fmt.Sprintf("Failed to read slow query: %v", err),
+		})
+	}
+	e.HasPlan = plan.Valid && plan.String != ""
+	e.PlanXML = plan.String
+
+	if c.Query("format") == "xml" {
+		if !e.HasPlan {
+			return c.Status(fiber.StatusNotFound).JSON(Response{Success: false, Message: "No plan captured for this query."})
+		}
+		c.Set("Content-Type", "application/xml; charset=utf-8")
+		c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="slowquery_%d.sqlplan"`, e.ID))
+		return c.SendString(e.PlanXML)
+	}
+	return c.JSON(e)
+}
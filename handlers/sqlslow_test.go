@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// drainSlowQueries mengosongkan antrean slow query dan mengembalikan isinya.
+func drainSlowQueries() []slowQuery {
+	var out []slowQuery
+	for {
+		select {
+		case sq := <-slowQueryQueue:
+			out = append(out, sq)
+		default:
+			return out
+		}
+	}
+}
+
+func TestRecordSlowQueryThreshold(t *testing.T) {
+	old := slowQueryThreshold
+	slowQueryThreshold = 500 * time.Millisecond
+	defer func() { slowQueryThreshold = old }()
+	drainSlowQueries()
+
+	fast := SQLAuditEntry{Kind: "query", Fingerprint: "fast", DurationMs: 499}
+	recordSlowQuery(fast, "SELECT 1", nil)
+	if got := drainSlowQueries(); len(got) != 0 {
+		t.Fatalf("query below threshold was queued: %+v", got)
+	}
+
+	slow := SQLAuditEntry{Kind: "query", Fingerprint: "slow", DurationMs: 500}
+	recordSlowQuery(slow, "SELECT * FROM tb_proposal WHERE Number = @p1", []interface{}{"P-001"})
+	got := drainSlowQueries()
+	if len(got) != 1 {
+		t.Fatalf("expected 1 queued slow query, got %d", len(got))
+	}
+	if got[0].entry.Fingerprint != "slow" || got[0].args[0] != "P-001" {
+		t.Fatalf("unexpected queued slow query %+v", got[0])
+	}
+}
+
+func TestRecordSlowQueryDropsWhenQueueFull(t *testing.T) {
+	old := slowQueryThreshold
+	slowQueryThreshold = 0
+	defer func() { slowQueryThreshold = old }()
+	drainSlowQueries()
+	defer drainSlowQueries()
+
+	for i := 0; i < slowQueryQueueSize+10; i++ {
+		recordSlowQuery(SQLAuditEntry{Kind: "query", Fingerprint: "flood"}, "SELECT 1", nil)
+	}
+	if n := len(slowQueryQueue); n != slowQueryQueueSize {
+		t.Fatalf("expected queue capped at %d, got %d", slowQueryQueueSize, n)
+	}
+}
+
+func TestShouldCapturePlanOncePerFingerprint(t *testing.T) {
+	a, _ := sqlFingerprint("SELECT * FROM tb_proposal WHERE Number = 'P-001'")
+	b, _ := sqlFingerprint("SELECT * FROM tb_proposal WHERE Number = 'P-002'")
+	other, _ := sqlFingerprint("SELECT * FROM tb_proposal_skp WHERE NoSKP = 'S-1'")
+
+	slowQueryPlanMu.Lock()
+	delete(slowQueryPlanSeen, a)
+	delete(slowQueryPlanSeen, other)
+	slowQueryPlanMu.Unlock()
+
+	if !shouldCapturePlan(a) {
+		t.Fatal("first slow execution should capture a plan")
+	}
+	if shouldCapturePlan(b) {
+		t.Fatal("same fingerprint with other literals should not capture again within the interval")
+	}
+	if !shouldCapturePlan(other) {
+		t.Fatal("a different fingerprint should capture its own plan")
+	}
+
+	// Setelah interval lewat, plan boleh diambil lagi.
+	slowQueryPlanMu.Lock()
+	slowQueryPlanSeen[a] = time.Now().Add(-slowQueryPlanInterval - time.Second)
+	slowQueryPlanMu.Unlock()
+	if !shouldCapturePlan(a) {
+		t.Fatal("plan should be captured again after the interval")
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import "sync"
+
+// tableSetup menjalankan DDL "create table if not exists" sekali sampai
+// berhasil. Berbeda dengan sync.Once, error tidak disimpan: request
+// berikutnya mencoba lagi (mis. setelah database sempat tidak tersedia).
+type tableSetup struct {
+	mu   sync.Mutex
+	done bool
+}
+
+func (s *tableSetup) ensure(create func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return nil
+	}
+	if err := create(); err != nil {
+		return err
+	}
+	s.done = true
+	return nil
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTableSetupRetriesAfterError(t *testing.T) {
+	var s tableSetup
+	calls := 0
+	create := func() error {
+		calls++
+		if calls == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}
+	if err := s.ensure(create); err == nil {
+		t.Fatal("expected the first error")
+	}
+	if err := s.ensure(create); err != nil {
+		t.Fatalf("second attempt: %v", err)
+	}
+	if err := s.ensure(create); err != nil || calls != 2 {
+		t.Fatalf("expected no further attempts after success, calls=%d err=%v", calls, err)
+	}
+}
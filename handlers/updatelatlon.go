@@ -176,7 +176,7 @@ func getCoordinatesFromMapbox(address string) (float64, float64, error) {
 func updateCustomerCoordinates(dbConn *sql.DB, customerID int, lat, lon float64) error {
 	// Query still uses '@p1', '@p2', '@p3' for SQL Server compatibility.
 	query := `UPDATE [pksrv-sap].pk_express.dbo.master_customer SET lat = @p1, lon = @p2 WHERE id = @p3;`
-	_, err := dbConn.Exec(query, lat, lon, customerID) // Execute the update query
+	_, err := auditedExec(auditSourceJob("latlon"), dbConn, query, lat, lon, customerID) // Execute the update query
 	if err != nil {
 		return fmt.Errorf("error updating customer ID %d: %w", customerID, err)
 	}
@@ -19,14 +19,18 @@ func main() {
 	engine := html.New("./templates", ".html")
 	app := fiber.New(fiber.Config{
 		Views: engine,
+		// Batas server harus menampung unggahan bukti kirim (foto + tanda tangan);
+		// rute lain tetap dibatasi default 4MB oleh LimitBody di bawah.
+		BodyLimit: handlers.PODBodyLimit(),
 	})
+	app.Use(handlers.LimitBody(fiber.DefaultBodyLimit, handlers.IsPODUpload))
 
 	// Tambahkan middleware CORS di sini
 	// Ini akan mengizinkan permintaan dari origin manapun (*).
 	// Untuk produksi, Anda sebaiknya membatasi ini ke origin frontend Anda yang sebenarnya.
 	app.Use(cors.New(cors.Config{
 		AllowOrigins: "*", // Ganti dengan origin frontend Anda, contoh: "http://192.168.60.19:4245:3000"
-		AllowHeaders: "Origin, Content-Type, Accept",
+		AllowHeaders: "Origin, Content-Type, Accept, Authorization, X-User-Code, X-User-Token, X-Admin-Token, X-Explain",
 	}))
 
 	// Middleware untuk menyajikan file statis dari direktori 'assets'
@@ -46,10 +50,15 @@ func main() {
 	// Pastikan koneksi database ditutup saat aplikasi berhenti
 	defer db.CloseDB()
 
+	go handlers.StartSQLAuditWriter()
+	go handlers.StartSlowQueryRecorder()
+	go handlers.StartManifestFeedPoller()
+	go handlers.StartDeliverySLAJob()
 	// go handlers.StartLatLonUpdater()
 	// go handlers.StartJarakUpdater()
 	go handlers.Cekplat()
-	go handlers.RunDeleteSkpTask()
+	go handlers.StartSkpCleanupJob()
+	go handlers.StartSkpReminderJob()
 	go handlers.KirimUltah()
 	go handlers.KirimKontrak()
 	go handlers.StartDailyExcelToJsonUpdater()
@@ -73,9 +82,18 @@ func main() {
 	
 	app.Get("/anp/apixl", handlers.AnpApiXLHandler)
 	app.Get("/anp/budgetsisa", handlers.AnpBudgetSisaHandler)
+	app.Get("/anp/fiscal", handlers.FiscalCalendarHandler)
+	app.Get("/anp/budget/balance", handlers.BudgetBalanceHandler)
+	app.Get("/anp/budget/forecast", handlers.BudgetForecastHandler)
+	app.Get("/anp/reconciliation", handlers.AnpReconciliationHandler)
 	app.Get("/anp/loadtabelproposal", handlers.Loadtabelproposal)
 	app.Get("/anp/dirloadtableproposal", handlers.Dirloadtableproposal)
 	app.Get("/anp/kamloadtableproposal", handlers.Kamloadproposal)
+	app.Post("/anp/proposal", handlers.RequireUser, handlers.CreateProposalHandler)
+	app.Get("/anp/proposal/:number", handlers.ProposalDetailHandler)
+	app.Put("/anp/proposal/:number", handlers.RequireUser, handlers.UpdateProposalHandler)
+	app.Post("/anp/proposal/:number/workflow", handlers.RequireUser, handlers.ProposalWorkflowHandler)
+	app.Get("/anp/proposal/:number/history", handlers.ProposalHistoryHandler)
 	
 	
 	app.Get("/hr/SendWaJs2", handlers.SendWaJs2)
@@ -99,10 +117,32 @@ func main() {
 	// Rute untuk DataTables server-side (POST request)
 	// Menggunakan AjaxManifesHandler dari package handlers
 	app.Get("/pkexpress/ajaxmanifes", handlers.AjaxManifesHandler)
+	app.Get("/pkexpress/manifes/export", handlers.ExportManifesXLSHandler) // harus sebelum /:no
+	app.Get("/pkexpress/manifes/kpi", handlers.DeliveryKPIHandler)
+	app.Get("/pkexpress/manifes/feed", handlers.ManifestFeedHandler)
+	app.Get("/pkexpress/manifes/:no", handlers.ManifesDetailHandler)
+	app.Post("/pkexpress/manifes/:no/sj/:sj/pod", handlers.RequireUser, handlers.LimitBody(handlers.PODBodyLimit(), nil), handlers.ManifesPODHandler)
+	app.Get("/pkexpress/driver/deliveries", handlers.RequireUser, handlers.DriverDeliveriesHandler)
+	app.Get("/pkexpress/pod/image", handlers.PODImageHandler)
 	app.Get("/pkexpress/jarak", handlers.KonversijarakHandler)
 	
 	app.Get("/pkexpress/konversialamat", handlers.MapboxGeocodeHandler)
 	
+	// Rute khusus admin (wajib header X-Admin-Token)
+	admin := app.Group("/admin", handlers.RequireAdmin)
+	admin.Post("/usertoken", handlers.IssueUserTokenHandler)
+	admin.Get("/sqlaudit", handlers.SQLAuditHandler)
+	admin.Get("/slowqueries", handlers.SlowQueryReportHandler)
+	admin.Get("/slowqueries/:id", handlers.SlowQueryDetailHandler)
+	admin.Post("/manifes/cache/invalidate", handlers.InvalidateManifestCacheHandler)
+	admin.Get("/delivery/sla-breaches", handlers.DeliverySLABreachesHandler)
+	admin.Post("/delivery/sla-breaches", handlers.DeliverySLABreachesHandler)
+	admin.Get("/skp/cleanup", handlers.SkpCleanupHandler)
+	admin.Post("/skp/cleanup", handlers.SkpCleanupHandler)
+	admin.Get("/skp/cleanup/history", handlers.SkpCleanupHistoryHandler)
+	admin.Get("/skp/reminder", handlers.SkpReminderHandler)
+	admin.Post("/skp/reminder", handlers.SkpReminderHandler)
+
 
 	// Rute untuk menyajikan file HTML dinamis dari direktori 'templates'
 	app.Get("/wms/:filename", func(c *fiber.Ctx) error {